@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ability is a passive effect of a Pokémon in battle. Every hook is optional; the engine calls
+// them at fixed points of a turn. Abilities without an entry in abilities have no effect.
+type Ability struct {
+	Name string
+	// immuneTypes are the attack types that deal no damage to the Pokémon.
+	immuneTypes []string
+	// onSwitchIn runs when the Pokémon enters the battle, at the start or after a faint.
+	onSwitchIn func(b *Battle, side int, c *combatant)
+	// onDamageTaken runs after an attack dealt damage to the Pokémon.
+	onDamageTaken func(b *Battle, side int, c, attacker *combatant, move Move, damage int)
+	// modifyDamage changes the damage of the Pokémon's own attacks of the given type.
+	modifyDamage func(c *combatant, attackType string, damage int) int
+	// endOfTurn runs at the end of every turn the Pokémon is still battling.
+	endOfTurn func(b *Battle, side int, c *combatant)
+}
+
+// Tuning of the abilities.
+const (
+	pinchBoost      = 1.5     // Damage factor of Blaze, Torrent and Overgrow
+	pinchHPFraction = 3       // Blaze and co. activate at or below 1/pinchHPFraction of max HP
+	staticChance    = 0.3     // Chance of Static paralyzing a Pokémon that hits it physically
+	shedSkinChance  = 1.0 / 3 // Chance of Shed Skin curing a status at the end of a turn
+)
+
+// abilities are the abilities with an effect in battle, keyed by their name in the Pokédex.
+var abilities = map[string]*Ability{
+	"levitate":   {Name: "Levitate", immuneTypes: []string{"ground"}},
+	"blaze":      {Name: "Blaze", modifyDamage: pinchBoostFor("fire")},
+	"torrent":    {Name: "Torrent", modifyDamage: pinchBoostFor("water")},
+	"overgrow":   {Name: "Overgrow", modifyDamage: pinchBoostFor("grass")},
+	"intimidate": {Name: "Intimidate", onSwitchIn: intimidate},
+	"static":     {Name: "Static", onDamageTaken: static},
+	"shed-skin":  {Name: "Shed Skin", endOfTurn: shedSkin},
+}
+
+// pinchBoostFor returns a damage modifier that powers up attacks of one type while the Pokémon
+// has a third of its HP or less.
+func pinchBoostFor(boosted string) func(c *combatant, attackType string, damage int) int {
+	return func(c *combatant, attackType string, damage int) int {
+		if attackType != boosted || c.hp*pinchHPFraction > c.maxHP {
+			return damage
+		}
+		return int(float64(damage) * pinchBoost)
+	}
+}
+
+// intimidate lowers the Attack of the opposing Pokémon.
+func intimidate(b *Battle, side int, c *combatant) {
+	other := b.sides[1-side]
+	target := other.current()
+	if target.fainted() {
+		return
+	}
+	b.emitAbility(side, c, "Intimidate", fmt.Sprintf("%s's Intimidate cuts %s's Attack!", b.sides[side].label(c), other.label(target)))
+	b.changeStage(1-side, target, StageChange{Stat: statAttack, Change: -1})
+}
+
+// static may paralyze a Pokémon that hits with a physical move.
+func static(b *Battle, side int, c, attacker *combatant, move Move, damage int) {
+	if move.Category != categoryPhysical || attacker.fainted() || attacker.status != "" {
+		return
+	}
+	if b.rng.Float64() < staticChance {
+		b.emitAbility(side, c, "Static", fmt.Sprintf("%s's Static!", b.sides[side].label(c)))
+		b.inflictStatus(1-side, attacker, statusParalysis)
+	}
+}
+
+// shedSkin may cure the Pokémon's status condition.
+func shedSkin(b *Battle, side int, c *combatant) {
+	if c.status != "" && b.rng.Float64() < shedSkinChance {
+		b.cureStatus(side, c, "%s shed its skin and was cured!")
+	}
+}
+
+// abilityName returns the ability of a Pokémon. Pokémon without one, such as those caught before
+// abilities existed, have the first ability of their species.
+func abilityName(p *Pokemon) string {
+	if p.Ability != "" {
+		return p.Ability
+	}
+	if len(p.Abilities) > 0 {
+		return p.Abilities[0]
+	}
+	if s, ok := species[p.Name]; ok && len(s.Abilities) > 0 {
+		return s.Abilities[0]
+	}
+	return ""
+}
+
+// displayAbility returns the name of an ability as shown to players, e.g. "Shed Skin".
+func displayAbility(name string) string {
+	if a, ok := abilities[name]; ok {
+		return a.Name
+	}
+	words := strings.Split(name, "-")
+	for i, w := range words {
+		words[i] = typeName(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// ability returns the combatant's ability, or nil if it has none with an effect.
+func (c *combatant) ability() *Ability {
+	return abilities[abilityName(c.pokemon)]
+}
+
+// immuneTo reports whether the combatant's ability protects it from attacks of a type.
+func (c *combatant) immuneTo(attackType string) bool {
+	a := c.ability()
+	if a == nil {
+		return false
+	}
+	for _, t := range a.immuneTypes {
+		if t == attackType {
+			return true
+		}
+	}
+	return false
+}
+
+// attackType returns the type of a damaging move. Moves without a type of their own take the type
+// of the attacker that works best against the defender, skipping types the defender is immune to.
+func attackType(attacker, defender *combatant, move Move) string {
+	if move.Type != "" {
+		return move.Type
+	}
+	best, bestMultiplier := "", -1.0
+	for _, t := range attacker.pokemon.Type {
+		if defender.immuneTo(t) {
+			continue
+		}
+		multiplier := 1.0
+		for _, defenderType := range defender.pokemon.Type {
+			multiplier *= calculateTypeEffectiveness(t, defenderType)
+		}
+		if multiplier > bestMultiplier {
+			best, bestMultiplier = t, multiplier
+		}
+	}
+	if best == "" && len(attacker.pokemon.Type) > 0 {
+		return attacker.pokemon.Type[0]
+	}
+	return best
+}
+
+// emitAbility announces that a combatant's ability took effect.
+func (b *Battle) emitAbility(side int, c *combatant, ability, text string) {
+	b.emit(BattleEvent{Kind: "ability", Side: side, Pokemon: c.pokemon.Name, Ability: ability, Text: text})
+}
+
+// switchIn runs the switch-in ability of a side's active Pokémon.
+func (b *Battle) switchIn(side int) {
+	c := b.sides[side].current()
+	if a := c.ability(); a != nil && a.onSwitchIn != nil && !c.fainted() {
+		a.onSwitchIn(b, side, c)
+	}
+}
+
+// damageTaken runs the ability of a combatant that was just hit by an attack.
+func (b *Battle) damageTaken(side int, c, attacker *combatant, move Move, damage int) {
+	if a := c.ability(); a != nil && a.onDamageTaken != nil && damage > 0 {
+		a.onDamageTaken(b, side, c, attacker, move, damage)
+	}
+}
+
+// endOfTurnAbilities runs the end-of-turn abilities of both active Pokémon.
+func (b *Battle) endOfTurnAbilities() {
+	for side, s := range b.sides {
+		if b.over {
+			return
+		}
+		c := s.current()
+		if a := c.ability(); a != nil && a.endOfTurn != nil && !c.fainted() {
+			a.endOfTurn(b, side, c)
+		}
+	}
+}
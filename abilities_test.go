@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fixedRNG returns the same numbers every time, to force the outcome of chance-based effects.
+type fixedRNG struct{ f float64 }
+
+func (r fixedRNG) Intn(n int) int   { return int(r.f * float64(n)) }
+func (r fixedRNG) Int63() int64     { return 0 }
+func (r fixedRNG) Float64() float64 { return r.f }
+
+// testPokemon returns a level 10 Pokémon with even stats.
+func testPokemon(name, ability string, types ...string) *Pokemon {
+	return &Pokemon{Name: name, Type: types, Ability: ability, Level: 10,
+		HP: 100, Attack: 50, Defense: 50, SpecialAttack: 50, SpecialDefense: 50, Speed: 50}
+}
+
+// testBattle returns a battle between two single Pokémon whose sides ignore every event.
+func testBattle(a, b *Pokemon, rng RNG) *Battle {
+	return newBattle(
+		newTeamSide("red", []*Pokemon{a}, silentController{}),
+		newTeamSide("blue", []*Pokemon{b}, silentController{}),
+		defaultBattleRules,
+		rng,
+	)
+}
+
+// fixedMove returns a move of the given type and category that always deals 40 damage.
+func fixedMove(attackType, category string) Move {
+	return Move{Name: "Test", Category: category, Type: attackType,
+		damage: func(attacker, defender *Pokemon) int { return 40 }}
+}
+
+func TestLevitate(t *testing.T) {
+	tests := []struct {
+		ability    string
+		attackType string
+		want       int
+	}{
+		{"levitate", "ground", 0},
+		{"levitate", "water", 40},
+		{"", "ground", 40},
+	}
+	for _, tt := range tests {
+		attacker := newCombatant(testPokemon("sandshrew", "", "ground"))
+		defender := newCombatant(testPokemon("gastly", tt.ability, "ghost"))
+		if got := moveDamage(attacker, defender, fixedMove(tt.attackType, categoryPhysical)); got != tt.want {
+			t.Errorf("%q defender hit by %s: damage %d, want %d", tt.ability, tt.attackType, got, tt.want)
+		}
+	}
+}
+
+func TestLevitateSpecialAttackUsesOtherType(t *testing.T) {
+	attacker := newCombatant(testPokemon("nidoking", "", "ground", "poison"))
+	defender := newCombatant(testPokemon("koffing", "levitate", "poison"))
+	if got := attackType(attacker, defender, Move{}); got != "poison" {
+		t.Errorf("attack type against levitate = %q, want poison", got)
+	}
+}
+
+func TestPinchAbilities(t *testing.T) {
+	tests := []struct {
+		ability    string
+		attackType string
+		hp         int // Of 99
+		want       int
+	}{
+		{"blaze", "fire", 99, 40},
+		{"blaze", "fire", 34, 40},
+		{"blaze", "fire", 33, 60},
+		{"blaze", "fire", 1, 60},
+		{"blaze", "water", 1, 40},
+		{"torrent", "water", 33, 60},
+		{"torrent", "water", 34, 40},
+		{"torrent", "grass", 1, 40},
+		{"overgrow", "grass", 33, 60},
+		{"overgrow", "grass", 50, 40},
+		{"overgrow", "fire", 1, 40},
+	}
+	for _, tt := range tests {
+		p := testPokemon("starter", tt.ability, tt.attackType)
+		p.HP = 99
+		attacker := newCombatant(p)
+		attacker.hp = tt.hp
+		defender := newCombatant(testPokemon("rattata", "", "normal"))
+		if got := moveDamage(attacker, defender, fixedMove(tt.attackType, categorySpecial)); got != tt.want {
+			t.Errorf("%s at %d/99 HP using %s: damage %d, want %d", tt.ability, tt.hp, tt.attackType, got, tt.want)
+		}
+	}
+}
+
+func TestIntimidate(t *testing.T) {
+	tests := []struct {
+		ability     string
+		targetHP    int
+		wantAttack  int // Stage of the opponent's Attack after the switch-in
+		startAttack int
+	}{
+		{"intimidate", 100, -1, 0},
+		{"intimidate", 100, -2, -1},
+		{"intimidate", 100, -6, -6}, // Already at the lowest stage
+		{"intimidate", 0, 0, 0},     // Fainted opponents are left alone
+		{"", 100, 0, 0},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("growlithe", tt.ability, "fire"), testPokemon("pidgey", "", "normal", "flying"), fixedRNG{})
+		target := b.sides[1].current()
+		target.hp = tt.targetHP
+		target.stages[statAttack] = tt.startAttack
+		b.switchIn(0)
+		if got := target.stages[statAttack]; got != tt.wantAttack {
+			t.Errorf("%q switch-in against %d HP at stage %d: Attack stage %d, want %d",
+				tt.ability, tt.targetHP, tt.startAttack, got, tt.wantAttack)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	tests := []struct {
+		name       string
+		roll       float64
+		category   string
+		types      []string // Of the attacker
+		status     string   // Of the attacker before the hit
+		wantStatus string
+	}{
+		{"paralyzes", 0, categoryPhysical, []string{"normal"}, "", statusParalysis},
+		{"just under the chance", staticChance - 0.01, categoryPhysical, []string{"normal"}, "", statusParalysis},
+		{"roll misses", staticChance, categoryPhysical, []string{"normal"}, "", ""},
+		{"special moves don't touch", 0, categorySpecial, []string{"normal"}, "", ""},
+		{"already burned", 0, categoryPhysical, []string{"normal"}, statusBurn, statusBurn},
+		{"electric types are immune", 0, categoryPhysical, []string{"electric"}, "", ""},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("pikachu", "static", "electric"), testPokemon("attacker", "", tt.types...), fixedRNG{tt.roll})
+		defender, attacker := b.sides[0].current(), b.sides[1].current()
+		attacker.status = tt.status
+		b.damageTaken(0, defender, attacker, fixedMove("normal", tt.category), 40)
+		if attacker.status != tt.wantStatus {
+			t.Errorf("%s: attacker status %q, want %q", tt.name, attacker.status, tt.wantStatus)
+		}
+	}
+}
+
+func TestShedSkin(t *testing.T) {
+	tests := []struct {
+		name       string
+		ability    string
+		roll       float64
+		status     string
+		wantStatus string
+	}{
+		{"cures", "shed-skin", 0, statusPoison, ""},
+		{"cures sleep", "shed-skin", shedSkinChance - 0.01, statusSleep, ""},
+		{"roll misses", "shed-skin", shedSkinChance, statusBurn, statusBurn},
+		{"no status", "shed-skin", 0, "", ""},
+		{"other ability", "", 0, statusPoison, statusPoison},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("metapod", tt.ability, "bug"), testPokemon("rattata", "", "normal"), fixedRNG{tt.roll})
+		c := b.sides[0].current()
+		c.status = tt.status
+		b.endOfTurnAbilities()
+		if c.status != tt.wantStatus {
+			t.Errorf("%s: status %q, want %q", tt.name, c.status, tt.wantStatus)
+		}
+	}
+}
+
+// TestBundledPokedexAbilities checks that the bundled Pokédex lists abilities exactly for the
+// species that can have an ability with an effect, as the README says.
+func TestBundledPokedexAbilities(t *testing.T) {
+	pokedex, err := loadPokedex("pokedex.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := 0
+	for _, p := range pokedex {
+		if len(p.Abilities) == 0 {
+			continue
+		}
+		listed++
+		effect := false
+		for _, name := range p.Abilities {
+			effect = effect || abilities[name] != nil
+		}
+		if !effect {
+			t.Errorf("%s lists %v, none of which has an effect", p.Name, p.Abilities)
+		}
+	}
+	if listed == 0 || listed == len(pokedex) {
+		t.Errorf("%d of %d species list abilities, want only those with an ability that has an effect", listed, len(pokedex))
+	}
+
+	// Every ability with an effect is listed for some species.
+	for name := range abilities {
+		found := false
+		for _, p := range pokedex {
+			for _, ability := range p.Abilities {
+				found = found || ability == name
+			}
+		}
+		if !found {
+			t.Errorf("no species lists %s", name)
+		}
+	}
+
+	// Species list all of their abilities, hidden ability last, or none.
+	want := map[string][]string{
+		"bulbasaur":  {"overgrow", "chlorophyll"},
+		"charmander": {"blaze", "solar-power"},
+		"squirtle":   {"torrent", "rain-dish"},
+		"ekans":      {"intimidate", "shed-skin", "unnerve"},
+		"pikachu":    {"static", "lightning-rod"},
+		"gastly":     {"levitate"},
+	}
+	index := make(map[string]Pokemon)
+	for _, p := range pokedex {
+		index[p.Name] = p
+	}
+	for name, list := range want {
+		if got := index[name].Abilities; strings.Join(got, ",") != strings.Join(list, ",") {
+			t.Errorf("%s lists %v, want %v", name, got, list)
+		}
+	}
+	for _, name := range []string{"rattata", "magikarp", "snorlax"} {
+		if got := index[name].Abilities; len(got) != 0 {
+			t.Errorf("%s lists %v, want no abilities", name, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	passwordIterations = 100000 // PBKDF2 iterations for new password hashes
+	passwordSaltBytes  = 16
+	passwordHashBytes  = 32
+	minPasswordLength  = 6
+)
+
+var (
+	errNoAccount       = errors.New("no such account")
+	errAccountExists   = errors.New("username is already taken")
+	errBadCredentials  = errors.New("wrong username or password")
+	errAlreadyLoggedIn = errors.New("account is already logged in")
+)
+
+// usernamePattern restricts usernames to characters that are safe in file names.
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{3,20}$`)
+
+// Account is a registered user and the player profile that belongs to them.
+type Account struct {
+	Username     string
+	Salt         string // Hex-encoded random salt
+	PasswordHash string // Hex-encoded PBKDF2-SHA256 of the password
+	Iterations   int
+	PlayerID     int // ID of the player data owned by this account
+	Created      time.Time
+}
+
+// hashPassword derives the password hash for the given salt and iteration count.
+func hashPassword(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, iterations, passwordHashBytes)
+}
+
+// newAccount creates an account with a freshly salted password hash.
+// The PlayerID is assigned by the store when the account is created.
+func newAccount(username, password string) (*Account, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("username must be 3-20 letters, digits or underscores")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password, salt, passwordIterations)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		Username:     username,
+		Salt:         hex.EncodeToString(salt),
+		PasswordHash: hex.EncodeToString(hash),
+		Iterations:   passwordIterations,
+		Created:      time.Now(),
+	}, nil
+}
+
+// checkPassword reports whether password matches the account's stored hash.
+func (a *Account) checkPassword(password string) bool {
+	salt, err := hex.DecodeString(a.Salt)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(a.PasswordHash)
+	if err != nil {
+		return false
+	}
+	got, err := hashPassword(password, salt, a.Iterations)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// registerAccount creates a new account and its empty player profile.
+func registerAccount(username, password string) (*Account, error) {
+	account, err := newAccount(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CreateAccount(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// authenticate checks a username and password against the stored accounts.
+func authenticate(username, password string) (*Account, error) {
+	account, err := store.LoadAccount(username)
+	if err == errNoAccount {
+		return nil, errBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !account.checkPassword(password) {
+		return nil, errBadCredentials
+	}
+	return account, nil
+}
+
+var (
+	sessions      = make(map[string]*Client) // Logged in clients by username
+	sessionsMutex sync.Mutex
+)
+
+// startSession marks an account as logged in by client.
+// An account can only be logged in from one connection at a time.
+func startSession(account *Account, client *Client) error {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	if _, ok := sessions[account.Username]; ok {
+		return errAlreadyLoggedIn
+	}
+	sessions[account.Username] = client
+	return nil
+}
+
+// endSession marks an account as logged out.
+func endSession(account *Account) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	delete(sessions, account.Username)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vector of RFC 7914, section 11, cut to passwordHashBytes.
+	hash, err := hashPassword("passwd", []byte("salt"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(hash), "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"; got != want {
+		t.Errorf("hash %s, want %s", got, want)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	account, err := newAccount("ash", "pikachu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Iterations != passwordIterations || account.PasswordHash == "pikachu" {
+		t.Errorf("account stored as %+v", account)
+	}
+	if !account.checkPassword("pikachu") {
+		t.Error("the right password is refused")
+	}
+	for _, wrong := range []string{"", "Pikachu", "pikachu ", "raichu"} {
+		if account.checkPassword(wrong) {
+			t.Errorf("wrong password %q accepted", wrong)
+		}
+	}
+
+	// The same password gets another salt, and so another hash.
+	other, err := newAccount("misty", "pikachu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Salt == account.Salt || other.PasswordHash == account.PasswordHash {
+		t.Error("two accounts with the same password have the same salt or hash")
+	}
+
+	// Accounts keep the iteration count they were hashed with.
+	hash, err := hashPassword("pikachu", []byte("salt"), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &Account{Salt: hex.EncodeToString([]byte("salt")), PasswordHash: hex.EncodeToString(hash), Iterations: 1000}
+	if !old.checkPassword("pikachu") {
+		t.Error("password of an account with fewer iterations is refused")
+	}
+	old.Salt = "not hex"
+	if old.checkPassword("pikachu") {
+		t.Error("password accepted with a corrupt salt")
+	}
+}
+
+func TestNewAccountValidation(t *testing.T) {
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"ash", "secret", true},
+		{"Ash_Ketchum_1", "secret", true},
+		{"as", "secret", false},
+		{"a_name_that_is_too_long", "secret", false},
+		{"ash/../misty", "secret", false},
+		{"ash ketchum", "secret", false},
+		{"ash", "short", false},
+	}
+	for _, tt := range tests {
+		if _, err := newAccount(tt.username, tt.password); (err == nil) != tt.ok {
+			t.Errorf("newAccount(%q, %q): %v", tt.username, tt.password, err)
+		}
+	}
+}
+
+func TestRegisterAndAuthenticate(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"file": func(t *testing.T) Store { return newFileStore(t.TempDir()) },
+		"db": func(t *testing.T) Store {
+			db, err := openDBStore(filepath.Join(t.TempDir(), "pokecat.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return db
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			old := store
+			store = open(t)
+			t.Cleanup(func() { store = old })
+
+			ash, err := registerAccount("Ash", "pikachu")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, username := range []string{"Ash", "ash", "ASH"} {
+				if _, err := registerAccount(username, "raichu"); err != errAccountExists {
+					t.Errorf("registering %s again: %v, want %v", username, err, errAccountExists)
+				}
+			}
+			misty, err := registerAccount("misty", "staryu")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if misty.PlayerID == ash.PlayerID {
+				t.Errorf("both accounts own player %d", ash.PlayerID)
+			}
+
+			account, err := authenticate("ash", "pikachu")
+			if err != nil || account.PlayerID != ash.PlayerID {
+				t.Errorf("logging in: %+v, %v; want player %d", account, err, ash.PlayerID)
+			}
+			for _, tt := range [][2]string{{"ash", "raichu"}, {"ash", "staryu"}, {"brock", "pikachu"}} {
+				if _, err := authenticate(tt[0], tt[1]); err != errBadCredentials {
+					t.Errorf("logging in as %s with %s: %v, want %v", tt[0], tt[1], err, errBadCredentials)
+				}
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	account := &Account{Username: "ash"}
+	first, _ := testClient(Pokemon{})
+	second, _ := testClient(Pokemon{})
+	if err := startSession(account, first); err != nil {
+		t.Fatal(err)
+	}
+	defer endSession(account)
+	if err := startSession(account, second); err != errAlreadyLoggedIn {
+		t.Errorf("second login: %v, want %v", err, errAlreadyLoggedIn)
+	}
+	endSession(account)
+	if err := startSession(account, second); err != nil {
+		t.Errorf("login after logging out: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Difficulty levels of computer opponents.
+const (
+	aiRandom  = "random"  // Picks any move
+	aiGreedy  = "greedy"  // Picks the move that deals the most damage right now
+	aiPlanner = "planner" // Looks a few turns ahead
+)
+
+// aiLevels lists the difficulty levels from easiest to hardest.
+var aiLevels = []string{aiRandom, aiGreedy, aiPlanner}
+
+// plannerDepth is the number of turns the planner looks ahead.
+const plannerDepth = 2
+
+// newAIController returns a computer controller of the given difficulty level.
+// Controllers choose at the same time as their opponent, so rng must not be shared with it.
+func newAIController(level string, rng RNG) (controller, error) {
+	switch level {
+	case aiRandom:
+		return &randomController{rng: rng}, nil
+	case aiGreedy:
+		return &greedyController{}, nil
+	case aiPlanner:
+		return &plannerController{depth: plannerDepth}, nil
+	}
+	return nil, fmt.Errorf("unknown AI level %q (choose %s)", level, strings.Join(aiLevels, ", "))
+}
+
+// moveActions returns every move the active Pokémon of a side can use.
+func moveActions(b *Battle, side int) []action {
+	moves := movesFor(b.sides[side].current().pokemon)
+	actions := make([]action, len(moves))
+	for i := range moves {
+		actions[i] = action{kind: actionMove, move: i}
+	}
+	return actions
+}
+
+// randomController picks a random move every turn. Wild Pokémon battle this way.
+type randomController struct {
+	rng RNG
+}
+
+func (r *randomController) chooseAction(b *Battle, side int) action {
+	actions := moveActions(b, side)
+	return actions[r.rng.Intn(len(actions))]
+}
+
+func (r *randomController) notify(b *Battle, event BattleEvent) {}
+
+// greedyController picks the move that deals the most damage to the opposing Pokémon,
+// which makes it prefer moves its types are super effective with.
+type greedyController struct{}
+
+func (g *greedyController) chooseAction(b *Battle, side int) action {
+	attacker := b.sides[side].current()
+	defender := b.sides[1-side].current()
+	moves := movesFor(attacker.pokemon)
+
+	best, bestDamage := 0, -1
+	for i, move := range moves {
+		// Among equally damaging moves, prefer one that may also inflict a status.
+		damage := moveDamage(attacker, defender, move)
+		if damage > bestDamage || (damage == bestDamage && move.Status != "" && defender.status == "") {
+			best, bestDamage = i, damage
+		}
+	}
+	return action{kind: actionMove, move: best}
+}
+
+func (g *greedyController) notify(b *Battle, event BattleEvent) {}
+
+// plannerController tries every combination of both sides' moves for a few turns on copies of
+// the battle and picks the move with the best outcome against the opponent's best replies.
+type plannerController struct {
+	depth int
+}
+
+func (p *plannerController) chooseAction(b *Battle, side int) action {
+	best, _ := p.search(b, side, p.depth)
+	return best
+}
+
+func (p *plannerController) notify(b *Battle, event BattleEvent) {}
+
+// search returns the action with the highest worst-case score after depth turns.
+func (p *plannerController) search(b *Battle, side, depth int) (action, float64) {
+	if b.over || depth == 0 {
+		return action{}, evaluateBattle(b, side)
+	}
+
+	own, other := moveActions(b, side), moveActions(b, 1-side)
+	// Try the most damaging move first, so it is kept when several moves score the same.
+	greedy := (&greedyController{}).chooseAction(b, side)
+	own[0], own[greedy.move] = own[greedy.move], own[0]
+
+	var best action
+	bestScore := -1e9
+	for _, mine := range own {
+		worst := 1e9
+		for _, theirs := range other {
+			var actions [2]action
+			actions[side], actions[1-side] = mine, theirs
+			next := b.clone()
+			next.resolveTurn(actions)
+			if _, score := p.search(next, side, depth-1); score < worst {
+				worst = score
+			}
+		}
+		if worst > bestScore {
+			best, bestScore = mine, worst
+		}
+	}
+	return best, bestScore
+}
+
+// evaluateBattle scores a battle from the point of view of a side: the share of its team's HP
+// left minus the opponent's, with a large bonus or penalty once the battle is decided.
+func evaluateBattle(b *Battle, side int) float64 {
+	if b.over && b.winner >= 0 {
+		if b.winner == side {
+			return 100
+		}
+		return -100
+	}
+	return hpShare(b.sides[side]) - hpShare(b.sides[1-side])
+}
+
+// hpShare returns the fraction of a side's total HP that is left.
+func hpShare(s *battleSide) float64 {
+	hp, maxHP := 0, 0
+	for _, c := range s.team {
+		hp += c.hp
+		maxHP += c.maxHP
+	}
+	if maxHP == 0 {
+		return 0
+	}
+	return float64(hp) / float64(maxHP)
+}
+
+// randomTeam picks a team of random species from the Pokédex, at the given level as in teamMember.
+func randomTeam(pokedex []Pokemon, size, level int, rng RNG) []*Pokemon {
+	team := make([]*Pokemon, 0, size)
+	for i := 0; i < size; i++ {
+		team = append(team, teamMember(pokedex[rng.Intn(len(pokedex))], level, rng))
+	}
+	return team
+}
+
+// teamMember returns a battler of a species with one of its abilities. A level above 0 sets it
+// to that level with a random EV spread; otherwise the Pokédex stats are used as in a draft.
+func teamMember(base Pokemon, level int, rng RNG) *Pokemon {
+	pokemon := base
+	if level > 0 {
+		pokemon.Level = level
+		setSpread(&pokemon, generateRandomEVs(rng))
+		recomputeStats(&pokemon)
+	}
+	if len(pokemon.Abilities) > 0 {
+		pokemon.Ability = pokemon.Abilities[rng.Intn(len(pokemon.Abilities))]
+	}
+	return &pokemon
+}
+
+// averageLevel returns the average level of a team, at least 1.
+func averageLevel(team []*Pokemon) int {
+	if len(team) == 0 {
+		return 1
+	}
+	total := 0
+	for _, p := range team {
+		total += p.Level
+	}
+	if level := total / len(team); level > 1 {
+		return level
+	}
+	return 1
+}
+
+// newTeamSide creates a battle side for a team of Pokémon.
+func newTeamSide(name string, team []*Pokemon, ctrl controller) *battleSide {
+	side := &battleSide{name: name, controller: ctrl}
+	for _, p := range team {
+		side.team = append(side.team, newCombatant(p))
+	}
+	return side
+}
+
+// survivors returns the Pokémon of a side that have not fainted.
+func survivors(s *battleSide) []*Pokemon {
+	var alive []*Pokemon
+	for _, c := range s.team {
+		if !c.fainted() {
+			alive = append(alive, c.pokemon)
+		}
+	}
+	return alive
+}
+
+// playAIMatch runs a battle between two computer opponents without any client, e.g. to test
+// balance changes. Both teams are used as they are.
+func playAIMatch(teamA, teamB []*Pokemon, levelA, levelB string, rules BattleRules, rng RNG) (*Battle, error) {
+	ctrlA, err := newAIController(levelA, newRNG(rng.Int63()))
+	if err != nil {
+		return nil, err
+	}
+	ctrlB, err := newAIController(levelB, newRNG(rng.Int63()))
+	if err != nil {
+		return nil, err
+	}
+	b := newBattle(
+		newTeamSide(levelA+" AI", teamA, ctrlA),
+		newTeamSide(levelB+" AI", teamB, ctrlB),
+		rules,
+		rng,
+	)
+	b.run(context.Background())
+	return b, nil
+}
+
+// battleAI runs a single-player battle between the client and a computer opponent.
+// The opponent's team is picked at random, at the level of the client's team in the collection format.
+func battleAI(client *Client, level, format string, pokedex []Pokemon) {
+	conn := client.conn
+	rng := pokeworld.forkRNG()
+	ai, err := newAIController(level, newRNG(rng.Int63()))
+	if err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		return
+	}
+
+	fmt.Fprintln(conn, "Welcome to the Pokémon battle!")
+	if err := chooseTeam(client, format, pokedex); err != nil {
+		return
+	}
+
+	opponentLevel := 0
+	if format == formatCollection {
+		opponentLevel = averageLevel(client.team)
+	}
+	opponent := randomTeam(pokedex, teamSize, opponentLevel, rng)
+	names := make([]string, len(opponent))
+	for i, p := range opponent {
+		names[i] = describePokemon(*p)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(conn, "Your opponent is a %s AI with %s.\n", level, strings.Join(names, ", "))
+
+	b := newBattle(
+		newTeamSide(client.account.Username, client.team, &humanController{client: client}),
+		newTeamSide(strings.ToUpper(level[:1])+level[1:]+" AI", opponent, ai),
+		currentConfig().Battle.rules(),
+		rng,
+	)
+	b.onFaint = awardFaintExperience
+	b.onItem = bagItemHook(client)
+	b.run(client.ctx)
+	keepBattleState(client)
+	if b.outcome == outcomeAborted {
+		return
+	}
+
+	client.team = survivors(b.sides[0])
+	result := resultLoss
+	switch {
+	case b.winner == 0:
+		result = resultWin
+		awardWinExperience(client, opponent)
+		awardPrize(client)
+	case b.outcome == outcomeStalemate:
+		result = resultDraw
+	}
+	addBattleRecord(client.player, BattleRecord{
+		OpponentID:       "ai:" + level,
+		Result:           result,
+		ExperienceGained: applyBattleExperience(client),
+		Timestamp:        pokeworld.clock.Now(),
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGreedyPicksMostDamagingMove(t *testing.T) {
+	tests := []struct {
+		attacker, defender string // Types
+		want               string
+	}{
+		{"fire", "grass", "Ember"},
+		{"water", "fire", "Special Attack"}, // Uses the attacker's type, unlike Withdraw
+		{"electric", "water", "Thunder Shock"},
+		{"grass", "water", "Special Attack"},
+	}
+	for _, tt := range tests {
+		attacker, defender := testPokemon("attacker", "", tt.attacker), testPokemon("defender", "", tt.defender)
+		b := testBattle(attacker, defender, newRNG(1))
+		act := (&greedyController{}).chooseAction(b, 0)
+		if got := movesFor(attacker)[act.move].Name; act.kind != actionMove || got != tt.want {
+			t.Errorf("%s against %s: greedy picks %s, want %s", tt.attacker, tt.defender, got, tt.want)
+		}
+	}
+}
+
+// TestPlayAIMatchEnds plays seeded matches between every pair of AI levels and checks that each
+// one ends within the turn limit, and the same way every time for the same seed.
+func TestPlayAIMatchEnds(t *testing.T) {
+	rules := defaultBattleRules
+	play := func(levelA, levelB string, seed int64) *Battle {
+		rng := newRNG(seed)
+		teamA, teamB := randomTeam(testPokedex, teamSize, 0, rng), randomTeam(testPokedex, teamSize, 0, rng)
+		b, err := playAIMatch(teamA, teamB, levelA, levelB, rules, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for _, levelA := range aiLevels {
+		for _, levelB := range aiLevels {
+			for seed := int64(1); seed <= 3; seed++ {
+				b := play(levelA, levelB, seed)
+				name := levelA + " against " + levelB
+				if !b.over || b.turn > rules.MaxTurns {
+					t.Errorf("%s, seed %d: over %v after %d turns", name, seed, b.over, b.turn)
+				}
+				switch b.outcome {
+				case outcomeWin, outcomeTiebreak:
+					if b.winner < 0 {
+						t.Errorf("%s, seed %d: %s without a winner", name, seed, b.outcome)
+					}
+				case outcomeStalemate:
+				default:
+					t.Errorf("%s, seed %d: outcome %q", name, seed, b.outcome)
+				}
+				if again := play(levelA, levelB, seed); again.turn != b.turn || again.winner != b.winner || again.outcome != b.outcome {
+					t.Errorf("%s, seed %d: %s for %d after %d turns, then %s for %d after %d turns", name, seed,
+						b.outcome, b.winner, b.turn, again.outcome, again.winner, again.turn)
+				}
+			}
+		}
+	}
+}
+
+func TestPlayAIMatchUnknownLevel(t *testing.T) {
+	team := []*Pokemon{testPokemon("rattata", "", "normal")}
+	if _, err := playAIMatch(team, team, aiGreedy, "expert", defaultBattleRules, newRNG(1)); err == nil || !strings.Contains(err.Error(), `unknown AI level "expert"`) {
+		t.Errorf("match against an unknown level: %v", err)
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of actions a side can take on its turn.
+const (
+	actionMove    = iota // Use one of the active Pokémon's moves
+	actionCatch          // Throw a Poké Ball (wild battles only)
+	actionRun            // Run away (wild battles only)
+	actionForfeit        // Give up the battle
+	actionItem           // Use a potion or a heal from the bag on the active Pokémon
+)
+
+// action is what a side does on a turn.
+type action struct {
+	kind int
+	move int    // Index into the active Pokémon's moves for actionMove
+	item string // Item key for actionItem, and the ball thrown for actionCatch
+}
+
+// Outcomes of a battle.
+const (
+	outcomeWin       = "win"       // One side has no Pokémon left, or forfeited
+	outcomeCaught    = "caught"    // The wild Pokémon was caught
+	outcomeEscaped   = "escaped"   // The player ran away, or the wild Pokémon fled
+	outcomeGone      = "gone"      // The wild Pokémon had left the world when a ball was thrown
+	outcomeTiebreak  = "tiebreak"  // A limit was reached and the side with more HP left won
+	outcomeStalemate = "stalemate" // A limit was reached without a winner
+	outcomeAborted   = "aborted"   // The server shut down before the battle was over
+)
+
+// BattleRules limit how long a battle can go on.
+type BattleRules struct {
+	MaxTurns        int           // Turns after which the battle is stopped; 0 means no limit
+	NoProgressTurns int           // Turns in a row without any HP change after which the battle is stopped; 0 disables it
+	Tiebreak        bool          // A stopped battle is won by the side with the highest share of HP left
+	TurnTimeout     time.Duration // Time a player has to choose an action; 0 means no limit
+	TimeoutForfeits bool          // Running out of time forfeits instead of using the best damaging move
+	Items           bool          // Players may use items from their bag
+}
+
+// defaultBattleRules are the default rules of battles between trainers, see BattleConfig.
+var defaultBattleRules = BattleRules{
+	MaxTurns:        100,
+	NoProgressTurns: 10,
+	Tiebreak:        true,
+	TurnTimeout:     60 * time.Second,
+}
+
+// wildBattleRules apply to battles against wild Pokémon, which simply leave when a battle drags on.
+var wildBattleRules = BattleRules{
+	MaxTurns:        50,
+	NoProgressTurns: 10,
+	TurnTimeout:     60 * time.Second,
+	Items:           true,
+}
+
+// BattleEvent describes something that happened in a battle. Clients that chose JSON with the
+// events command receive it encoded as is; the others only see Text.
+type BattleEvent struct {
+	Turn int `json:"turn"`
+	// Kind is one of "start", "move", "miss", "status", "immobile", "cure", "stage", "residual",
+	// "ability", "item", "faint", "switch", "catch", "gone", "run", "forfeit", "stalemate", "abort"
+	// or "end".
+	Kind    string `json:"kind"`
+	Side    int    `json:"side"`
+	Pokemon string `json:"pokemon,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Move    string `json:"move,omitempty"`
+	Damage  int    `json:"damage,omitempty"`
+	HP      int    `json:"hp,omitempty"`      // Remaining HP of the target
+	Status  string `json:"status,omitempty"`  // Status condition involved, e.g. "burn"
+	Stat    string `json:"stat,omitempty"`    // Stat whose stage changed
+	Stage   int    `json:"stage,omitempty"`   // New stage of Stat
+	Ability string `json:"ability,omitempty"` // Ability that took effect
+	Item    string `json:"item,omitempty"`    // Item used
+	Text    string `json:"text"`
+}
+
+// controller decides the actions of one side of a battle and receives its events.
+type controller interface {
+	chooseAction(b *Battle, side int) action
+	notify(b *Battle, event BattleEvent)
+}
+
+// combatant is a Pokémon taking part in a battle, with its battle-only state.
+type combatant struct {
+	pokemon    *Pokemon
+	hp         int
+	maxHP      int
+	status     string // Status condition, written back to the Pokémon when the battle ends
+	sleepTurns int    // Turns left asleep; -1 until rolled for a Pokémon that was already asleep
+	stages     [numStages]int
+}
+
+func newCombatant(p *Pokemon) *combatant {
+	c := &combatant{pokemon: p, hp: p.currentHP(), maxHP: p.HP, status: p.Status}
+	if c.status == statusSleep {
+		c.sleepTurns = -1
+	}
+	return c
+}
+
+func (c *combatant) fainted() bool {
+	return c.hp <= 0
+}
+
+// battleSide is one of the two parties of a battle.
+type battleSide struct {
+	name       string // Shown in battle messages, e.g. "ash"
+	wild       bool   // A wild Pokémon from the world rather than a trainer
+	team       []*combatant
+	active     int // Index of the Pokémon currently battling
+	controller controller
+}
+
+func (s *battleSide) current() *combatant {
+	return s.team[s.active]
+}
+
+// defeated reports whether every Pokémon of the side has fainted.
+func (s *battleSide) defeated() bool {
+	for _, c := range s.team {
+		if !c.fainted() {
+			return false
+		}
+	}
+	return true
+}
+
+// label names a combatant in battle messages.
+func (s *battleSide) label(c *combatant) string {
+	if s.wild {
+		return "The wild " + c.pokemon.Name
+	}
+	return fmt.Sprintf("%s's %s", s.name, c.pokemon.Name)
+}
+
+// sendOut announces the Pokémon a side brings into the battle.
+func (s *battleSide) sendOut() string {
+	if s.wild {
+		return fmt.Sprintf("The wild %s wants to battle!", s.current().pokemon.Name)
+	}
+	return fmt.Sprintf("%s sends out %s!", s.name, s.current().pokemon.Name)
+}
+
+// Battle is a turn-based battle between two sides. Every turn both sides choose an action,
+// then the actions run in order of the active Pokémon's speed.
+type Battle struct {
+	sides   [2]*battleSide
+	turn    int
+	rng     RNG
+	over    bool
+	winner  int    // Index of the winning side, or -1
+	outcome string // One of the outcome constants once the battle is over
+	rules   BattleRules
+	idle    int             // Turns in a row without any HP change
+	ctx     context.Context // Cancelled to call the battle off, see run
+
+	// onCatch is called for actionCatch with the key of the ball thrown and reports whether the
+	// opposing Pokémon was caught and whether it fled. present is false if it had already left
+	// the world, caught by someone else or despawned; the hook then returns the ball to the bag.
+	// Battles without it don't allow catching.
+	onCatch func(b *Battle, side int, ball string) (caught, fled, present bool)
+	// onItem uses up one of an item from the bag of the given side and reports whether it had one.
+	// Battles without it, or whose rules don't allow items, have no items.
+	onItem func(b *Battle, side int, key string) bool
+	// onFaint is called when a Pokémon of the given side faints.
+	onFaint func(b *Battle, side int, c *combatant)
+}
+
+// newBattle creates a battle between two sides.
+func newBattle(a, b *battleSide, rules BattleRules, rng RNG) *Battle {
+	return &Battle{sides: [2]*battleSide{a, b}, rules: rules, rng: rng, winner: -1, ctx: context.Background()}
+}
+
+// clone returns a copy of the battle for trying out actions, as the lookahead AI does.
+// The copy has no controllers or hooks, so nothing that happens in it reaches the players,
+// and speed ties are decided by its own generator.
+func (b *Battle) clone() *Battle {
+	c := &Battle{turn: b.turn, rng: newRNG(int64(b.turn)), over: b.over, winner: b.winner, outcome: b.outcome,
+		rules: b.rules, idle: b.idle, ctx: b.ctx}
+	for i, side := range b.sides {
+		copied := *side
+		copied.controller = silentController{}
+		copied.team = make([]*combatant, len(side.team))
+		for j, member := range side.team {
+			m := *member
+			copied.team[j] = &m
+		}
+		c.sides[i] = &copied
+	}
+	return c
+}
+
+// emit sends an event to both sides.
+func (b *Battle) emit(event BattleEvent) {
+	event.Turn = b.turn
+	for _, side := range b.sides {
+		side.controller.notify(b, event)
+	}
+}
+
+// finish ends the battle.
+func (b *Battle) finish(winner int, outcome string) {
+	b.over = true
+	b.winner = winner
+	b.outcome = outcome
+}
+
+// run plays the battle until it is over. If ctx is cancelled, the battle is called off without
+// a winner at the end of the turn being chosen.
+func (b *Battle) run(ctx context.Context) {
+	b.ctx = ctx
+	for i, side := range b.sides {
+		b.emit(BattleEvent{Kind: "start", Side: i, Pokemon: side.current().pokemon.Name, Text: side.sendOut()})
+	}
+	for i := range b.sides {
+		b.switchIn(i)
+	}
+	for !b.over {
+		b.playTurn()
+	}
+
+	// Lost HP and status conditions outlast the battle.
+	for _, side := range b.sides {
+		for _, c := range side.team {
+			c.pokemon.Status = c.status
+			c.pokemon.HPLost = c.maxHP - c.hp
+		}
+	}
+	text := "The battle ended."
+	switch {
+	case b.outcome == outcomeAborted:
+		text = "The battle was called off."
+	case b.outcome == outcomeStalemate:
+		text = "The battle ends in a draw!"
+	case b.outcome == outcomeTiebreak:
+		text = fmt.Sprintf("%s wins the battle with more HP left (%.0f%% to %.0f%%)!", b.sides[b.winner].name,
+			100*hpShare(b.sides[b.winner]), 100*hpShare(b.sides[1-b.winner]))
+	case b.winner >= 0 && b.outcome == outcomeWin:
+		text = fmt.Sprintf("%s wins the battle!", b.sides[b.winner].name)
+	}
+	b.emit(BattleEvent{Kind: "end", Side: b.winner, Text: text})
+}
+
+// playTurn collects both sides' actions and carries them out. A cancelled battle is aborted
+// instead: the players' actions may be nothing but their connections being cut.
+func (b *Battle) playTurn() {
+	actions := b.chooseActions()
+	if b.ctx.Err() != nil {
+		b.emit(BattleEvent{Kind: "abort", Side: -1, Text: "The server is shutting down."})
+		b.finish(-1, outcomeAborted)
+		return
+	}
+	b.resolveTurn(actions)
+}
+
+// resolveTurn carries out one turn with the given actions, then applies the rules' limits.
+func (b *Battle) resolveTurn(actions [2]action) {
+	b.turn++
+	hpBefore := b.totalHP()
+	for _, side := range b.turnOrder(actions) {
+		if b.over {
+			return
+		}
+		b.execute(side, actions[side])
+	}
+	if !b.over {
+		b.residualDamage()
+	}
+	if !b.over {
+		b.endOfTurnAbilities()
+	}
+	if b.over {
+		return
+	}
+
+	if b.totalHP() == hpBefore {
+		b.idle++
+	} else {
+		b.idle = 0
+	}
+	switch {
+	case b.rules.MaxTurns > 0 && b.turn >= b.rules.MaxTurns:
+		b.stop(fmt.Sprintf("The turn limit of %d turns has been reached.", b.rules.MaxTurns))
+	case b.rules.NoProgressTurns > 0 && b.idle >= b.rules.NoProgressTurns:
+		b.stop(fmt.Sprintf("No damage has been dealt for %d turns.", b.idle))
+	}
+}
+
+// totalHP returns the HP left on both sides, which changes whenever the battle makes progress.
+func (b *Battle) totalHP() int {
+	total := 0
+	for _, side := range b.sides {
+		for _, c := range side.team {
+			total += c.hp
+		}
+	}
+	return total
+}
+
+// stop ends a battle that reached a limit. With the tiebreak rule the side with the highest
+// share of HP left wins; otherwise, or if both have the same share, it is a stalemate.
+func (b *Battle) stop(reason string) {
+	b.emit(BattleEvent{Kind: "stalemate", Side: -1, Text: reason})
+	share0, share1 := hpShare(b.sides[0]), hpShare(b.sides[1])
+	switch {
+	case b.rules.Tiebreak && share0 > share1:
+		b.finish(0, outcomeTiebreak)
+	case b.rules.Tiebreak && share1 > share0:
+		b.finish(1, outcomeTiebreak)
+	default:
+		b.finish(-1, outcomeStalemate)
+	}
+}
+
+// stalemated reports whether the battle was stopped by a limit rather than played out.
+func (b *Battle) stalemated() bool {
+	return b.outcome == outcomeTiebreak || b.outcome == outcomeStalemate
+}
+
+// chooseActions asks both controllers for their action at the same time.
+func (b *Battle) chooseActions() [2]action {
+	var actions [2]action
+	var wg sync.WaitGroup
+	for i, side := range b.sides {
+		wg.Add(1)
+		go func(i int, side *battleSide) {
+			defer wg.Done()
+			actions[i] = side.controller.chooseAction(b, i)
+		}(i, side)
+	}
+	wg.Wait()
+	return actions
+}
+
+// turnOrder returns the order in which the sides act. Anything other than a move goes first;
+// otherwise the faster Pokémon moves first and ties are decided randomly.
+func (b *Battle) turnOrder(actions [2]action) []int {
+	first := 0
+	switch {
+	case actions[0].kind != actionMove && actions[1].kind == actionMove:
+		first = 0
+	case actions[1].kind != actionMove && actions[0].kind == actionMove:
+		first = 1
+	default:
+		speed0, speed1 := b.speed(b.sides[0].current()), b.speed(b.sides[1].current())
+		if speed1 > speed0 || (speed1 == speed0 && b.rng.Intn(2) == 0) {
+			first = 1
+		}
+	}
+	return []int{first, 1 - first}
+}
+
+// execute carries out the action of one side.
+func (b *Battle) execute(side int, act action) {
+	own := b.sides[side]
+	other := b.sides[1-side]
+
+	switch act.kind {
+	case actionForfeit:
+		b.emit(BattleEvent{Kind: "forfeit", Side: side, Text: fmt.Sprintf("%s forfeits the battle!", own.name)})
+		b.finish(1-side, outcomeWin)
+	case actionRun:
+		b.emit(BattleEvent{Kind: "run", Side: side, Text: "Got away safely!"})
+		b.finish(-1, outcomeEscaped)
+	case actionCatch:
+		ball := items[act.item]
+		if b.onCatch == nil || ball == nil || ball.Kind != itemBall {
+			return
+		}
+		if !b.takeItem(side, act.item) {
+			b.emit(BattleEvent{Kind: "item", Side: side, Item: ball.Name, Text: fmt.Sprintf("%s has no %s left!", own.name, ball.Name)})
+			return
+		}
+		b.emit(BattleEvent{Kind: "item", Side: side, Item: ball.Name, Text: fmt.Sprintf("%s threw a %s!", own.name, ball.Name)})
+		caught, fled, present := b.onCatch(b, side, act.item)
+		target := other.current().pokemon.Name
+		switch {
+		case !present:
+			b.emit(BattleEvent{Kind: "gone", Side: side, Target: target, Item: ball.Name,
+				Text: fmt.Sprintf("The wild %s is gone! %s put the %s back in the bag.", target, own.name, ball.Name)})
+			b.finish(-1, outcomeGone)
+		case caught:
+			b.emit(BattleEvent{Kind: "catch", Side: side, Target: target, Text: fmt.Sprintf("Gotcha! %s was caught!", target)})
+			b.finish(side, outcomeCaught)
+		case fled:
+			b.emit(BattleEvent{Kind: "catch", Side: side, Target: target, Text: fmt.Sprintf("Oh no! The wild %s broke free and ran away!", target)})
+			b.finish(-1, outcomeEscaped)
+		default:
+			b.emit(BattleEvent{Kind: "catch", Side: side, Target: target, Text: fmt.Sprintf("Argh! The wild %s broke free!", target)})
+		}
+	case actionItem:
+		b.useItem(side, act.item)
+	case actionMove:
+		b.useMove(side, act.move)
+	}
+}
+
+// useMove makes the active Pokémon of a side attack the opposing active Pokémon.
+func (b *Battle) useMove(side, index int) {
+	own := b.sides[side]
+	other := b.sides[1-side]
+	attacker, defender := own.current(), other.current()
+
+	moves := movesFor(attacker.pokemon)
+	if index < 0 || index >= len(moves) {
+		index = 0
+	}
+	move := moves[index]
+	if !b.canMove(side, attacker) {
+		return
+	}
+
+	if move.targetsOpponent() && !b.hits(attacker, defender) {
+		b.emit(BattleEvent{Kind: "miss", Side: side, Pokemon: attacker.pokemon.Name, Move: move.Name,
+			Text: fmt.Sprintf("%s used %s! %s avoided the attack!", own.label(attacker), move.Name, other.label(defender))})
+		return
+	}
+
+	if move.damage != nil && defender.immuneTo(attackType(attacker, defender, move)) {
+		b.emit(BattleEvent{Kind: "ability", Side: 1 - side, Pokemon: defender.pokemon.Name, Move: move.Name, Ability: defender.ability().Name,
+			Text: fmt.Sprintf("%s used %s! It doesn't affect %s because of its %s...",
+				own.label(attacker), move.Name, other.label(defender), defender.ability().Name)})
+		return
+	}
+
+	if move.damage != nil {
+		damage := moveDamage(attacker, defender, move)
+		defender.hp -= damage
+		if defender.hp < 0 {
+			defender.hp = 0
+		}
+		b.emit(BattleEvent{Kind: "move", Side: side, Pokemon: attacker.pokemon.Name, Target: defender.pokemon.Name,
+			Move: move.Name, Damage: damage, HP: defender.hp,
+			Text: fmt.Sprintf("%s used %s! %s took %d damage (%d/%d HP).",
+				own.label(attacker), move.Name, other.label(defender), damage, defender.hp, defender.maxHP)})
+		b.damageTaken(1-side, defender, attacker, move, damage)
+	} else {
+		b.emit(BattleEvent{Kind: "move", Side: side, Pokemon: attacker.pokemon.Name, Target: defender.pokemon.Name,
+			Move: move.Name, HP: defender.hp, Text: fmt.Sprintf("%s used %s!", own.label(attacker), move.Name)})
+	}
+
+	// Side effects: a status for the target and stage changes for either Pokémon.
+	// Status moves say so when none of them took effect.
+	effect := false
+	if move.Status != "" && !defender.fainted() {
+		effect = b.rng.Float64() < move.StatusChance && b.inflictStatus(1-side, defender, move.Status)
+	}
+	for _, change := range move.Stages {
+		if change.Self {
+			effect = b.changeStage(side, attacker, change) || effect
+		} else if !defender.fainted() {
+			effect = b.changeStage(1-side, defender, change) || effect
+		}
+	}
+	if !effect && move.damage == nil && move.Status != "" {
+		b.emit(BattleEvent{Kind: "miss", Side: side, Pokemon: attacker.pokemon.Name, Move: move.Name, Text: "But it failed!"})
+	}
+
+	if defender.fainted() {
+		b.faint(1-side, defender)
+	}
+}
+
+// faint handles a fainted Pokémon: the side sends out its next Pokémon or loses.
+func (b *Battle) faint(side int, c *combatant) {
+	s := b.sides[side]
+	b.emit(BattleEvent{Kind: "faint", Side: side, Pokemon: c.pokemon.Name, Text: fmt.Sprintf("%s fainted!", s.label(c))})
+	if b.onFaint != nil {
+		b.onFaint(b, side, c)
+	}
+	c.resetStages()
+
+	if s.defeated() {
+		b.finish(1-side, outcomeWin)
+		return
+	}
+	for i, next := range s.team {
+		if !next.fainted() {
+			s.active = i
+			break
+		}
+	}
+	b.emit(BattleEvent{Kind: "switch", Side: side, Pokemon: s.current().pokemon.Name, Text: s.sendOut()})
+	b.switchIn(side)
+}
+
+// humanController lets a connected client choose actions by typing them.
+type humanController struct {
+	client *Client
+}
+
+// chooseAction shows the state of the battle and reads the client's choice.
+// A client that disconnects forfeits.
+func (h *humanController) chooseAction(b *Battle, side int) action {
+	conn := h.client.conn
+	own, other := b.sides[side].current(), b.sides[1-side].current()
+	moves := movesFor(own.pokemon)
+
+	fmt.Fprintf(conn, "Your %s%s: %d/%d HP%s | %s%s: %d/%d HP%s\n",
+		own.pokemon.Name, statusTag(own.status), own.hp, own.maxHP, own.describeStages(),
+		b.sides[1-side].label(other), statusTag(other.status), other.hp, other.maxHP, other.describeStages())
+	fmt.Fprintf(conn, "What will %s do?\n", own.pokemon.Name)
+	for i, move := range moves {
+		fmt.Fprintf(conn, "%d. %s%s\n", i+1, move.Name, move.describeEffects())
+	}
+	canRun := b.sides[1-side].wild
+	canUse := b.rules.Items && b.onItem != nil
+	canCatch := canRun && canUse && b.onCatch != nil
+	var options []string
+	if canUse {
+		options = append(options, "'use <item>' to use a potion or heal from your bag")
+	}
+	if canCatch {
+		options = append(options, "'catch [ball]' to throw a Poké Ball")
+	}
+	if canRun {
+		options = append(options, "'run' to run away")
+	}
+	switch n := len(options); {
+	case n == 1:
+		fmt.Fprintf(conn, "Or type %s.\n", options[0])
+	case n > 1:
+		fmt.Fprintf(conn, "Or type %s or %s.\n", strings.Join(options[:n-1], ", "), options[n-1])
+	}
+
+	for {
+		fmt.Fprint(conn, "Choose your move: ")
+		if b.rules.TurnTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(b.rules.TurnTimeout))
+		}
+		input, err := h.client.reader.ReadString('\n')
+		conn.SetReadDeadline(time.Time{})
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return h.timedOut(b, side)
+		}
+		if err != nil {
+			return action{kind: actionForfeit}
+		}
+		input = strings.ToLower(strings.TrimSpace(input))
+
+		verb, rest, _ := strings.Cut(input, " ")
+		switch {
+		case canCatch && verb == "catch":
+			if act, ok := h.chooseBall(rest); ok {
+				return act
+			}
+			continue
+		case canUse && verb == "use":
+			if act, ok := h.chooseItem(rest, canCatch); ok {
+				return act
+			}
+			continue
+		case canRun && (input == "run" || input == "flee"):
+			return action{kind: actionRun}
+		}
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(moves) {
+			fmt.Fprintln(conn, "Invalid choice. Try again.")
+			continue
+		}
+		return action{kind: actionMove, move: choice - 1}
+	}
+}
+
+// chooseBall picks the ball to throw, the weakest one in the bag if none is named.
+func (h *humanController) chooseBall(name string) (action, bool) {
+	key := itemKey(name)
+	if key == "" {
+		player := h.client.player
+		player.mutex.Lock()
+		key = defaultBall(player.Bag)
+		player.mutex.Unlock()
+		if key == "" {
+			fmt.Fprintln(h.client.conn, "You have no Poké Balls left!")
+			return action{}, false
+		}
+	}
+	if item := items[key]; item == nil || item.Kind != itemBall {
+		fmt.Fprintf(h.client.conn, "%q is not a Poké Ball.\n", name)
+		return action{}, false
+	}
+	if countItem(h.client.player, key) == 0 {
+		fmt.Fprintf(h.client.conn, "You have no %s.\n", itemName(key))
+		return action{}, false
+	}
+	return action{kind: actionCatch, item: key}, true
+}
+
+// chooseItem checks an item the client wants to use in battle.
+func (h *humanController) chooseItem(name string, canCatch bool) (action, bool) {
+	key := itemKey(name)
+	item := items[key]
+	switch {
+	case item == nil:
+		fmt.Fprintf(h.client.conn, "There is no item called %q.\n", name)
+		return action{}, false
+	case item.Kind == itemBall && canCatch:
+		return h.chooseBall(name)
+	case item.Kind != itemPotion && item.Kind != itemHeal:
+		fmt.Fprintf(h.client.conn, "The %s can't be used in this battle.\n", item.Name)
+		return action{}, false
+	case countItem(h.client.player, key) == 0:
+		fmt.Fprintf(h.client.conn, "You have no %s.\n", item.Name)
+		return action{}, false
+	}
+	return action{kind: actionItem, item: key}, true
+}
+
+// timedOut picks the action of a client who did not choose in time: the move that deals the most
+// damage, or forfeiting if the rules say so.
+func (h *humanController) timedOut(b *Battle, side int) action {
+	fmt.Fprintln(h.client.conn)
+	if b.rules.TimeoutForfeits {
+		fmt.Fprintln(h.client.conn, "Time's up!")
+		return action{kind: actionForfeit}
+	}
+	act := (&greedyController{}).chooseAction(b, side)
+	own := b.sides[side].current().pokemon
+	fmt.Fprintf(h.client.conn, "Time's up! %s will use %s.\n", own.Name, movesFor(own)[act.move].Name)
+	return act
+}
+
+// notify sends battle events to the client: their text, or one JSON object per line if the
+// client asked for JSON with the events command.
+func (h *humanController) notify(b *Battle, event BattleEvent) {
+	if !h.client.jsonEvents {
+		fmt.Fprintln(h.client.conn, event.Text)
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding battle event: %v", err)
+		return
+	}
+	fmt.Fprintf(h.client.conn, "%s\n", data)
+}
+
+// cmdEvents chooses how the client receives battle events: as text or as JSON lines.
+func cmdEvents(client *Client, args []string, pokedex []Pokemon) {
+	if len(args) == 0 {
+		format := "text"
+		if client.jsonEvents {
+			format = "json"
+		}
+		fmt.Fprintf(client.conn, "Battle events are sent as %s. Use 'events <text|json>' to change it.\n", format)
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "text":
+		client.jsonEvents = false
+		fmt.Fprintln(client.conn, "Battle events will be sent as text.")
+	case "json":
+		client.jsonEvents = true
+		fmt.Fprintln(client.conn, "Battle events will be sent as JSON, one object per line.")
+	default:
+		fmt.Fprintln(client.conn, "Usage: events <text|json>")
+	}
+}
+
+// silentController ignores events; it stands in for the players in cloned battles.
+type silentController struct{}
+
+func (silentController) chooseAction(b *Battle, side int) action {
+	return action{kind: actionMove}
+}
+
+func (silentController) notify(b *Battle, event BattleEvent) {}
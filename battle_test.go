@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyFormats(t *testing.T) {
+	event := BattleEvent{Turn: 2, Kind: "status", Side: 1, Pokemon: "pidgey", Status: statusParalysis,
+		Text: "The wild pidgey is paralyzed! It may be unable to move!"}
+	client, conn := testClient(Pokemon{})
+	h := &humanController{client: client}
+
+	h.notify(nil, event)
+	if got := conn.take(); got != event.Text+"\n" {
+		t.Errorf("text event %q, want %q", got, event.Text+"\n")
+	}
+
+	cmdEvents(client, []string{"json"}, nil)
+	conn.take()
+	h.notify(nil, event)
+	h.notify(nil, event)
+	lines := strings.Split(strings.TrimSuffix(conn.take(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines for 2 JSON events: %q", len(lines), lines)
+	}
+	var got BattleEvent
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("JSON event %q: %v", lines[0], err)
+	}
+	if got != event {
+		t.Errorf("JSON event decodes to %+v, want %+v", got, event)
+	}
+
+	cmdEvents(client, []string{"text"}, nil)
+	conn.take()
+	h.notify(nil, event)
+	if got := conn.take(); got != event.Text+"\n" {
+		t.Errorf("text event after switching back %q, want %q", got, event.Text+"\n")
+	}
+}
+
+// scriptedController takes the same action every turn and keeps the events it is sent.
+type scriptedController struct {
+	act    action
+	events []BattleEvent
+}
+
+func (s *scriptedController) chooseAction(b *Battle, side int) action { return s.act }
+
+func (s *scriptedController) notify(b *Battle, event BattleEvent) {
+	s.events = append(s.events, event)
+}
+
+// lastEvent returns the last event of the given kind, or the zero event.
+func (s *scriptedController) lastEvent(kind string) BattleEvent {
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].Kind == kind {
+			return s.events[i]
+		}
+	}
+	return BattleEvent{}
+}
+
+// withdraw is the move of water Pokémon that only raises their Defense.
+var withdraw = action{kind: actionMove, move: 2}
+
+func TestBattleRuleOutcomes(t *testing.T) {
+	normalAttack := action{kind: actionMove, move: 0}
+	tests := []struct {
+		name      string
+		rules     BattleRules
+		acts      [2]action
+		hurt      int // HP red has lost before the battle
+		turns     int
+		outcome   string
+		winner    int
+		stalemate string // Text of the stalemate event
+	}{
+		{"turn limit", BattleRules{MaxTurns: 3}, [2]action{normalAttack, normalAttack}, 0,
+			3, outcomeStalemate, -1, "The turn limit of 3 turns has been reached."},
+		{"turn limit with tiebreak", BattleRules{MaxTurns: 3, Tiebreak: true}, [2]action{normalAttack, withdraw}, 0,
+			3, outcomeTiebreak, 0, "The turn limit of 3 turns has been reached."},
+		{"no progress", BattleRules{MaxTurns: 100, NoProgressTurns: 4}, [2]action{withdraw, withdraw}, 0,
+			4, outcomeStalemate, -1, "No damage has been dealt for 4 turns."},
+		{"no progress with tiebreak", BattleRules{NoProgressTurns: 4, Tiebreak: true}, [2]action{withdraw, withdraw}, 500,
+			4, outcomeTiebreak, 1, "No damage has been dealt for 4 turns."},
+		{"tiebreak with the same share of HP", BattleRules{NoProgressTurns: 4, Tiebreak: true}, [2]action{withdraw, withdraw}, 0,
+			4, outcomeStalemate, -1, "No damage has been dealt for 4 turns."},
+		// Red's attack only gets through blue's Defense before blue has used Withdraw once.
+		{"no progress after the last damage", BattleRules{MaxTurns: 100, NoProgressTurns: 4}, [2]action{normalAttack, withdraw}, 0,
+			5, outcomeStalemate, -1, "No damage has been dealt for 4 turns."},
+	}
+	for _, tt := range tests {
+		red, blue := testPokemon("squirtle", "", "water"), testPokemon("psyduck", "", "water")
+		red.HP, blue.HP = 1000, 1000
+		red.Attack = 60 // Normal Attack deals Attack minus Defense
+		red.HPLost = tt.hurt
+		watch := &scriptedController{act: tt.acts[0]}
+		b := newBattle(
+			newTeamSide("red", []*Pokemon{red}, watch),
+			newTeamSide("blue", []*Pokemon{blue}, &scriptedController{act: tt.acts[1]}),
+			tt.rules,
+			newRNG(1),
+		)
+		b.run(context.Background())
+
+		if b.turn != tt.turns || b.outcome != tt.outcome || b.winner != tt.winner {
+			t.Errorf("%s: turn %d, outcome %s, winner %d; want turn %d, %s, %d",
+				tt.name, b.turn, b.outcome, b.winner, tt.turns, tt.outcome, tt.winner)
+		}
+		if !b.stalemated() {
+			t.Errorf("%s: not counted as stopped by a limit", tt.name)
+		}
+		if got := watch.lastEvent("stalemate").Text; got != tt.stalemate {
+			t.Errorf("%s: stopped with %q, want %q", tt.name, got, tt.stalemate)
+		}
+		if end := watch.lastEvent("end"); end.Side != tt.winner {
+			t.Errorf("%s: end event for side %d, want %d", tt.name, end.Side, tt.winner)
+		}
+	}
+}
+
+// timeoutError is what reading from a connection returns once its read deadline has passed.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTurnTimeout(t *testing.T) {
+	for _, forfeits := range []bool{false, true} {
+		charmander, bulbasaur := testPokemon("charmander", "", "fire"), testPokemon("bulbasaur", "", "grass")
+		client, conn := testClient(*charmander)
+		client.reader = bufio.NewReader(readerFunc(func(p []byte) (int, error) { return 0, timeoutError{} }))
+		rules := defaultBattleRules
+		rules.TimeoutForfeits = forfeits
+		b := newBattle(
+			newTeamSide("red", []*Pokemon{charmander}, &humanController{client: client}),
+			newTeamSide("blue", []*Pokemon{bulbasaur}, silentController{}),
+			rules,
+			newRNG(1),
+		)
+
+		act := b.sides[0].controller.chooseAction(b, 0)
+		out := conn.take()
+		switch {
+		case forfeits && act.kind != actionForfeit:
+			t.Errorf("timed out with forfeiting rules: %+v, want to forfeit", act)
+		case forfeits && !strings.Contains(out, "Time's up!"):
+			t.Errorf("timed out with forfeiting rules: %q", out)
+		case !forfeits && (act.kind != actionMove || movesFor(charmander)[act.move].Name != "Ember"):
+			t.Errorf("timed out: %+v, want the super-effective Ember", act)
+		case !forfeits && !strings.Contains(out, "Time's up! charmander will use Ember."):
+			t.Errorf("timed out: %q", out)
+		}
+	}
+}
+
+func TestRecordDraw(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	useTestWorld(t, clock)
+	a, _ := testClient(testPokedex[0])
+	b, _ := testClient(testPokedex[1])
+	b.player.ID = 2
+
+	recordDraw(a, b)
+	for _, tt := range []struct {
+		client   *Client
+		opponent int
+	}{{a, 2}, {b, 1}} {
+		id := tt.client.player.ID
+		want := BattleRecord{OpponentID: playerKey(tt.opponent), Result: resultDraw, Timestamp: clock.Now()}
+		if history := tt.client.player.BattleHistory; len(history) != 1 || history[0] != want {
+			t.Errorf("player %d history %+v, want %+v", id, history, want)
+		}
+		data, err := store.LoadPlayer(id)
+		if err != nil {
+			t.Fatalf("player %d: %v", id, err)
+		}
+		if len(data.BattleHistory) != 1 || data.BattleHistory[0].Result != resultDraw {
+			t.Errorf("player %d saved history %+v, want the draw", id, data.BattleHistory)
+		}
+	}
+}
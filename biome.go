@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Biomes of the world map. Every tile belongs to one, which decides the Pokémon that spawn on it.
+const (
+	biomeGrass    = "grass"
+	biomeForest   = "forest"
+	biomeWater    = "water"
+	biomeMountain = "mountain"
+	biomeCave     = "cave"
+)
+
+// biomeSymbols are the characters of a map file, one per tile.
+var biomeSymbols = map[byte]string{
+	'.': biomeGrass,
+	'f': biomeForest,
+	'~': biomeWater,
+	'^': biomeMountain,
+	'c': biomeCave,
+}
+
+// biomeWeights are the relative sizes of the biomes in a generated map, in a stable order.
+var biomeWeights = []struct {
+	biome  string
+	weight int
+}{
+	{biomeGrass, 35},
+	{biomeForest, 20},
+	{biomeWater, 20},
+	{biomeMountain, 15},
+	{biomeCave, 10},
+}
+
+// biomeRegionSize is the average width of a biome region in a generated map, in tiles.
+const biomeRegionSize = 50
+
+// biomeMap tells which biome a tile belongs to.
+type biomeMap interface {
+	biomeAt(x, y int) string
+}
+
+// generatedBiomes is a map generated from a seed. The world is cut into squares of
+// biomeRegionSize tiles; each square holds a point with a random biome, and every tile takes
+// the biome of the closest point, which gives irregular regions. Nothing is stored: any tile
+// can be looked up from the seed alone.
+type generatedBiomes struct {
+	seed int64
+}
+
+// mix hashes a seed and a square of the map (splitmix64), so each square gets its own random values.
+func mix(seed int64, cx, cy int) uint64 {
+	z := uint64(seed) ^ uint64(cx)*0x9e3779b97f4a7c15 ^ uint64(cy)*0xc2b2ae3d27d4eb4f
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// region returns the point and biome of a square.
+func (g generatedBiomes) region(cx, cy int) (px, py int, biome string) {
+	h := mix(g.seed, cx, cy)
+	px = cx*biomeRegionSize + int(h%biomeRegionSize)
+	py = cy*biomeRegionSize + int((h>>16)%biomeRegionSize)
+
+	total := 0
+	for _, b := range biomeWeights {
+		total += b.weight
+	}
+	roll := int((h >> 32) % uint64(total))
+	for _, b := range biomeWeights {
+		if roll -= b.weight; roll < 0 {
+			return px, py, b.biome
+		}
+	}
+	return px, py, biomeGrass
+}
+
+func (g generatedBiomes) biomeAt(x, y int) string {
+	cx, cy := floorDiv(x, biomeRegionSize), floorDiv(y, biomeRegionSize)
+	best, bestDistance := biomeGrass, -1
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			px, py, biome := g.region(cx+dx, cy+dy)
+			if d := (px-x)*(px-x) + (py-y)*(py-y); bestDistance < 0 || d < bestDistance {
+				best, bestDistance = biome, d
+			}
+		}
+	}
+	return best
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return (a - b + 1) / b
+	}
+	return a / b
+}
+
+// fileBiomes is a map drawn in a text file, one line per row and one character per tile
+// (see biomeSymbols). The drawing is stretched over the whole world, so a small file can
+// describe a large world.
+type fileBiomes struct {
+	rows          []string
+	width, height int // Size of the world
+}
+
+// loadBiomeMap reads a map file for a world of the given size.
+func loadBiomeMap(filename string, width, height int) (*fileBiomes, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &fileBiomes{width: width, height: height}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		row := strings.TrimRight(scanner.Text(), "\r")
+		if row == "" {
+			continue
+		}
+		if len(m.rows) > 0 && len(row) != len(m.rows[0]) {
+			return nil, fmt.Errorf("%s: line %d has %d tiles, expected %d", filename, len(m.rows)+1, len(row), len(m.rows[0]))
+		}
+		for i := 0; i < len(row); i++ {
+			if _, ok := biomeSymbols[row[i]]; !ok {
+				return nil, fmt.Errorf("%s: unknown tile %q on line %d", filename, row[i], len(m.rows)+1)
+			}
+		}
+		m.rows = append(m.rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.rows) == 0 {
+		return nil, fmt.Errorf("%s: empty map", filename)
+	}
+	return m, nil
+}
+
+func (m *fileBiomes) biomeAt(x, y int) string {
+	row := m.rows[y*len(m.rows)/m.height]
+	return biomeSymbols[row[x*len(row)/m.width]]
+}
+
+// SpawnEntry is one line of a biome's spawn table: a species, or every species of a type,
+// with its weight and the levels it spawns at. Legendary species only spawn from entries that
+// name them.
+type SpawnEntry struct {
+	Species  string `json:"species,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Weight   int    `json:"weight"`
+	MinLevel int    `json:"min_level"`
+	MaxLevel int    `json:"max_level"`
+}
+
+// legendaries are the species that never spawn through a type entry.
+var legendaries = map[string]bool{
+	"articuno": true, "zapdos": true, "moltres": true, "mewtwo": true, "mew": true,
+}
+
+// defaultSpawnTables are the spawn tables used unless -spawn-tables gives others.
+var defaultSpawnTables = map[string][]SpawnEntry{
+	biomeGrass: {
+		{Type: "normal", Weight: 300, MinLevel: 2, MaxLevel: 25},
+		{Type: "grass", Weight: 200, MinLevel: 2, MaxLevel: 25},
+		{Type: "bug", Weight: 200, MinLevel: 2, MaxLevel: 20},
+		{Type: "flying", Weight: 150, MinLevel: 5, MaxLevel: 30},
+		{Type: "electric", Weight: 80, MinLevel: 5, MaxLevel: 30},
+		{Type: "fairy", Weight: 70, MinLevel: 5, MaxLevel: 25},
+	},
+	biomeForest: {
+		{Type: "bug", Weight: 300, MinLevel: 3, MaxLevel: 25},
+		{Type: "grass", Weight: 300, MinLevel: 5, MaxLevel: 30},
+		{Type: "poison", Weight: 150, MinLevel: 5, MaxLevel: 30},
+		{Type: "psychic", Weight: 80, MinLevel: 10, MaxLevel: 35},
+		{Type: "dark", Weight: 70, MinLevel: 10, MaxLevel: 35},
+		{Type: "ghost", Weight: 50, MinLevel: 15, MaxLevel: 35},
+		{Species: "mew", Weight: 1, MinLevel: 40, MaxLevel: 60},
+	},
+	biomeWater: {
+		{Type: "water", Weight: 700, MinLevel: 5, MaxLevel: 40},
+		{Type: "ice", Weight: 100, MinLevel: 20, MaxLevel: 50},
+		{Type: "dragon", Weight: 30, MinLevel: 15, MaxLevel: 40},
+		{Species: "articuno", Weight: 1, MinLevel: 50, MaxLevel: 60},
+	},
+	biomeMountain: {
+		{Type: "rock", Weight: 300, MinLevel: 10, MaxLevel: 40},
+		{Type: "ground", Weight: 250, MinLevel: 10, MaxLevel: 40},
+		{Type: "fighting", Weight: 200, MinLevel: 10, MaxLevel: 40},
+		{Type: "fire", Weight: 150, MinLevel: 10, MaxLevel: 40},
+		{Type: "flying", Weight: 100, MinLevel: 15, MaxLevel: 45},
+		{Species: "moltres", Weight: 1, MinLevel: 50, MaxLevel: 60},
+		{Species: "zapdos", Weight: 1, MinLevel: 50, MaxLevel: 60},
+	},
+	biomeCave: {
+		{Type: "rock", Weight: 250, MinLevel: 15, MaxLevel: 45},
+		{Type: "ground", Weight: 200, MinLevel: 15, MaxLevel: 45},
+		{Type: "poison", Weight: 150, MinLevel: 10, MaxLevel: 40},
+		{Type: "ghost", Weight: 150, MinLevel: 15, MaxLevel: 45},
+		{Type: "psychic", Weight: 100, MinLevel: 15, MaxLevel: 45},
+		{Type: "steel", Weight: 50, MinLevel: 15, MaxLevel: 45},
+		{Type: "dark", Weight: 50, MinLevel: 15, MaxLevel: 45},
+		{Species: "mewtwo", Weight: 1, MinLevel: 70, MaxLevel: 80},
+	},
+}
+
+// loadSpawnTables reads spawn tables from a JSON file of the same shape as defaultSpawnTables.
+func loadSpawnTables(filename string) (map[string][]SpawnEntry, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var tables map[string][]SpawnEntry
+	if err := json.Unmarshal(raw, &tables); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for biome, entries := range tables {
+		if !knownBiome(biome) {
+			return nil, fmt.Errorf("%s: unknown biome %q", filename, biome)
+		}
+		for _, e := range entries {
+			if (e.Species == "") == (e.Type == "") || e.Weight < 0 || e.MinLevel < 1 || e.MaxLevel < e.MinLevel || e.MaxLevel > maxLevel {
+				return nil, fmt.Errorf("%s: invalid entry %+v for %s: set either species or type, "+
+					"a weight of at least 0 and levels between 1 and %d", filename, e, biome, maxLevel)
+			}
+		}
+	}
+	return tables, nil
+}
+
+func knownBiome(biome string) bool {
+	for _, b := range biomeWeights {
+		if b.biome == biome {
+			return true
+		}
+	}
+	return false
+}
+
+// spawnOption is a spawn table entry with the Pokédex species it stands for.
+type spawnOption struct {
+	entry   SpawnEntry
+	species []*Pokemon
+}
+
+// spawnTable picks the wild Pokémon of one biome.
+type spawnTable struct {
+	options []spawnOption
+	total   int
+}
+
+// buildSpawnTables matches the entries of the tables with the Pokédex. Entries without any
+// matching species are left out.
+func buildSpawnTables(tables map[string][]SpawnEntry, pokedex []Pokemon) map[string]*spawnTable {
+	built := make(map[string]*spawnTable)
+	for biome, entries := range tables {
+		table := &spawnTable{}
+		for _, e := range entries {
+			option := spawnOption{entry: e}
+			for i := range pokedex {
+				p := &pokedex[i]
+				if p.Name == e.Species || (e.Type != "" && !legendaries[p.Name] && hasType(p, e.Type)) {
+					option.species = append(option.species, p)
+				}
+			}
+			if len(option.species) > 0 && e.Weight > 0 {
+				table.options = append(table.options, option)
+				table.total += e.Weight
+			}
+		}
+		built[biome] = table
+	}
+	return built
+}
+
+func hasType(p *Pokemon, t string) bool {
+	for _, own := range p.Type {
+		if own == t {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses an entry by weight, then one of its species and a level in its range.
+func (t *spawnTable) pick(rng RNG) (species *Pokemon, level int, ok bool) {
+	if t == nil || t.total == 0 {
+		return nil, 0, false
+	}
+	roll := rng.Intn(t.total)
+	for _, option := range t.options {
+		if roll -= option.entry.Weight; roll < 0 {
+			e := option.entry
+			return option.species[rng.Intn(len(option.species))], e.MinLevel + rng.Intn(e.MaxLevel-e.MinLevel+1), true
+		}
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBiomeMap writes a map file into a temporary directory and returns its path.
+func writeBiomeMap(t *testing.T, drawing string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "map.txt")
+	if err := os.WriteFile(path, []byte(drawing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileBiomes(t *testing.T) {
+	// Each tile of the drawing covers 2x2 tiles of the world.
+	m, err := loadBiomeMap(writeBiomeMap(t, "~.\r\n^c\n\n"), 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		x, y int
+		want string
+	}{
+		{0, 0, biomeWater}, {1, 1, biomeWater},
+		{2, 0, biomeGrass}, {3, 1, biomeGrass},
+		{0, 2, biomeMountain}, {1, 3, biomeMountain},
+		{2, 2, biomeCave}, {3, 3, biomeCave},
+	}
+	for _, tt := range tests {
+		if got := m.biomeAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("(%d, %d) is %s, want %s", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	for drawing, want := range map[string]string{
+		"~.\n^":  "line 2 has 1 tiles, expected 2",
+		"~.\n^x": `unknown tile 'x' on line 2`,
+		"\n\n":   "empty map",
+	} {
+		if _, err := loadBiomeMap(writeBiomeMap(t, drawing), 4, 4); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("map %q: %v, want an error containing %q", drawing, err, want)
+		}
+	}
+}
+
+func TestGeneratedBiomes(t *testing.T) {
+	a, b := generatedBiomes{seed: 1}, generatedBiomes{seed: 1}
+	seen := make(map[string]bool)
+	for x := -100; x < 500; x += 7 {
+		for y := -100; y < 500; y += 7 {
+			biome := a.biomeAt(x, y)
+			if !knownBiome(biome) {
+				t.Fatalf("(%d, %d) is in unknown biome %q", x, y, biome)
+			}
+			if again := b.biomeAt(x, y); again != biome {
+				t.Fatalf("(%d, %d) is %s, then %s with the same seed", x, y, biome, again)
+			}
+			seen[biome] = true
+		}
+	}
+	if len(seen) != len(biomeWeights) {
+		t.Errorf("found the biomes %v, want all %d", seen, len(biomeWeights))
+	}
+}
+
+func TestBuildSpawnTables(t *testing.T) {
+	pokedex := []Pokemon{
+		{Name: "squirtle", Type: []string{"water"}},
+		{Name: "lapras", Type: []string{"water", "ice"}},
+		{Name: "articuno", Type: []string{"ice", "flying"}},
+		{Name: "pikachu", Type: []string{"electric"}},
+	}
+	tables := buildSpawnTables(map[string][]SpawnEntry{
+		biomeWater: {
+			{Type: "water", Weight: 10, MinLevel: 5, MaxLevel: 10},
+			{Type: "ice", Weight: 4, MinLevel: 5, MaxLevel: 10},          // Not articuno, a legendary
+			{Species: "articuno", Weight: 1, MinLevel: 50, MaxLevel: 50}, // Named, so it spawns
+			{Type: "fire", Weight: 5, MinLevel: 5, MaxLevel: 10},         // No fire species: left out
+			{Type: "electric", Weight: 0, MinLevel: 5, MaxLevel: 10},     // Weight 0: left out
+		},
+	}, pokedex)
+
+	water := tables[biomeWater]
+	if water.total != 15 || len(water.options) != 3 {
+		t.Fatalf("water table has %d options weighing %d, want 3 weighing 15", len(water.options), water.total)
+	}
+	want := [][]string{{"squirtle", "lapras"}, {"lapras"}, {"articuno"}}
+	for i, option := range water.options {
+		var names []string
+		for _, p := range option.species {
+			names = append(names, p.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(want[i], ",") {
+			t.Errorf("option %d is %v, want %v", i, names, want[i])
+		}
+	}
+	if tables[biomeCave] != nil {
+		t.Error("a biome without a table got one")
+	}
+}
+
+func TestSpawnTablePick(t *testing.T) {
+	table := buildSpawnTables(map[string][]SpawnEntry{biomeMountain: {
+		{Species: "geodude", Weight: 3, MinLevel: 10, MaxLevel: 20},
+		{Species: "rattata", Weight: 1, MinLevel: 2, MaxLevel: 4},
+	}}, testPokedex)[biomeMountain]
+	tests := []struct {
+		roll  float64
+		name  string
+		level int
+	}{
+		{0, "geodude", 10},
+		{0.7, "geodude", 17}, // Rolls 2 of 4 for the entry and 7 of 11 for the level
+		{0.75, "rattata", 4},
+		{0.99, "rattata", 4},
+	}
+	for _, tt := range tests {
+		species, level, ok := table.pick(fixedRNG{tt.roll})
+		if !ok || species.Name != tt.name || level != tt.level {
+			t.Errorf("roll %v: %v at level %d, want %s at level %d", tt.roll, species, level, tt.name, tt.level)
+		}
+	}
+	var none *spawnTable
+	if _, _, ok := none.pick(newRNG(1)); ok {
+		t.Error("picked from a biome without a table")
+	}
+}
+
+// TestSpawnUsesBiomeTable spawns in a world that is half water and half mountain and checks that
+// every wild Pokémon comes from the table of its tile's biome.
+func TestSpawnUsesBiomeTable(t *testing.T) {
+	pw := newTestWorld(1, newFakeClock(time.Now()))
+	biomes, err := loadBiomeMap(writeBiomeMap(t, "~^\n"), pw.Width, pw.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.biomes = biomes
+	pw.spawnTables = buildSpawnTables(map[string][]SpawnEntry{
+		biomeWater:    {{Species: "magikarp", Weight: 1, MinLevel: 5, MaxLevel: 10}},
+		biomeMountain: {{Species: "geodude", Weight: 1, MinLevel: 20, MaxLevel: 25}},
+	}, testPokedex)
+	pw.spawnPokemon(20)
+
+	pw.Lock()
+	defer pw.Unlock()
+	spawned := 0
+	for x := range pw.grid {
+		for _, p := range pw.grid[x] {
+			if p == nil {
+				continue
+			}
+			spawned++
+			want, min, max := "magikarp", 5, 10
+			if x >= pw.Width/2 {
+				want, min, max = "geodude", 20, 25
+			}
+			if p.Name != want || p.Level < min || p.Level > max {
+				t.Errorf("%s at level %d in the %s at x %d, want %s at %d-%d", p.Name, p.Level, biomes.biomeAt(x, 0), x, want, min, max)
+			}
+		}
+	}
+	if spawned == 0 {
+		t.Fatal("nothing spawned")
+	}
+}
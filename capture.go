@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Encounter is a meeting between a player and a wild Pokémon in the world.
+type Encounter struct {
+	wild     *Pokemon
+	x, y     int // Tile the wild Pokémon stands on
+	hp       int // Current HP of the wild Pokémon; fighting it lowers it
+	attempts int // Failed catch attempts so far
+}
+
+// catchRate returns the base catch rate of a species from its rarity.
+// Species that yield more base experience are rarer and harder to catch.
+func catchRate(p *Pokemon) float64 {
+	rate := 1 - float64(p.BaseExp)/400
+	if rate < 0.05 {
+		rate = 0.05
+	}
+	if rate > 0.95 {
+		rate = 0.95
+	}
+	return rate
+}
+
+// catchProbability returns the chance of catching a wild Pokémon.
+// Weakened Pokémon are easier to catch: at full HP the chance is a third of that at 1 HP.
+// Higher levels halve the chance at most. ballBonus multiplies the result.
+func catchProbability(wild *Pokemon, currentHP int, ballBonus float64) float64 {
+	maxHP := wild.HP
+	if maxHP < 1 {
+		maxHP = 1
+	}
+	if currentHP > maxHP {
+		currentHP = maxHP
+	}
+	if currentHP < 1 {
+		currentHP = 1
+	}
+	hpFactor := float64(3*maxHP-2*currentHP) / float64(3*maxHP)
+
+	level := wild.Level
+	if level < 1 {
+		level = 1
+	}
+	levelFactor := 1 - 0.5*float64(level-1)/float64(maxLevel-1)
+
+	p := catchRate(wild) * hpFactor * levelFactor * ballBonus
+	if p > 1 {
+		p = 1
+	}
+	return p
+}
+
+// fleeProbability returns the chance that a wild Pokémon runs away after a failed catch attempt.
+// It grows with every failed attempt.
+func fleeProbability(attempts int) float64 {
+	p := 0.1 * float64(attempts)
+	if p > 0.5 {
+		p = 0.5
+	}
+	return p
+}
+
+// attemptCatch rolls a single catch attempt. attempts counts the failed attempts before this one.
+// The result only depends on rng, so a seeded generator makes it reproducible.
+func attemptCatch(rng RNG, wild *Pokemon, currentHP int, ballBonus float64, attempts int) (caught, fled bool) {
+	if rng.Float64() < catchProbability(wild, currentHP, ballBonus) {
+		return true, false
+	}
+	return false, rng.Float64() < fleeProbability(attempts+1)
+}
+
+// boxFull reports whether the player can't hold any more Pokémon.
+func boxFull(player *Player) bool {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return len(player.Pokemons) >= currentConfig().World.MaxPokemonPerPlayer
+}
+
+// boxFullMessage tells the player why a catch is not possible.
+const boxFullMessage = "Your box is full (%d Pokémon)! Release some before catching more.\n"
+
+// startEncounter tells the client about a wild Pokémon and waits for them to catch it or flee.
+// A Pokémon weakened in an earlier fight still has only the HP it kept.
+func startEncounter(client *Client, wild *Pokemon, x, y int) {
+	pokeworld.Lock()
+	seen := *wild
+	pokeworld.Unlock()
+	client.encounter = &Encounter{wild: wild, x: x, y: y, hp: seen.currentHP()}
+	fmt.Fprintf(client.conn, "A wild %s appeared!\n", describePokemon(seen))
+	if boxFull(client.player) {
+		fmt.Fprintf(client.conn, boxFullMessage, currentConfig().World.MaxPokemonPerPlayer)
+		fmt.Fprintln(client.conn, "Type 'fight' to battle it or 'flee' to run away.")
+		return
+	}
+	fmt.Fprintln(client.conn, "Type 'fight' to battle it, 'catch [ball]' to throw a Poké Ball or 'flee' to run away.")
+}
+
+// cmdCatch throws a Poké Ball at the wild Pokémon of the current encounter: the named one,
+// or the weakest one in the bag.
+func cmdCatch(client *Client, args []string, pokedex []Pokemon) {
+	throwBall(client, itemKey(strings.Join(args, " ")))
+}
+
+// throwBall throws a ball from the client's bag at the wild Pokémon of the current encounter.
+// An empty key picks the weakest ball in the bag.
+func throwBall(client *Client, key string) {
+	enc := client.encounter
+	if enc == nil {
+		fmt.Fprintln(client.conn, "There is no wild Pokémon here.")
+		return
+	}
+	if boxFull(client.player) {
+		fmt.Fprintf(client.conn, boxFullMessage, currentConfig().World.MaxPokemonPerPlayer)
+		return
+	}
+	if key == "" {
+		player := client.player
+		player.mutex.Lock()
+		key = defaultBall(player.Bag)
+		player.mutex.Unlock()
+		if key == "" {
+			fmt.Fprintln(client.conn, "You have no Poké Balls left! Look around the world for more, or win battles to earn some.")
+			return
+		}
+	}
+	ball := items[key]
+	if ball == nil || ball.Kind != itemBall {
+		fmt.Fprintf(client.conn, "%s is not a Poké Ball.\n", itemName(key))
+		return
+	}
+	if !takeItem(client.player, key) {
+		fmt.Fprintf(client.conn, "You have no %s.\n", ball.Name)
+		return
+	}
+
+	// Whether the Pokémon is still there is only known under the world lock, once the ball is
+	// taken; if it is gone, the ball goes back in the bag.
+	caught, fled, present := pokeworld.catchWild(client, enc, ball.CatchBonus)
+	if !present {
+		client.encounter = nil
+		giveItem(client, key, "The wild "+enc.wild.Name+" is gone. You put the %s back in your bag.")
+		return
+	}
+	fmt.Fprintf(client.conn, "You threw a %s!\n", ball.Name)
+	switch {
+	case caught:
+		key := addToCollection(client.player, *enc.wild)
+		fmt.Fprintf(client.conn, "Gotcha! %s was caught! It is number %d in your collection.\n", enc.wild.Name, key)
+		client.encounter = nil
+	case fled:
+		fmt.Fprintf(client.conn, "Oh no! The wild %s broke free and ran away!\n", enc.wild.Name)
+		client.encounter = nil
+	default:
+		enc.attempts++
+		fmt.Fprintf(client.conn, "Argh! The wild %s broke free! Type 'catch' to try again or 'flee' to run away.\n", enc.wild.Name)
+	}
+}
+
+// catchWild rolls a catch attempt of the client for an encounter with the world's random number
+// generator.
+// Caught and fleeing Pokémon are removed from the world. present is false if the
+// Pokémon despawned or was caught by someone else before the attempt.
+func (pw *Pokeworld) catchWild(client *Client, enc *Encounter, ballBonus float64) (caught, fled, present bool) {
+	pw.Lock()
+	defer pw.Unlock()
+
+	if pw.grid[enc.x][enc.y] != enc.wild {
+		return false, false, false
+	}
+	caught, fled = attemptCatch(pw.rng, enc.wild, enc.hp, ballBonus, enc.attempts)
+	if caught || fled {
+		reason := leftCaught
+		if fled {
+			reason = leftFled
+		}
+		pw.removeWild(enc.x, enc.y, enc.wild, reason)
+	}
+	if caught {
+		pw.events.publish(WorldEvent{Kind: eventCapture, X: enc.x, Y: enc.y, Pokemon: enc.wild.Name,
+			Player: client.account.Username, player: client.player})
+	}
+	return caught, fled, true
+}
+
+// cmdFlee ends the current encounter.
+func cmdFlee(client *Client, args []string, pokedex []Pokemon) {
+	if client.encounter == nil {
+		fmt.Fprintln(client.conn, "There is nothing to run away from.")
+		return
+	}
+	fmt.Fprintln(client.conn, "Got away safely!")
+	client.encounter = nil
+}
+
+// cmdFight battles the wild Pokémon of the current encounter with the player's lead Pokémon.
+// The wild Pokémon fights back with random moves. Weakening it makes it easier to catch,
+// and defeating it earns the lead Pokémon experience.
+func cmdFight(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	enc := client.encounter
+	if enc == nil {
+		fmt.Fprintln(conn, "There is no wild Pokémon here.")
+		return
+	}
+	if pokeworld.wildAt(enc.x, enc.y) != enc.wild {
+		fmt.Fprintf(conn, "The wild %s is gone.\n", enc.wild.Name)
+		client.encounter = nil
+		return
+	}
+	client.setBattling(true)
+	defer client.setBattling(false)
+	lead := leadPokemon(client)
+	if lead == nil {
+		fmt.Fprintln(conn, "You have no Pokémon that can battle. Try to catch it instead, or heal your Pokémon first!")
+		return
+	}
+
+	// The battle works on a copy, since the world shares the wild Pokémon with other sessions.
+	battler := *enc.wild
+	wild := newCombatant(&battler)
+	wild.hp = enc.hp
+	rng := pokeworld.forkRNG()
+	b := newBattle(
+		newTeamSide(client.account.Username, []*Pokemon{lead}, &humanController{client: client}),
+		&battleSide{name: "The wild " + enc.wild.Name, wild: true, team: []*combatant{wild}, controller: &randomController{rng: rng}},
+		wildBattleRules,
+		rng,
+	)
+	b.onFaint = awardFaintExperience
+	b.onItem = bagItemHook(client)
+	if !boxFull(client.player) {
+		b.onCatch = func(b *Battle, side int, ball string) (caught, fled, present bool) {
+			enc.hp = wild.hp
+			caught, fled, present = pokeworld.catchWild(client, enc, items[ball].CatchBonus)
+			switch {
+			case !present:
+				putItem(client.player, ball)
+			case !caught && !fled:
+				enc.attempts++
+			}
+			return caught, fled, present
+		}
+	}
+
+	client.expGained = nil
+	client.roster = []*Pokemon{lead}
+	b.run(client.ctx)
+	keepBattleState(client)
+	enc.hp = wild.hp
+
+	// A wild Pokémon that is still around keeps the HP it lost and its status.
+	pokeworld.Lock()
+	if pokeworld.grid[enc.x][enc.y] == enc.wild {
+		enc.wild.HPLost, enc.wild.Status = battler.HPLost, battler.Status
+	}
+	pokeworld.Unlock()
+
+	switch {
+	case b.outcome == outcomeCaught:
+		key := addToCollection(client.player, battler)
+		fmt.Fprintf(conn, "%s is number %d in your collection.\n", enc.wild.Name, key)
+	case b.outcome == outcomeWin && b.winner == 0:
+		pokeworld.Lock()
+		pokeworld.removeWild(enc.x, enc.y, enc.wild, leftDefeated)
+		pokeworld.Unlock()
+		applyBattleExperience(client)
+	case b.outcome == outcomeWin:
+		fmt.Fprintf(conn, "You hurried away from the wild %s.\n", enc.wild.Name)
+	case b.stalemated():
+		fmt.Fprintf(conn, "The wild %s lost interest and wandered off.\n", enc.wild.Name)
+	}
+	client.encounter = nil
+}
+
+// leadPokemon returns a battle copy of the first Pokémon of the player's default team that has
+// not fainted, or of their collection if there is none. It returns nil if no Pokémon can battle.
+func leadPokemon(client *Client) *Pokemon {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	for _, keys := range [][]int{player.validTeam(), player.ableKeys()} {
+		for _, key := range keys {
+			pokemon := player.Pokemons[key]
+			if pokemon.fainted() {
+				continue
+			}
+			recomputeStats(&pokemon)
+			pokemon.Owner = client
+			pokemon.collectionKey = key
+			return &pokemon
+		}
+	}
+	return nil
+}
+
+// addToCollection adds a caught Pokémon to the player's collection, saves it
+// and returns its key.
+func addToCollection(player *Player, pokemon Pokemon) int {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	pokemon.Owner = nil
+	pokemon.wildID = 0
+	key := player.nextPokemonKey()
+	player.Pokemons[key] = pokemon
+
+	// Record the capture in the player's saved collection.
+	if err := store.AddCapture(player.ID, toSavedPokemon(key, pokemon)); err != nil {
+		log.Printf("Error saving capture for player %d: %v", player.ID, err)
+	}
+	return key
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatchProbability(t *testing.T) {
+	// Base experience 200 gives a catch rate of 0.5.
+	wild := func(hp, level int) *Pokemon { return &Pokemon{Name: "wild", BaseExp: 200, HP: hp, Level: level} }
+	tests := []struct {
+		name      string
+		wild      *Pokemon
+		currentHP int
+		ballBonus float64
+		want      float64
+	}{
+		{"full HP", wild(90, 1), 90, 1, 0.5 / 3},
+		{"1 HP", wild(90, 1), 1, 1, 0.5 * 268 / 270},
+		{"more than full HP counts as full", wild(90, 1), 200, 1, 0.5 / 3},
+		{"0 HP counts as 1", wild(90, 1), 0, 1, 0.5 * 268 / 270},
+		{"negative HP counts as 1", wild(90, 1), -5, 1, 0.5 * 268 / 270},
+		{"no max HP", wild(0, 1), 0, 1, 0.5 / 3},
+		{"max level halves the chance", wild(90, maxLevel), 90, 1, 0.5 / 3 / 2},
+		{"level 0 counts as 1", wild(90, 0), 90, 1, 0.5 / 3},
+		{"ball bonus", wild(90, 1), 90, 1.5, 0.25},
+		{"capped at 1", wild(90, 1), 1, 10, 1},
+		{"exactly 1", wild(90, 1), 90, 6, 1},
+	}
+	for _, tt := range tests {
+		if got := catchProbability(tt.wild, tt.currentHP, tt.ballBonus); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: catch probability %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAttemptCatch(t *testing.T) {
+	// The first two numbers of each seed: 1: 0.605, 0.941; 2: 0.167, 0.265; 4: 0.243, 0.102.
+	tests := []struct {
+		seed      int64
+		currentHP int
+		ballBonus float64
+		attempts  int
+		caught    bool
+		fled      bool
+	}{
+		{1, 1, 1, 0, false, false}, // 0.605 misses a chance of 0.496
+		{2, 1, 1, 0, true, false},
+		{4, 1, 1, 0, true, false},
+		{2, 90, 1, 0, false, false},  // 0.167 just misses a chance of 0.1667
+		{2, 90, 1.5, 0, true, false}, // but not a chance of 0.25
+		{4, 90, 1, 0, false, false},  // 0.102 escapes a flee chance of 0.1
+		{4, 90, 1, 1, false, true},   // but not one of 0.2
+		{1, 90, 1, 10, false, false}, // 0.941 escapes the highest flee chance of 0.5
+	}
+	for _, tt := range tests {
+		p := &Pokemon{Name: "wild", BaseExp: 200, HP: 90, Level: 1}
+		caught, fled := attemptCatch(newRNG(tt.seed), p, tt.currentHP, tt.ballBonus, tt.attempts)
+		if caught != tt.caught || fled != tt.fled {
+			t.Errorf("seed %d at %d HP, ball bonus %v, %d attempts: caught %v, fled %v; want %v, %v",
+				tt.seed, tt.currentHP, tt.ballBonus, tt.attempts, caught, fled, tt.caught, tt.fled)
+		}
+	}
+}
+
+func TestThrowBallKeepsBallWhenWildIsGone(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	client, conn := testClient(testPokedex[1])
+	client.account = &Account{Username: "ash", PlayerID: 1}
+	client.player.Bag = Bag{"poke-ball": 2}
+
+	// Someone else caught it, or it despawned, since the encounter started.
+	wild := testPokedex[0]
+	client.encounter = &Encounter{wild: &wild, x: 2, y: 2, hp: wild.HP}
+	throwBall(client, "poke-ball")
+	if n := client.player.Bag["poke-ball"]; n != 2 {
+		t.Errorf("%d Poké Balls left after throwing at a Pokémon that is gone, want 2", n)
+	}
+	if client.encounter != nil {
+		t.Error("the encounter goes on after the Pokémon is gone")
+	}
+	if out := conn.take(); !strings.Contains(out, "is gone") || strings.Contains(out, "You threw") {
+		t.Errorf("throw at a Pokémon that is gone: %q", out)
+	}
+
+	pokeworld.Lock()
+	pokeworld.addWild(2, 2, &wild)
+	pokeworld.Unlock()
+	client.encounter = &Encounter{wild: &wild, x: 2, y: 2, hp: wild.HP}
+	throwBall(client, "poke-ball")
+	if n := client.player.Bag["poke-ball"]; n != 1 {
+		t.Errorf("%d Poké Balls left after a throw, want 1", n)
+	}
+	if out := conn.take(); !strings.Contains(out, "You threw a Poké Ball!") {
+		t.Errorf("throw at a Pokémon that is there: %q", out)
+	}
+}
+
+// TestFightWorksOnACopy fights a wild Pokémon for a turn and runs away, while another session
+// reads the Pokémon in the world, and checks that the world's Pokémon keeps the HP it lost.
+// With -race it also checks that the battle leaves the world's Pokémon alone until it is over.
+func TestFightWorksOnACopy(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	client, conn := testClient(testPokedex[2])
+	client.account = &Account{Username: "ash", PlayerID: 1}
+	client.ctx = context.Background()
+	client.reader = bufio.NewReader(strings.NewReader("1\nrun\n"))
+
+	wild := testPokedex[0]
+	wild.HP = 200 // Lasts more than a turn
+	pokeworld.Lock()
+	pokeworld.addWild(2, 2, &wild)
+	pokeworld.Unlock()
+	client.encounter = &Encounter{wild: &wild, x: 2, y: 2, hp: wild.HP}
+
+	// Another session looks at the Pokémon the whole time, as it may, under the world lock.
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			pokeworld.Lock()
+			if p := pokeworld.grid[2][2]; p != nil && p.HPLost > p.HP {
+				t.Errorf("the world's %s lost %d of its %d HP", p.Name, p.HPLost, p.HP)
+			}
+			pokeworld.Unlock()
+		}
+	}()
+	cmdFight(client, nil, nil)
+	close(stop)
+	<-done
+
+	out := conn.take()
+	if !strings.Contains(out, "Got away safely!") {
+		t.Fatalf("fight: %q", out)
+	}
+	pokeworld.Lock()
+	present, lost := pokeworld.grid[2][2] == &wild, wild.HPLost
+	pokeworld.Unlock()
+	if !present {
+		t.Fatal("the wild Pokémon left the world")
+	}
+	if lost == 0 {
+		t.Fatal("the wild Pokémon didn't keep the HP it lost")
+	}
+
+	// The next encounter, and the catch chance with it, starts from the HP it has left.
+	startEncounter(client, &wild, 2, 2)
+	if got, want := client.encounter.hp, wild.HP-lost; got != want {
+		t.Errorf("next encounter starts at %d HP, want the %d it has left", got, want)
+	}
+}
+
+// readerFunc reads by calling a function, e.g. to change the world while a client chooses.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestFightCatchKeepsBallWhenWildIsGone(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	client, conn := testClient(testPokedex[2])
+	client.account = &Account{Username: "ash", PlayerID: 1}
+	client.ctx = context.Background()
+	client.player.Bag = Bag{"poke-ball": 1}
+
+	wild := testPokedex[0]
+	pokeworld.Lock()
+	pokeworld.addWild(2, 2, &wild)
+	pokeworld.Unlock()
+	client.encounter = &Encounter{wild: &wild, x: 2, y: 2, hp: wild.HP}
+
+	// Someone else catches it while the player picks a ball.
+	input := "catch poke-ball\n"
+	client.reader = bufio.NewReader(readerFunc(func(p []byte) (int, error) {
+		pokeworld.Lock()
+		pokeworld.removeWild(2, 2, &wild, leftCaught)
+		pokeworld.Unlock()
+		n := copy(p, input)
+		input = input[n:]
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}))
+	cmdFight(client, nil, nil)
+
+	out := conn.take()
+	if !strings.Contains(out, "The wild rattata is gone!") || strings.Contains(out, "ran away") {
+		t.Errorf("catch in a fight after the Pokémon left: %q", out)
+	}
+	if n := client.player.Bag["poke-ball"]; n != 1 {
+		t.Errorf("%d Poké Balls left after throwing at a Pokémon that is gone, want 1", n)
+	}
+	if len(client.player.Pokemons) != 1 {
+		t.Errorf("the player has %d Pokémon, want only the lead", len(client.player.Pokemons))
+	}
+	if client.encounter != nil {
+		t.Error("the encounter goes on after the Pokémon is gone")
+	}
+}
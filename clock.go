@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs timers. The world uses it instead of the time package, so that
+// tests can replace it with a fakeClock.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f once d has passed, unless the returned timer is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started with Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It reports false if it already fired or was stopped.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// fakeClock is a Clock whose time only moves when Advance is called, so timers can be tested
+// without waiting for them.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer // Pending timers
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	due   time.Time
+	f     func()
+}
+
+// newFakeClock returns a fakeClock set to now.
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, due: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d. Timers that fall due run one by one in the order of their
+// due time, with the clock set to it, in the goroutine that called Advance; timers they start
+// run too if they fall due within d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].due.Before(c.timers[j].due) })
+		if len(c.timers) == 0 || c.timers[0].due.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.due
+		c.mutex.Unlock()
+		t.f()
+		c.mutex.Lock()
+	}
+	c.now = end
+	c.mutex.Unlock()
+}
+
+// Pending returns the number of timers that have neither fired nor been stopped.
+func (c *fakeClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RNG is a source of random numbers. The world and battles take one instead of using the global
+// generator of math/rand, so that a seed makes them repeatable. *rand.Rand implements it.
+type RNG interface {
+	Intn(n int) int
+	Int63() int64
+	Float64() float64
+}
+
+// newRNG returns a generator seeded with seed.
+func newRNG(seed int64) RNG {
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordConn is a connection that keeps what the server writes to the client.
+type recordConn struct {
+	net.Conn
+	mutex sync.Mutex
+	out   bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.out.Write(p)
+}
+
+func (c *recordConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// take returns what was written since the last call.
+func (c *recordConn) take() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := c.out.String()
+	c.out.Reset()
+	return s
+}
+
+// useTestWorld replaces the world and the store with a test world running on clock and a
+// store in a temporary directory, until the test ends.
+func useTestWorld(t *testing.T, clock Clock) {
+	oldWorld, oldStore := pokeworld, store
+	pokeworld = newTestWorld(1, clock)
+	store = newFileStore(t.TempDir())
+	t.Cleanup(func() { pokeworld, store = oldWorld, oldStore })
+}
+
+// testClient returns a client logged in as a new player who owns pokemon under key 1.
+func testClient(pokemon Pokemon) (*Client, *recordConn) {
+	conn := &recordConn{}
+	player := &Player{ID: 1, X: 1, Y: 1, Pokemons: map[int]Pokemon{1: pokemon}, Team: []int{1}}
+	return &Client{conn: conn, player: player}, conn
+}
+
+func TestDespawnTime(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	pw := newTestWorld(1, clock)
+	p := testPokedex[0]
+	pw.Lock()
+	pw.addWild(3, 3, &p)
+	pw.Unlock()
+
+	despawn := pw.PokemonDespawnTime
+	clock.Advance(despawn - time.Second)
+	if pw.grid[3][3] == nil || pw.TotalPokemon != 1 {
+		t.Fatalf("wild Pokémon gone %s before its despawn time", time.Second)
+	}
+	clock.Advance(time.Second)
+	if pw.grid[3][3] != nil || pw.TotalPokemon != 0 {
+		t.Fatalf("wild Pokémon still there at its despawn time")
+	}
+	if n := pw.metrics.removed[leftDespawned]; n != 1 {
+		t.Errorf("%d Pokémon counted as despawned, want 1", n)
+	}
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers pending after the despawn", clock.Pending())
+	}
+}
+
+func TestHealCooldown(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	useTestWorld(t, clock)
+	cooldown := time.Duration(currentConfig().World.HealCooldown)
+
+	hurt := testPokedex[0]
+	hurt.HPLost = 10
+	client, conn := testClient(hurt)
+	player := client.player
+	heal := func() string {
+		cmdHeal(client, nil, nil)
+		return conn.take()
+	}
+	healthy := func() bool {
+		p := player.Pokemons[1]
+		return p.healthy()
+	}
+	hurtAgain := func() {
+		p := player.Pokemons[1]
+		p.HPLost, p.Status = 10, statusPoison
+		player.Pokemons[1] = p
+	}
+
+	if out := heal(); !strings.Contains(out, "restored") || !healthy() {
+		t.Fatalf("first heal: %q", out)
+	}
+	if !player.LastHeal.Equal(clock.Now()) {
+		t.Errorf("LastHeal is %s, want %s", player.LastHeal, clock.Now())
+	}
+
+	hurtAgain()
+	if out := heal(); !strings.Contains(out, "You can heal again in "+cooldown.String()) {
+		t.Errorf("heal right after healing: %q", out)
+	}
+	clock.Advance(cooldown - time.Second)
+	if out := heal(); !strings.Contains(out, "You can heal again in 1s") || healthy() {
+		t.Errorf("heal a second before the cooldown ends: %q", out)
+	}
+	clock.Advance(time.Second)
+	if out := heal(); !strings.Contains(out, "restored") || !healthy() {
+		t.Errorf("heal once the cooldown ended: %q", out)
+	}
+
+	// A Pokémon Center heals during the cooldown and doesn't restart it.
+	hurtAgain()
+	lastHeal := player.LastHeal
+	clock.Advance(time.Minute)
+	player.X, player.Y = pokemonCenterSpacing/2, pokemonCenterSpacing/2
+	if out := heal(); !strings.Contains(out, "restored") {
+		t.Errorf("heal at a Pokémon Center: %q", out)
+	}
+	if !player.LastHeal.Equal(lastHeal) {
+		t.Errorf("healing at a Pokémon Center moved LastHeal to %s", player.LastHeal)
+	}
+}
+
+// useEvolutionLine adds charmander, which evolves into charmeleon at level 16, to the species
+// until the test ends.
+func useEvolutionLine(t *testing.T) Pokemon {
+	charmander := Pokemon{Name: "charmander", Type: []string{"fire"}, HP: 39, Attack: 52, Defense: 43,
+		SpecialAttack: 60, SpecialDefense: 50, Speed: 65, BaseExp: 62, EvolvesTo: "charmeleon", EvolutionLevel: 16}
+	charmeleon := Pokemon{Name: "charmeleon", Type: []string{"fire"}, HP: 58, Attack: 64, Defense: 58,
+		SpecialAttack: 80, SpecialDefense: 65, Speed: 80, BaseExp: 142}
+	indexPokedex([]Pokemon{charmander, charmeleon})
+	t.Cleanup(func() {
+		delete(species, "charmander")
+		delete(species, "charmeleon")
+	})
+	charmander.Level = 16
+	return charmander
+}
+
+func TestEvolutionDelay(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	useTestWorld(t, clock)
+	client, conn := testClient(useEvolutionLine(t))
+	player := client.player
+
+	player.mutex.Lock()
+	scheduleEvolution(client, 1)
+	player.mutex.Unlock()
+	if out := conn.take(); !strings.Contains(out, "is evolving into charmeleon") {
+		t.Errorf("evolution announced as %q", out)
+	}
+
+	clock.Advance(evolutionDelay - time.Nanosecond)
+	if name := player.Pokemons[1].Name; name != "charmander" {
+		t.Fatalf("evolved into %s before the delay passed", name)
+	}
+	clock.Advance(time.Nanosecond)
+	if name := player.Pokemons[1].Name; name != "charmeleon" {
+		t.Fatalf("still %s once the delay passed", name)
+	}
+	if len(player.evolutions) != 0 {
+		t.Errorf("%d evolutions still pending", len(player.evolutions))
+	}
+	if out := conn.take(); !strings.Contains(out, "evolved into charmeleon") {
+		t.Errorf("evolution reported as %q", out)
+	}
+	data, err := store.LoadPlayer(player.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Team) != 1 || data.Team[0].Name != "charmeleon" {
+		t.Errorf("saved team %+v, want the evolved charmeleon", data.Team)
+	}
+}
+
+func TestEvolutionCancelled(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	useTestWorld(t, clock)
+	client, conn := testClient(useEvolutionLine(t))
+	player := client.player
+
+	player.mutex.Lock()
+	scheduleEvolution(client, 1)
+	player.mutex.Unlock()
+	clock.Advance(evolutionDelay / 2)
+	cmdCancel(client, []string{"1"}, nil)
+	conn.take()
+
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers pending after the evolution was cancelled", clock.Pending())
+	}
+	clock.Advance(evolutionDelay)
+	if name := player.Pokemons[1].Name; name != "charmander" {
+		t.Errorf("cancelled evolution still turned charmander into %s", name)
+	}
+}
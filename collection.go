@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// listPageSize is the number of Pokémon shown per page by "list".
+const listPageSize = 10
+
+// maxNicknameLength is the longest nickname a Pokémon can be given, in characters.
+const maxNicknameLength = 12
+
+// listUsage describes the options of "list".
+const listUsage = "Usage: list [species <name>] [type <type>] [level <min>[-<max>]] [<stat> <min>] [favorites] [sort <field>] [page <n>]"
+
+// statKeys are the names of the stats in "list" options, indexed like an EV spread.
+var statKeys = [numStats]string{"hp", "attack", "defense", "spatk", "spdef", "speed"}
+
+// collectionQuery selects, orders and pages the Pokémon shown by "list".
+type collectionQuery struct {
+	species   string         // Part of the species or nickname
+	typ       string         // One of the Pokémon's types
+	minLevel  int            // Lowest level, 0 for any
+	maxLevel  int            // Highest level, 0 for any
+	minStats  map[string]int // Lowest value of a stat, by stat key or "total"
+	favorites bool           // Only favorites
+	sortBy    string         // "number", "species", "type", "level", a stat key or "total"
+	page      int
+}
+
+// parseCollectionQuery parses the options of "list".
+func parseCollectionQuery(args []string) (collectionQuery, error) {
+	q := collectionQuery{minStats: make(map[string]int), sortBy: "number", page: 1}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		if option == "favorites" {
+			q.favorites = true
+			continue
+		}
+		if i+1 == len(args) {
+			return q, fmt.Errorf("%q needs a value", option)
+		}
+		i++
+		value := strings.ToLower(args[i])
+
+		switch {
+		case option == "species":
+			q.species = value
+		case option == "type":
+			q.typ = value
+		case option == "level":
+			minText, maxText, isRange := strings.Cut(value, "-")
+			low, err := strconv.Atoi(minText)
+			high := low
+			if err == nil && isRange {
+				high, err = strconv.Atoi(maxText)
+			}
+			if err != nil || low < 1 || high < low {
+				return q, fmt.Errorf("invalid level %q", value)
+			}
+			q.minLevel, q.maxLevel = low, high
+		case option == "sort":
+			if !validSortField(value) {
+				return q, fmt.Errorf("can't sort by %q; try number, species, type, level, %s or total", value, strings.Join(statKeys[:], ", "))
+			}
+			q.sortBy = value
+		case option == "page":
+			page, err := strconv.Atoi(value)
+			if err != nil || page < 1 {
+				return q, fmt.Errorf("invalid page %q", value)
+			}
+			q.page = page
+		case isStatKey(option):
+			low, err := strconv.Atoi(value)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", option, value)
+			}
+			q.minStats[option] = low
+		default:
+			return q, fmt.Errorf("unknown option %q", option)
+		}
+	}
+	return q, nil
+}
+
+func isStatKey(key string) bool {
+	if key == "total" {
+		return true
+	}
+	for _, stat := range statKeys {
+		if key == stat {
+			return true
+		}
+	}
+	return false
+}
+
+func validSortField(field string) bool {
+	switch field {
+	case "number", "species", "type", "level":
+		return true
+	}
+	return isStatKey(field)
+}
+
+// statByKey returns a stat of a Pokémon by its key in statKeys, or the sum of all stats for "total".
+func statByKey(p *Pokemon, key string) int {
+	values := statValues(p)
+	total := 0
+	for stat, value := range values {
+		if statKeys[stat] == key {
+			return value
+		}
+		total += value
+	}
+	return total
+}
+
+// matches reports whether a Pokémon passes the filters of the query. Its stats must be up to date.
+func (q collectionQuery) matches(p *Pokemon) bool {
+	if q.favorites && !p.Favorite {
+		return false
+	}
+	if q.species != "" && !strings.Contains(strings.ToLower(p.Name), q.species) &&
+		!strings.Contains(strings.ToLower(p.Nickname), q.species) {
+		return false
+	}
+	if q.typ != "" {
+		found := false
+		for _, t := range p.Type {
+			found = found || strings.EqualFold(t, q.typ)
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.minLevel > 0 && (p.Level < q.minLevel || p.Level > q.maxLevel) {
+		return false
+	}
+	for key, low := range q.minStats {
+		if statByKey(p, key) < low {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders two Pokémon of the collection by the query's sort field: names and types
+// alphabetically, levels and stats highest first, and by number otherwise.
+func (q collectionQuery) less(a, b *Pokemon, keyA, keyB int) bool {
+	switch q.sortBy {
+	case "species":
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case "type":
+		if typeA, typeB := strings.Join(a.Type, "/"), strings.Join(b.Type, "/"); typeA != typeB {
+			return typeA < typeB
+		}
+	case "level":
+		if a.Level != b.Level {
+			return a.Level > b.Level
+		}
+	case "number":
+	default:
+		if statA, statB := statByKey(a, q.sortBy), statByKey(b, q.sortBy); statA != statB {
+			return statA > statB
+		}
+	}
+	return keyA < keyB
+}
+
+// cmdList pages through the player's collection, filtered and sorted as asked.
+func cmdList(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) == 1 && strings.ToLower(args[0]) == "help" {
+		fmt.Fprintln(conn, listUsage)
+		fmt.Fprintf(conn, "Stats are %s and total. Sort by number, species, type, level or a stat.\n", strings.Join(statKeys[:], ", "))
+		fmt.Fprintln(conn, "For example: list type water level 10-20 speed 50 sort attack")
+		return
+	}
+	q, err := parseCollectionQuery(args)
+	if err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		fmt.Fprintln(conn, listUsage)
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if len(player.Pokemons) == 0 {
+		fmt.Fprintln(conn, "You have no Pokémon yet. Walk around the world to find some!")
+		return
+	}
+
+	// Filters and sorting use the stats the Pokémon battle with.
+	var keys []int
+	pokemons := make(map[int]*Pokemon)
+	for key, pokemon := range player.Pokemons {
+		recomputeStats(&pokemon)
+		if q.matches(&pokemon) {
+			keys = append(keys, key)
+			pokemons[key] = &pokemon
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return q.less(pokemons[keys[i]], pokemons[keys[j]], keys[i], keys[j])
+	})
+
+	if len(keys) == 0 {
+		fmt.Fprintln(conn, "None of your Pokémon match.")
+		return
+	}
+	pages := (len(keys) + listPageSize - 1) / listPageSize
+	if q.page > pages {
+		fmt.Fprintf(conn, "There is no page %d; the last one is %d.\n", q.page, pages)
+		return
+	}
+
+	fmt.Fprintf(conn, "%d of your %d Pokémon (max %d), page %d of %d, by %s:\n",
+		len(keys), len(player.Pokemons), currentConfig().World.MaxPokemonPerPlayer, q.page, pages, q.sortBy)
+	start := (q.page - 1) * listPageSize
+	for _, key := range keys[start:min(start+listPageSize, len(keys))] {
+		p := pokemons[key]
+		mark := " "
+		if p.Favorite {
+			mark = "*"
+		}
+		line := fmt.Sprintf("%s%4d. %s", mark, key, describePokemon(*p))
+		if isStatKey(q.sortBy) {
+			line += fmt.Sprintf("  %s %d", q.sortBy, statByKey(p, q.sortBy))
+		}
+		fmt.Fprintln(conn, line)
+	}
+	if q.page < pages {
+		fmt.Fprintf(conn, "Type the same command with 'page %d' for more.\n", q.page+1)
+	}
+}
+
+// cmdNickname gives a Pokémon of the player's collection a nickname, or removes it.
+func cmdNickname(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) < 1 {
+		fmt.Fprintln(conn, "Usage: nickname <number> [name]")
+		return
+	}
+	nickname := strings.Join(args[1:], " ")
+	if err := validateNickname(nickname); err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	key, err := strconv.Atoi(args[0])
+	pokemon, ok := player.Pokemons[key]
+	if err != nil || !ok {
+		fmt.Fprintf(conn, "You don't have a Pokémon numbered %q.\n", args[0])
+		return
+	}
+	pokemon.Nickname = nickname
+	player.Pokemons[key] = pokemon
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	if nickname == "" {
+		fmt.Fprintf(conn, "Your %s no longer has a nickname.\n", pokemon.Name)
+	} else {
+		fmt.Fprintf(conn, "Your %s is now called %s.\n", pokemon.Name, nickname)
+	}
+}
+
+// validateNickname checks that a nickname is short and made of letters, digits, spaces and
+// a little punctuation. The empty nickname removes it.
+func validateNickname(nickname string) error {
+	if utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return fmt.Errorf("a nickname has at most %d characters", maxNicknameLength)
+	}
+	for _, r := range nickname {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" '-.", r) {
+			return fmt.Errorf("a nickname can't contain %q", r)
+		}
+	}
+	return nil
+}
+
+// cmdFavorite marks a Pokémon of the player's collection as a favorite, or unmarks it.
+// Favorites can't be released.
+func cmdFavorite(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: favorite <number>")
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	key, err := strconv.Atoi(args[0])
+	pokemon, ok := player.Pokemons[key]
+	if err != nil || !ok {
+		fmt.Fprintf(conn, "You don't have a Pokémon numbered %q.\n", args[0])
+		return
+	}
+	pokemon.Favorite = !pokemon.Favorite
+	player.Pokemons[key] = pokemon
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	if pokemon.Favorite {
+		fmt.Fprintf(conn, "%s is now one of your favorites.\n", pokemonName(pokemon))
+	} else {
+		fmt.Fprintf(conn, "%s is no longer one of your favorites.\n", pokemonName(pokemon))
+	}
+}
+
+// cmdRelease lets Pokémon of the player's collection go, which makes room for new ones. They
+// return to the world next to the player, where anyone can catch them again; items they hold
+// go back to the bag.
+func cmdRelease(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) == 0 {
+		fmt.Fprintln(conn, "Usage: release <number>...")
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	var keys []int
+	seen := make(map[int]bool)
+	for _, arg := range args {
+		key, err := strconv.Atoi(arg)
+		pokemon, ok := player.Pokemons[key]
+		if err != nil || !ok {
+			player.mutex.Unlock()
+			fmt.Fprintf(conn, "You don't have a Pokémon numbered %q.\n", arg)
+			return
+		}
+		if pokemon.Favorite {
+			player.mutex.Unlock()
+			fmt.Fprintf(conn, "%s is one of your favorites. Type 'favorite %d' first to release it.\n", pokemonName(pokemon), key)
+			return
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	var released []Pokemon
+	for _, key := range keys {
+		pokemon := player.Pokemons[key]
+		delete(player.Pokemons, key)
+		if timer, pending := player.evolutions[key]; pending {
+			timer.Stop()
+			delete(player.evolutions, key)
+		}
+		if pokemon.HeldItem != "" {
+			player.Bag.add(pokemon.HeldItem, 1)
+			fmt.Fprintf(conn, "You took the %s from %s.\n", itemName(pokemon.HeldItem), pokemonName(pokemon))
+		}
+		released = append(released, pokemon)
+	}
+	player.Team = player.validTeam()
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	x, y := player.X, player.Y
+	player.mutex.Unlock()
+
+	for _, pokemon := range released {
+		fmt.Fprintf(conn, "You released %s. Bye-bye!\n", pokemonName(pokemon))
+		pokeworld.releaseWild(x, y, pokemon)
+	}
+}
+
+// pokemonName returns the nickname of a Pokémon, or its species if it has none.
+func pokemonName(p Pokemon) string {
+	if p.Nickname != "" {
+		return p.Nickname
+	}
+	return p.Name
+}
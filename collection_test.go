@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseFavorite(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	client, conn := testClient(testPokedex[0])
+	player := client.player
+	favorite := testPokedex[1]
+	favorite.Nickname, favorite.Favorite = "Goldie", true
+	player.Pokemons[2] = favorite
+
+	for _, args := range [][]string{{"2"}, {"1", "2"}} {
+		cmdRelease(client, args, nil)
+		if out := conn.take(); !strings.Contains(out, "Goldie is one of your favorites. Type 'favorite 2' first") {
+			t.Errorf("release %v: %q", args, out)
+		}
+		if len(player.Pokemons) != 2 {
+			t.Errorf("release %v: %d Pokémon left, want both", args, len(player.Pokemons))
+		}
+	}
+	if pokeworld.TotalPokemon != 0 {
+		t.Errorf("%d Pokémon released into the world", pokeworld.TotalPokemon)
+	}
+
+	cmdFavorite(client, []string{"2"}, nil)
+	if out := conn.take(); !strings.Contains(out, "Goldie is no longer one of your favorites.") {
+		t.Errorf("unmarking the favorite: %q", out)
+	}
+	cmdRelease(client, []string{"2"}, nil)
+	if out := conn.take(); !strings.Contains(out, "You released Goldie. Bye-bye!") {
+		t.Errorf("releasing after unmarking: %q", out)
+	}
+	if _, ok := player.Pokemons[2]; ok {
+		t.Error("the former favorite is still in the collection")
+	}
+	data, err := store.LoadPlayer(player.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(data.Team) + len(data.CapturedPokemon); n != 1 {
+		t.Errorf("%d Pokémon saved, want 1", n)
+	}
+
+	// Back in the wild, it is nobody's favorite.
+	pokeworld.Lock()
+	defer pokeworld.Unlock()
+	if pokeworld.TotalPokemon != 1 {
+		t.Fatalf("%d Pokémon in the world after the release, want 1", pokeworld.TotalPokemon)
+	}
+	for x := range pokeworld.grid {
+		for _, p := range pokeworld.grid[x] {
+			if p != nil && (p.Favorite || p.Nickname != "") {
+				t.Errorf("released %s is still a favorite named %q", p.Name, p.Nickname)
+			}
+		}
+	}
+}
+
+func TestFavoriteIsSaved(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	client, conn := testClient(testPokedex[0])
+	cmdFavorite(client, []string{"1"}, nil)
+	if out := conn.take(); !strings.Contains(out, "rattata is now one of your favorites.") {
+		t.Errorf("marking a favorite: %q", out)
+	}
+	data, err := store.LoadPlayer(client.player.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Team) != 1 || !data.Team[0].Favorite {
+		t.Errorf("saved team %+v, want the rattata as a favorite", data.Team)
+	}
+	cmdFavorite(client, []string{"7"}, nil)
+	if out := conn.take(); !strings.Contains(out, `You don't have a Pokémon numbered "7".`) {
+		t.Errorf("marking a Pokémon the player doesn't have: %q", out)
+	}
+}
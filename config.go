@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config holds the settings of the server. They come from, in increasing order of precedence,
+// the built-in defaults, the JSON file given with -config, POKECAT_* environment variables and
+// command line flags (see settings).
+type Config struct {
+	Listen  string       `json:"listen"`  // Address the server accepts connections on
+	Store   string       `json:"store"`   // Storage backend, see openStore
+	Metrics string       `json:"metrics"` // Address Prometheus metrics are served on, if any
+	World   WorldConfig  `json:"world"`
+	Battle  BattleConfig `json:"battle"`
+}
+
+// WorldConfig holds the settings of the game world.
+type WorldConfig struct {
+	Width               int      `json:"width"`
+	Height              int      `json:"height"`
+	Seed                int64    `json:"seed"`         // Seed of the biome map and of spawns; 0 picks one at random
+	Map                 string   `json:"map"`          // Biome map file instead of a generated map
+	SpawnTables         string   `json:"spawn_tables"` // Spawn tables file instead of the built-in tables
+	SpawnRate           Duration `json:"spawn_rate"`   // Time between two waves of spawns
+	DespawnTime         Duration `json:"despawn_time"` // Time a wild Pokémon stays in the world
+	PokemonPerSpawn     int      `json:"pokemon_per_spawn"`
+	MaxWildPokemon      int      `json:"max_wild_pokemon"` // Wild Pokémon in the world above which none spawn
+	MaxPokemonPerPlayer int      `json:"max_pokemon_per_player"`
+	HealCooldown        Duration `json:"heal_cooldown"`    // Time between two uses of the heal command
+	InterestRadius      int      `json:"interest_radius"`  // Distance in tiles within which players hear about events
+	EventQueueSize      int      `json:"event_queue_size"` // Notifications kept for a client that reads them slowly
+}
+
+// BattleConfig holds the rules of battles between trainers, see BattleRules.
+type BattleConfig struct {
+	Items           bool     `json:"items"`
+	MaxTurns        int      `json:"max_turns"`
+	NoProgressTurns int      `json:"no_progress_turns"`
+	Tiebreak        bool     `json:"tiebreak"`
+	TurnTimeout     Duration `json:"turn_timeout"`
+	TimeoutForfeits bool     `json:"timeout_forfeits"`
+}
+
+// rules returns the battle rules the settings stand for.
+func (c BattleConfig) rules() BattleRules {
+	return BattleRules{
+		MaxTurns:        c.MaxTurns,
+		NoProgressTurns: c.NoProgressTurns,
+		Tiebreak:        c.Tiebreak,
+		TurnTimeout:     time.Duration(c.TurnTimeout),
+		TimeoutForfeits: c.TimeoutForfeits,
+		Items:           c.Items,
+	}
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "5m" in the config file.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"90s\" or \"5m\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// defaultConfig returns the settings used when nothing else is configured.
+func defaultConfig() Config {
+	return Config{
+		Listen: ":8080",
+		Store:  defaultStoreSpec,
+		World: WorldConfig{
+			Width:               1000,
+			Height:              1000,
+			SpawnRate:           Duration(time.Minute),
+			DespawnTime:         Duration(5 * time.Minute),
+			PokemonPerSpawn:     50,
+			MaxWildPokemon:      50,
+			MaxPokemonPerPlayer: 200,
+			HealCooldown:        Duration(10 * time.Minute),
+			InterestRadius:      10,
+			EventQueueSize:      32,
+		},
+		Battle: BattleConfig{
+			Items:           defaultBattleRules.Items,
+			MaxTurns:        defaultBattleRules.MaxTurns,
+			NoProgressTurns: defaultBattleRules.NoProgressTurns,
+			Tiebreak:        defaultBattleRules.Tiebreak,
+			TurnTimeout:     Duration(defaultBattleRules.TurnTimeout),
+			TimeoutForfeits: defaultBattleRules.TimeoutForfeits,
+		},
+	}
+}
+
+// setting is a value of Config that can be set with a flag and an environment variable.
+type setting struct {
+	name   string              // Name of the flag; the environment variable is envName(name)
+	usage  string              // Help text of the flag
+	reload bool                // Changes take effect on SIGHUP; other settings need a restart
+	field  func(c *Config) any // Pointer to the value in c
+}
+
+// settings are all the values of Config that can be set outside the config file.
+var settings = []setting{
+	{"listen", "address to accept connections on", false, func(c *Config) any { return &c.Listen }},
+	{"store", "storage backend: file:<directory> or db:<file>", false, func(c *Config) any { return &c.Store }},
+	{"metrics", "address to serve Prometheus metrics on at /metrics, e.g. :9100 (none if empty)", false,
+		func(c *Config) any { return &c.Metrics }},
+	{"world-width", "width of the world in tiles", false, func(c *Config) any { return &c.World.Width }},
+	{"world-height", "height of the world in tiles", false, func(c *Config) any { return &c.World.Height }},
+	{"world-seed", "seed of the generated biome map and of spawns (0 picks one at random)", false,
+		func(c *Config) any { return &c.World.Seed }},
+	{"world-map", "text file with the biome map, stretched over the world, instead of a generated one", false,
+		func(c *Config) any { return &c.World.Map }},
+	{"spawn-tables", "JSON file with the spawn table of each biome instead of the built-in ones", false,
+		func(c *Config) any { return &c.World.SpawnTables }},
+	{"spawn-rate", "time between two waves of wild Pokémon", true, func(c *Config) any { return &c.World.SpawnRate }},
+	{"despawn-time", "time a wild Pokémon stays in the world", true, func(c *Config) any { return &c.World.DespawnTime }},
+	{"pokemon-per-spawn", "wild Pokémon spawned by each wave", true, func(c *Config) any { return &c.World.PokemonPerSpawn }},
+	{"max-wild-pokemon", "wild Pokémon in the world above which none spawn", true,
+		func(c *Config) any { return &c.World.MaxWildPokemon }},
+	{"max-pokemon-per-player", "Pokémon a player can own", true, func(c *Config) any { return &c.World.MaxPokemonPerPlayer }},
+	{"heal-cooldown", "time between two uses of the heal command away from a Pokémon Center", true,
+		func(c *Config) any { return &c.World.HealCooldown }},
+	{"interest-radius", "distance in tiles within which players are told about spawns and other players", true,
+		func(c *Config) any { return &c.World.InterestRadius }},
+	{"event-queue-size", "notifications kept for a client that reads them slowly; more are dropped", true,
+		func(c *Config) any { return &c.World.EventQueueSize }},
+	{"battle-items", "allow items in battles between trainers", true, func(c *Config) any { return &c.Battle.Items }},
+	{"battle-max-turns", "turns after which a battle between trainers is stopped (0 means no limit)", true,
+		func(c *Config) any { return &c.Battle.MaxTurns }},
+	{"battle-no-progress", "turns in a row without any HP change after which a battle is stopped (0 disables it)", true,
+		func(c *Config) any { return &c.Battle.NoProgressTurns }},
+	{"battle-tiebreak", "a stopped battle is won by the side with the highest share of HP left", true,
+		func(c *Config) any { return &c.Battle.Tiebreak }},
+	{"battle-turn-timeout", "time a player has to choose an action (0 means no limit)", true,
+		func(c *Config) any { return &c.Battle.TurnTimeout }},
+	{"battle-timeout-forfeits", "running out of time forfeits instead of using the best damaging move", true,
+		func(c *Config) any { return &c.Battle.TimeoutForfeits }},
+}
+
+// envName returns the environment variable of a setting, e.g. POKECAT_SPAWN_RATE for spawn-rate.
+func envName(name string) string {
+	return "POKECAT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// setValue parses s into the value field points to.
+func setValue(field any, s string) error {
+	var err error
+	switch v := field.(type) {
+	case *string:
+		*v = s
+	case *int:
+		*v, err = strconv.Atoi(s)
+	case *int64:
+		*v, err = strconv.ParseInt(s, 10, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(s)
+	case *Duration:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		*v = Duration(d)
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", field))
+	}
+	return err
+}
+
+// settingFlag records the value a setting is given on the command line. Flags are parsed
+// before the config file is read, so they are applied on top of it afterwards.
+type settingFlag struct {
+	setting *setting
+	values  map[string]string
+}
+
+func (f settingFlag) String() string {
+	if f.setting == nil {
+		return ""
+	}
+	defaults := defaultConfig()
+	value := fmt.Sprint(reflect.ValueOf(f.setting.field(&defaults)).Elem().Interface())
+	if value == "false" || value == "0" {
+		return "" // Not worth showing in the help
+	}
+	return value
+}
+
+func (f settingFlag) Set(s string) error {
+	if err := setValue(f.setting.field(&Config{}), s); err != nil {
+		return err
+	}
+	f.values[f.setting.name] = s
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool {
+	_, ok := f.setting.field(&Config{}).(*bool)
+	return ok
+}
+
+// configSource is where the settings come from, kept to read them again on SIGHUP.
+type configSource struct {
+	file  string            // Config file, if any
+	flags map[string]string // Settings given on the command line
+}
+
+// addConfigFlags defines -config and a flag for every setting.
+func addConfigFlags() *configSource {
+	source := &configSource{flags: make(map[string]string)}
+	flag.StringVar(&source.file, "config", "", "JSON config file; see README for its fields")
+	for i := range settings {
+		s := &settings[i]
+		flag.Var(settingFlag{setting: s, values: source.flags}, s.name,
+			fmt.Sprintf("%s (env %s)", s.usage, envName(s.name)))
+	}
+	return source
+}
+
+// load reads the settings: defaults, then the config file, environment variables and flags.
+func (source *configSource) load() (Config, error) {
+	c := defaultConfig()
+	if source.file != "" {
+		raw, err := os.ReadFile(source.file)
+		if err != nil {
+			return c, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c); err != nil {
+			return c, fmt.Errorf("%s: %v", source.file, err)
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := setValue(s.field(&c), value); err != nil {
+				return c, fmt.Errorf("%s: %v", envName(s.name), err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := source.flags[s.name]; ok {
+			setValue(s.field(&c), value) // Already checked when the flag was parsed
+		}
+	}
+	return c, c.validate()
+}
+
+// validate reports the first setting that is out of range.
+func (c Config) validate() error {
+	w, b := c.World, c.Battle
+	switch {
+	case c.Listen == "":
+		return fmt.Errorf("listen: an address is required")
+	case c.Store == "":
+		return fmt.Errorf("store: a backend is required")
+	case w.Width < 1 || w.Height < 1:
+		return fmt.Errorf("world: the size must be at least 1x1, got %dx%d", w.Width, w.Height)
+	case w.SpawnRate <= 0:
+		return fmt.Errorf("spawn_rate: must be positive, got %s", w.SpawnRate)
+	case w.DespawnTime <= 0:
+		return fmt.Errorf("despawn_time: must be positive, got %s", w.DespawnTime)
+	case w.PokemonPerSpawn < 0:
+		return fmt.Errorf("pokemon_per_spawn: can't be negative, got %d", w.PokemonPerSpawn)
+	case w.MaxWildPokemon < 0:
+		return fmt.Errorf("max_wild_pokemon: can't be negative, got %d", w.MaxWildPokemon)
+	case w.MaxPokemonPerPlayer < 1:
+		return fmt.Errorf("max_pokemon_per_player: must be at least 1, got %d", w.MaxPokemonPerPlayer)
+	case w.HealCooldown < 0:
+		return fmt.Errorf("heal_cooldown: can't be negative, got %s", w.HealCooldown)
+	case w.InterestRadius < 0:
+		return fmt.Errorf("interest_radius: can't be negative, got %d", w.InterestRadius)
+	case w.EventQueueSize < 1:
+		return fmt.Errorf("event_queue_size: must be at least 1, got %d", w.EventQueueSize)
+	case b.MaxTurns < 0:
+		return fmt.Errorf("battle max_turns: can't be negative, got %d", b.MaxTurns)
+	case b.NoProgressTurns < 0:
+		return fmt.Errorf("battle no_progress_turns: can't be negative, got %d", b.NoProgressTurns)
+	case b.TurnTimeout < 0:
+		return fmt.Errorf("battle turn_timeout: can't be negative, got %s", b.TurnTimeout)
+	}
+	return nil
+}
+
+var (
+	configMutex sync.Mutex
+	config      = defaultConfig() // Settings in effect; read through currentConfig
+)
+
+// currentConfig returns the settings in effect. They may change on SIGHUP.
+func currentConfig() Config {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return config
+}
+
+func setConfig(c Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	config = c
+}
+
+// reloadConfig reads the settings again and applies those that can change while the server
+// runs. Invalid settings are rejected as a whole, and changes to the others are ignored.
+func reloadConfig(source *configSource) {
+	next, err := source.load()
+	if err != nil {
+		log.Printf("Error reloading config, keeping the current one: %v", err)
+		return
+	}
+	current := currentConfig()
+	for _, s := range settings {
+		old, changed := reflect.ValueOf(s.field(&current)).Elem(), reflect.ValueOf(s.field(&next)).Elem()
+		if s.reload || old.Interface() == changed.Interface() {
+			continue
+		}
+		log.Printf("Config: %s can't change while the server runs, restart to apply it", s.name)
+		changed.Set(old)
+	}
+	setConfig(next)
+	if pokeworld != nil {
+		pokeworld.configure(next.World)
+	}
+	log.Printf("Config reloaded")
+}
+
+// watchConfig reloads the settings whenever the server receives SIGHUP, until ctx is cancelled.
+func watchConfig(ctx context.Context, source *configSource) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reloadConfig(source)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string // Start of the error, or empty if the config is valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"no listen address", func(c *Config) { c.Listen = "" }, "listen:"},
+		{"no store", func(c *Config) { c.Store = "" }, "store:"},
+		{"no width", func(c *Config) { c.World.Width = 0 }, "world:"},
+		{"no height", func(c *Config) { c.World.Height = 0 }, "world:"},
+		{"1x1 world", func(c *Config) { c.World.Width, c.World.Height = 1, 1 }, ""},
+		{"no spawn rate", func(c *Config) { c.World.SpawnRate = 0 }, "spawn_rate:"},
+		{"no despawn time", func(c *Config) { c.World.DespawnTime = 0 }, "despawn_time:"},
+		{"negative spawns", func(c *Config) { c.World.PokemonPerSpawn = -1 }, "pokemon_per_spawn:"},
+		{"no spawns", func(c *Config) { c.World.PokemonPerSpawn = 0 }, ""},
+		{"negative wild cap", func(c *Config) { c.World.MaxWildPokemon = -1 }, "max_wild_pokemon:"},
+		{"no Pokémon per player", func(c *Config) { c.World.MaxPokemonPerPlayer = 0 }, "max_pokemon_per_player:"},
+		{"negative heal cooldown", func(c *Config) { c.World.HealCooldown = -1 }, "heal_cooldown:"},
+		{"no heal cooldown", func(c *Config) { c.World.HealCooldown = 0 }, ""},
+		{"negative interest radius", func(c *Config) { c.World.InterestRadius = -1 }, "interest_radius:"},
+		{"no event queue", func(c *Config) { c.World.EventQueueSize = 0 }, "event_queue_size:"},
+		{"negative turn limit", func(c *Config) { c.Battle.MaxTurns = -1 }, "battle max_turns:"},
+		{"no turn limit", func(c *Config) { c.Battle.MaxTurns = 0 }, ""},
+		{"negative no-progress turns", func(c *Config) { c.Battle.NoProgressTurns = -1 }, "battle no_progress_turns:"},
+		{"negative turn timeout", func(c *Config) { c.Battle.TurnTimeout = -1 }, "battle turn_timeout:"},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		tt.change(&c)
+		err := c.validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v, want the config to be valid", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want an error starting with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// writeConfigFile writes a config file into a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `{"world": {"spawn_rate": "2m", "width": 20}}`)
+	tests := []struct {
+		name  string
+		file  bool
+		env   string
+		flag  string
+		want  time.Duration
+		width int
+	}{
+		{"defaults", false, "", "", time.Minute, 1000},
+		{"file", true, "", "", 2 * time.Minute, 20},
+		{"env over defaults", false, "3m", "", 3 * time.Minute, 1000},
+		{"env over file", true, "3m", "", 3 * time.Minute, 20},
+		{"flag over defaults", false, "", "4m", 4 * time.Minute, 1000},
+		{"flag over file", true, "", "4m", 4 * time.Minute, 20},
+		{"flag over env and file", true, "3m", "4m", 4 * time.Minute, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &configSource{flags: make(map[string]string)}
+			if tt.file {
+				source.file = file
+			}
+			if tt.env != "" {
+				t.Setenv("POKECAT_SPAWN_RATE", tt.env)
+			}
+			if tt.flag != "" {
+				source.flags["spawn-rate"] = tt.flag
+			}
+			c, err := source.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := time.Duration(c.World.SpawnRate); got != tt.want {
+				t.Errorf("spawn rate %s, want %s", got, tt.want)
+			}
+			if c.World.Width != tt.width {
+				t.Errorf("width %d, want %d", c.World.Width, tt.width)
+			}
+		})
+	}
+}
+
+func TestConfigLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  string // Value of POKECAT_WORLD_WIDTH
+		want string
+	}{
+		{"unknown field", `{"world": {"widht": 20}}`, "", `unknown field "widht"`},
+		{"duration as a number", `{"world": {"spawn_rate": 60}}`, "", "durations are strings"},
+		{"invalid env", `{}`, "wide", "POKECAT_WORLD_WIDTH:"},
+		{"out of range", `{"world": {"width": -1}}`, "", "world:"},
+		{"out of range from env", `{}`, "0", "world:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("POKECAT_WORLD_WIDTH", tt.env)
+			}
+			source := &configSource{file: writeConfigFile(t, tt.file), flags: make(map[string]string)}
+			if _, err := source.load(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loaded with %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestReloadConfig reloads a config file that changes one setting at a time and checks which
+// changes are applied and which wait for a restart.
+func TestReloadConfig(t *testing.T) {
+	tests := []struct {
+		file    string
+		applied bool
+		check   func(c Config) bool // Whether the change is in c
+	}{
+		{`{"world": {"spawn_rate": "2m"}}`, true, func(c Config) bool { return c.World.SpawnRate == Duration(2*time.Minute) }},
+		{`{"world": {"despawn_time": "1m"}}`, true, func(c Config) bool { return c.World.DespawnTime == Duration(time.Minute) }},
+		{`{"world": {"max_wild_pokemon": 7}}`, true, func(c Config) bool { return c.World.MaxWildPokemon == 7 }},
+		{`{"world": {"event_queue_size": 4}}`, true, func(c Config) bool { return c.World.EventQueueSize == 4 }},
+		{`{"battle": {"max_turns": 20}}`, true, func(c Config) bool { return c.Battle.MaxTurns == 20 }},
+		{`{"battle": {"timeout_forfeits": true}}`, true, func(c Config) bool { return c.Battle.TimeoutForfeits }},
+		{`{"listen": ":9090"}`, false, func(c Config) bool { return c.Listen == ":9090" }},
+		{`{"store": "db:other.db"}`, false, func(c Config) bool { return c.Store == "db:other.db" }},
+		{`{"world": {"width": 20}}`, false, func(c Config) bool { return c.World.Width == 20 }},
+		{`{"world": {"seed": 5}}`, false, func(c Config) bool { return c.World.Seed == 5 }},
+		{`{"world": {"spawn_rate": "0s"}}`, false, func(c Config) bool { return c.World.SpawnRate == 0 }},
+	}
+	useTestWorld(t, newFakeClock(time.Now()))
+	old := currentConfig()
+	t.Cleanup(func() { setConfig(old) })
+	for _, tt := range tests {
+		setConfig(defaultConfig())
+		reloadConfig(&configSource{file: writeConfigFile(t, tt.file), flags: make(map[string]string)})
+		c := currentConfig()
+		if got := tt.check(c); got != tt.applied {
+			t.Errorf("reloading %s: applied %v, want %v", tt.file, got, tt.applied)
+		}
+		if c.Listen != ":8080" || c.World.Width != 1000 {
+			t.Errorf("reloading %s changed settings that need a restart: listen %q, width %d", tt.file, c.Listen, c.World.Width)
+		}
+	}
+
+	// The world picks up the settings it uses.
+	setConfig(defaultConfig())
+	reloadConfig(&configSource{file: writeConfigFile(t, `{"world": {"spawn_rate": "2m", "max_wild_pokemon": 7}}`),
+		flags: make(map[string]string)})
+	pokeworld.Lock()
+	rate, max := pokeworld.PokemonSpawnRate, pokeworld.MaxPokemon
+	pokeworld.Unlock()
+	if rate != 2*time.Minute || max != 7 {
+		t.Errorf("world spawns every %s up to %d after the reload, want every 2m0s up to 7", rate, max)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Kinds of world events.
+const (
+	eventSpawn   = "spawn"   // A wild Pokémon appeared
+	eventDespawn = "despawn" // A wild Pokémon left the world without being caught
+	eventCapture = "capture" // A player caught a wild Pokémon
+	eventJoin    = "join"    // A player logged in
+	eventLeave   = "leave"   // A player logged out
+	eventMove    = "move"    // A player walked one tile
+)
+
+// WorldEvent is something that happened at a tile of the world.
+type WorldEvent struct {
+	Kind         string
+	X, Y         int
+	FromX, FromY int     // Previous position of the player for eventMove
+	Pokemon      string  // Species of the wild Pokémon, if any
+	Player       string  // Name of the player, if any
+	player       *Player // The player, who is not notified of their own events
+}
+
+// eventBus passes world events on to the connected clients. Each client only hears about events
+// within its area of interest, a square of interestRadius tiles around it, and has a queue of
+// notifications of its own: when a client reads them slower than they come, new ones are dropped
+// instead of holding up the world.
+type eventBus struct {
+	mutex          sync.Mutex
+	subscribers    map[*subscriber]bool
+	interestRadius int
+	queueSize      int // Size of the queues of new subscribers
+}
+
+// subscriber is a client listening to the bus.
+type subscriber struct {
+	player  *Player
+	x, y    int         // Position of the player, kept up to date with moveTo
+	queue   chan string // Notifications waiting to be sent
+	dropped int         // Notifications dropped since the last one sent
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[*subscriber]bool)}
+}
+
+// configure applies the settings of the bus, which may change while the server runs.
+func (bus *eventBus) configure(settings WorldConfig) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.interestRadius = settings.InterestRadius
+	bus.queueSize = settings.EventQueueSize
+}
+
+// subscribe starts listening for events around (x, y) on behalf of player.
+func (bus *eventBus) subscribe(player *Player, x, y int) *subscriber {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	s := &subscriber{player: player, x: x, y: y, queue: make(chan string, bus.queueSize)}
+	bus.subscribers[s] = true
+	return s
+}
+
+// unsubscribe stops listening and closes the subscriber's queue.
+func (bus *eventBus) unsubscribe(s *subscriber) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if bus.subscribers[s] {
+		delete(bus.subscribers, s)
+		close(s.queue)
+	}
+}
+
+// moveTo moves the subscriber's area of interest along with their player.
+func (bus *eventBus) moveTo(s *subscriber, x, y int) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	s.x, s.y = x, y
+}
+
+// publish queues a notification of the event for every subscriber it concerns. It never blocks,
+// so it can be called with the world locked.
+func (bus *eventBus) publish(e WorldEvent) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	for s := range bus.subscribers {
+		if s.player == e.player || !bus.near(s, e.X, e.Y) {
+			continue
+		}
+		// Players walking around are only worth a notification when they come into view.
+		if e.Kind == eventMove && bus.near(s, e.FromX, e.FromY) {
+			continue
+		}
+		select {
+		case s.queue <- describeEvent(e, e.X-s.x, e.Y-s.y):
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// near reports whether (x, y) is in the subscriber's area of interest.
+// The caller must hold bus.mutex.
+func (bus *eventBus) near(s *subscriber, x, y int) bool {
+	return abs(x-s.x) <= bus.interestRadius && abs(y-s.y) <= bus.interestRadius
+}
+
+// takeDropped returns the number of notifications the subscriber missed and resets it.
+func (bus *eventBus) takeDropped(s *subscriber) int {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// deliverEvents writes the subscriber's notifications to conn until it unsubscribes.
+func (bus *eventBus) deliverEvents(conn net.Conn, s *subscriber) {
+	for text := range s.queue {
+		if n := bus.takeDropped(s); n > 0 {
+			fmt.Fprintf(conn, "\n(%d more things happened nearby while you were busy.)", n)
+		}
+		fmt.Fprintf(conn, "\n%s\n", text)
+	}
+}
+
+// describeEvent tells a player about an event that happened dx, dy tiles away from them.
+func describeEvent(e WorldEvent, dx, dy int) string {
+	where := describeOffset(dx, dy)
+	switch e.Kind {
+	case eventSpawn:
+		return fmt.Sprintf("A wild %s appeared %s.", e.Pokemon, where)
+	case eventDespawn:
+		return fmt.Sprintf("The wild %s %s is gone.", e.Pokemon, where)
+	case eventCapture:
+		return fmt.Sprintf("%s caught the wild %s %s.", e.Player, e.Pokemon, where)
+	case eventJoin:
+		return fmt.Sprintf("%s arrived %s.", e.Player, where)
+	case eventLeave:
+		return fmt.Sprintf("%s left the world %s.", e.Player, where)
+	case eventMove:
+		return fmt.Sprintf("%s came into view %s.", e.Player, where)
+	}
+	return fmt.Sprintf("Something happened %s.", where)
+}
+
+// describeOffset tells where a tile dx, dy tiles away is, e.g. "5 tiles north" or
+// "2 tiles south and 1 tile east". North is up.
+func describeOffset(dx, dy int) string {
+	var parts []string
+	if dy < 0 {
+		parts = append(parts, tiles(-dy)+" north")
+	} else if dy > 0 {
+		parts = append(parts, tiles(dy)+" south")
+	}
+	if dx > 0 {
+		parts = append(parts, tiles(dx)+" east")
+	} else if dx < 0 {
+		parts = append(parts, tiles(-dx)+" west")
+	}
+	if len(parts) == 0 {
+		return "right here"
+	}
+	return strings.Join(parts, " and ")
+}
+
+func tiles(n int) string {
+	if n == 1 {
+		return "1 tile"
+	}
+	return fmt.Sprintf("%d tiles", n)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testBus returns a bus with an interest radius of 5 tiles and queues of 2 notifications.
+func testBus() *eventBus {
+	bus := newEventBus()
+	bus.configure(WorldConfig{InterestRadius: 5, EventQueueSize: 2})
+	return bus
+}
+
+func TestPublishInterestArea(t *testing.T) {
+	bus := testBus()
+	me := &Player{ID: 1}
+	s := bus.subscribe(me, 10, 10)
+	tests := []struct {
+		name  string
+		event WorldEvent
+		sent  bool
+	}{
+		{"on the edge", WorldEvent{Kind: eventSpawn, X: 15, Y: 5, Pokemon: "rattata"}, true},
+		{"out of range east", WorldEvent{Kind: eventSpawn, X: 16, Y: 10, Pokemon: "rattata"}, false},
+		{"out of range north", WorldEvent{Kind: eventSpawn, X: 10, Y: 4, Pokemon: "rattata"}, false},
+		{"own event", WorldEvent{Kind: eventJoin, X: 10, Y: 10, Player: "ash", player: me}, false},
+		{"walking in view", WorldEvent{Kind: eventMove, X: 11, Y: 10, FromX: 12, FromY: 10, Player: "misty"}, false},
+		{"coming into view", WorldEvent{Kind: eventMove, X: 15, Y: 10, FromX: 16, FromY: 10, Player: "misty"}, true},
+	}
+	for _, tt := range tests {
+		bus.publish(tt.event)
+		select {
+		case text := <-s.queue:
+			if !tt.sent {
+				t.Errorf("%s: sent %q", tt.name, text)
+			}
+		default:
+			if tt.sent {
+				t.Errorf("%s: nothing sent", tt.name)
+			}
+		}
+	}
+
+	// The area moves with the player.
+	bus.moveTo(s, 20, 10)
+	bus.publish(WorldEvent{Kind: eventSpawn, X: 16, Y: 10, Pokemon: "pidgey"})
+	if len(s.queue) != 1 {
+		t.Errorf("%d notifications after moving next to a spawn, want 1", len(s.queue))
+	}
+}
+
+func TestPublishDropsWhenQueueIsFull(t *testing.T) {
+	bus := testBus()
+	s := bus.subscribe(&Player{ID: 1}, 0, 0)
+	for _, name := range []string{"rattata", "pidgey", "zubat", "geodude"} {
+		bus.publish(WorldEvent{Kind: eventSpawn, X: 1, Y: 0, Pokemon: name})
+	}
+	if len(s.queue) != 2 {
+		t.Fatalf("%d notifications queued, want 2", len(s.queue))
+	}
+
+	// The client hears about the first two and how many it missed.
+	bus.unsubscribe(s)
+	conn := &recordConn{}
+	bus.deliverEvents(conn, s)
+	out := conn.take()
+	want := "\n(2 more things happened nearby while you were busy.)\nA wild rattata appeared 1 tile east.\n" +
+		"\nA wild pidgey appeared 1 tile east.\n"
+	if out != want {
+		t.Errorf("delivered %q, want %q", out, want)
+	}
+	if n := bus.takeDropped(s); n != 0 {
+		t.Errorf("%d dropped notifications left after delivering", n)
+	}
+}
+
+func TestPublishAfterUnsubscribe(t *testing.T) {
+	bus := testBus()
+	s := bus.subscribe(&Player{ID: 1}, 0, 0)
+	bus.unsubscribe(s)
+	bus.unsubscribe(s) // Closing twice would panic
+	bus.publish(WorldEvent{Kind: eventSpawn, X: 0, Y: 0, Pokemon: "rattata"})
+	if _, open := <-s.queue; open {
+		t.Error("notified after unsubscribing")
+	}
+}
+
+func TestDescribeOffset(t *testing.T) {
+	tests := []struct {
+		dx, dy int
+		want   string
+	}{
+		{0, 0, "right here"},
+		{0, -1, "1 tile north"},
+		{3, 0, "3 tiles east"},
+		{-1, 2, "2 tiles south and 1 tile west"},
+	}
+	for _, tt := range tests {
+		if got := describeOffset(tt.dx, tt.dy); got != tt.want {
+			t.Errorf("(%d, %d): %q, want %q", tt.dx, tt.dy, got, tt.want)
+		}
+	}
+	if got := describeEvent(WorldEvent{Kind: eventCapture, Player: "ash", Pokemon: "pidgey"}, 0, 1); !strings.HasPrefix(got, "ash caught the wild pidgey 1 tile south") {
+		t.Errorf("capture described as %q", got)
+	}
+}
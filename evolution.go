@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// evolutionDelay is how long an owner has to cancel an evolution before it happens.
+const evolutionDelay = 10 * time.Second
+
+// evolutionTarget returns the species a Pokémon evolves into at its current level, if any.
+func evolutionTarget(p Pokemon) (Pokemon, bool) {
+	current, ok := species[p.Name]
+	if !ok || current.EvolvesTo == "" || p.Level < current.EvolutionLevel {
+		return Pokemon{}, false
+	}
+	target, ok := species[current.EvolvesTo]
+	return target, ok
+}
+
+// evolve turns a Pokémon into the target species. Level, accumulated experience and EV are
+// kept, while the name, types and base experience are taken from the new species and the
+// stats are recomputed from its base stats. The ability keeps its slot in the species' list.
+func evolve(p *Pokemon, target Pokemon) {
+	if current, ok := species[p.Name]; ok {
+		for i, name := range current.Abilities {
+			if name == abilityName(p) && i < len(target.Abilities) {
+				p.Ability = target.Abilities[i]
+				break
+			}
+		}
+	}
+	p.Name = target.Name
+	p.Type = target.Type
+	p.BaseExp = target.BaseExp
+	recomputeStats(p)
+}
+
+// scheduleEvolution starts the evolution of a Pokémon in the client's collection if its level
+// allows it. The owner is notified and can cancel it within evolutionDelay.
+// The caller must hold player.mutex.
+func scheduleEvolution(client *Client, key int) {
+	player := client.player
+	pokemon, ok := player.Pokemons[key]
+	if !ok {
+		return
+	}
+	target, ok := evolutionTarget(pokemon)
+	if !ok {
+		return
+	}
+	if _, pending := player.evolutions[key]; pending {
+		return
+	}
+
+	if player.evolutions == nil {
+		player.evolutions = make(map[int]Timer)
+	}
+	player.evolutions[key] = pokeworld.clock.AfterFunc(evolutionDelay, func() {
+		player.mutex.Lock()
+		defer player.mutex.Unlock()
+
+		if _, pending := player.evolutions[key]; !pending {
+			return // Cancelled, or already applied at logout
+		}
+		delete(player.evolutions, key)
+		completeEvolution(client, key, target)
+		if err := savePlayer(player); err != nil {
+			log.Printf("Error saving data for player %d: %v", player.ID, err)
+		}
+	})
+	fmt.Fprintf(client.conn, "What? %s is evolving into %s! Type 'cancel %d' within %d seconds to stop it.\n",
+		pokemon.Name, target.Name, key, int(evolutionDelay/time.Second))
+}
+
+// completeEvolution evolves a Pokémon of the player and tells the owner.
+// If the new species can evolve further at its level, that evolution is scheduled next.
+// The caller must hold player.mutex.
+func completeEvolution(client *Client, key int, target Pokemon) {
+	player := client.player
+	pokemon, ok := player.Pokemons[key]
+	if !ok {
+		return // Released in the meantime
+	}
+	oldName := pokemon.Name
+	evolve(&pokemon, target)
+	player.Pokemons[key] = pokemon
+	fmt.Fprintf(client.conn, "Congratulations! Your %s evolved into %s!\n", oldName, pokemon.Name)
+
+	scheduleEvolution(client, key)
+}
+
+// finishEvolutions applies every pending evolution right away, e.g. when the owner logs out.
+// The caller must hold player.mutex.
+func finishEvolutions(client *Client) {
+	player := client.player
+	for len(player.evolutions) > 0 {
+		for key, timer := range player.evolutions {
+			timer.Stop()
+			delete(player.evolutions, key)
+			if target, ok := evolutionTarget(player.Pokemons[key]); ok {
+				completeEvolution(client, key, target)
+			}
+		}
+	}
+}
+
+// cmdCancel cancels pending evolutions: one Pokémon by number, or all of them.
+// A cancelled Pokémon tries to evolve again the next time it levels up.
+func cmdCancel(client *Client, args []string, pokedex []Pokemon) {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if len(player.evolutions) == 0 {
+		fmt.Fprintln(client.conn, "No evolution is in progress.")
+		return
+	}
+
+	keys := make([]int, 0, len(player.evolutions))
+	if len(args) > 0 {
+		key, err := strconv.Atoi(args[0])
+		if _, pending := player.evolutions[key]; err != nil || !pending {
+			fmt.Fprintf(client.conn, "Pokémon %s is not evolving.\n", args[0])
+			return
+		}
+		keys = append(keys, key)
+	} else {
+		for key := range player.evolutions {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		player.evolutions[key].Stop()
+		delete(player.evolutions, key)
+		fmt.Fprintf(client.conn, "Huh? %s stopped evolving!\n", player.Pokemons[key].Name)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// maxLevel is the highest level a Pokémon can reach.
+const maxLevel = 100
+
+// species indexes the Pokédex by name; it holds the base stats used when stats are recomputed.
+var species = make(map[string]Pokemon)
+
+// indexPokedex fills the species index from the loaded Pokédex.
+func indexPokedex(pokedex []Pokemon) {
+	for _, p := range pokedex {
+		species[p.Name] = p
+	}
+}
+
+// expForLevel returns the total experience needed to reach a level (medium fast growth).
+func expForLevel(level int) int {
+	return level * level * level
+}
+
+// experienceYield returns the experience gained for defeating a Pokémon.
+func experienceYield(defeated *Pokemon) int {
+	exp := defeated.BaseExp * defeated.Level / 7
+	if exp < 1 {
+		exp = 1
+	}
+	return exp
+}
+
+// calculateStat returns a stat for the given base stat and level, using ev as a growth multiplier.
+func calculateStat(base, level int, ev float64) int {
+	return int(float64(2*base*level)*ev/100) + 5
+}
+
+// calculateHP is calculateStat for hit points, which grow by an extra point per level.
+func calculateHP(base, level int, ev float64) int {
+	return int(float64(2*base*level)*ev/100) + level + 10
+}
+
+// recomputeStats sets the stats of a Pokémon from its species' base stats, level and EV spread.
+// Pokémon whose species is unknown keep their current stats.
+func recomputeStats(p *Pokemon) {
+	base, ok := species[p.Name]
+	if !ok {
+		return
+	}
+	p.HP = calculateHP(base.HP, p.Level, p.statEV(statHP))
+	p.Attack = calculateStat(base.Attack, p.Level, p.statEV(statAttack))
+	p.Defense = calculateStat(base.Defense, p.Level, p.statEV(statDefense))
+	p.SpecialAttack = calculateStat(base.SpecialAttack, p.Level, p.statEV(statSpecialAttack))
+	p.SpecialDefense = calculateStat(base.SpecialDefense, p.Level, p.statEV(statSpecialDefense))
+	p.Speed = calculateStat(base.Speed, p.Level, p.statEV(statSpeed))
+}
+
+// gainExperience adds experience to a Pokémon and levels it up for every threshold crossed.
+// It returns the number of levels gained.
+func gainExperience(p *Pokemon, exp int) int {
+	// Pokémon that never gained experience start at the threshold of their current level.
+	if p.AccumExp < expForLevel(p.Level) {
+		p.AccumExp = expForLevel(p.Level)
+	}
+	p.AccumExp += exp
+
+	levels := 0
+	for p.Level < maxLevel && p.AccumExp >= expForLevel(p.Level+1) {
+		p.Level++
+		levels++
+	}
+	if levels > 0 {
+		recomputeStats(p)
+	}
+	return levels
+}
+
+// addExperience records experience earned by a team member during a battle.
+// It is applied to the owner's collection when the battle ends; draft Pokémon earn nothing.
+func (client *Client) addExperience(p *Pokemon, exp int) {
+	if p.collectionKey == 0 {
+		return
+	}
+	if client.expGained == nil {
+		client.expGained = make(map[int]int)
+	}
+	client.expGained[p.collectionKey] += exp
+}
+
+// awardFaintExperience gives the Pokémon that knocked out another the experience it is worth.
+// Battles use it as their onFaint hook; only Pokémon from a collection keep what they earn.
+func awardFaintExperience(b *Battle, side int, fainted *combatant) {
+	winner := b.sides[1-side].current().pokemon
+	if winner.Owner != nil {
+		winner.Owner.addExperience(winner, experienceYield(fainted.pokemon))
+	}
+}
+
+// awardWinExperience shares a bonus among the winner's remaining Pokémon:
+// half of the experience the defeated team is worth.
+func awardWinExperience(winner *Client, defeated []*Pokemon) {
+	if len(winner.team) == 0 {
+		return
+	}
+	total := 0
+	for _, p := range defeated {
+		total += experienceYield(p)
+	}
+	bonus := total / (2 * len(winner.team))
+	for _, p := range winner.team {
+		winner.addExperience(p, bonus)
+	}
+}
+
+// applyBattleExperience adds the experience earned in a battle to the client's collection,
+// saves it and reports the result. It returns the total experience gained.
+func applyBattleExperience(client *Client) int {
+	if len(client.expGained) == 0 {
+		return 0
+	}
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	keys := make([]int, 0, len(client.expGained))
+	for key := range client.expGained {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	total := 0
+	fmt.Fprintln(client.conn, "Battle summary:")
+	for _, key := range keys {
+		pokemon, ok := player.Pokemons[key]
+		if !ok {
+			continue // Released during the battle
+		}
+		exp := client.expGained[key]
+		total += exp
+
+		levels := gainExperience(&pokemon, exp)
+		player.Pokemons[key] = pokemon
+		if levels > 0 {
+			fmt.Fprintf(client.conn, "  %s gained %d exp and grew to Lv.%d!\n", pokemon.Name, exp, pokemon.Level)
+			scheduleEvolution(client, key)
+		} else {
+			fmt.Fprintf(client.conn, "  %s gained %d exp.\n", pokemon.Name, exp)
+		}
+	}
+
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	return total
+}
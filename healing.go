@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// pokemonCenterSpacing is the distance between Pokémon Centers. They stand in the middle of
+// every pokemonCenterSpacing x pokemonCenterSpacing square of the world.
+const pokemonCenterSpacing = 100
+
+// currentHP returns the HP a Pokémon has left.
+func (p *Pokemon) currentHP() int {
+	if p.HPLost >= p.HP {
+		return 0
+	}
+	return p.HP - p.HPLost
+}
+
+// fainted reports whether a Pokémon has no HP left and can't battle until it is healed.
+func (p *Pokemon) fainted() bool {
+	return p.currentHP() == 0
+}
+
+// healthy reports whether a Pokémon has neither lost HP nor has a status condition.
+func (p *Pokemon) healthy() bool {
+	return p.HPLost == 0 && p.Status == ""
+}
+
+// isPokemonCenter reports whether there is a Pokémon Center at (x, y).
+func isPokemonCenter(x, y int) bool {
+	half := pokemonCenterSpacing / 2
+	return x%pokemonCenterSpacing == half && y%pokemonCenterSpacing == half
+}
+
+// nearestPokemonCenter returns the position of the Pokémon Center closest to (x, y).
+// ok is false if the world is too small to have one.
+func nearestPokemonCenter(x, y int) (cx, cy int, ok bool) {
+	nearest := func(v, size int) (int, bool) {
+		c := v/pokemonCenterSpacing*pokemonCenterSpacing + pokemonCenterSpacing/2
+		if c >= size {
+			c -= pokemonCenterSpacing
+		}
+		return c, c >= 0
+	}
+	cx, okX := nearest(x, pokeworld.Width)
+	cy, okY := nearest(y, pokeworld.Height)
+	return cx, cy, okX && okY
+}
+
+// healAll restores the HP and cures the status of every Pokémon of the player and saves them.
+// It returns the number of Pokémon healed. The caller must hold player.mutex.
+func healAll(player *Player) int {
+	healed := 0
+	for key, pokemon := range player.Pokemons {
+		if pokemon.healthy() {
+			continue
+		}
+		pokemon.HPLost = 0
+		pokemon.Status = ""
+		player.Pokemons[key] = pokemon
+		healed++
+	}
+	if healed > 0 {
+		if err := savePlayer(player); err != nil {
+			log.Printf("Error saving data for player %d: %v", player.ID, err)
+		}
+	}
+	return healed
+}
+
+// visitPokemonCenter heals the client's Pokémon at the Pokémon Center they stepped on.
+func visitPokemonCenter(client *Client) {
+	player := client.player
+	player.mutex.Lock()
+	healed := healAll(player)
+	player.mutex.Unlock()
+
+	fmt.Fprintln(client.conn, "Welcome to the Pokémon Center!")
+	if healed > 0 {
+		fmt.Fprintln(client.conn, "Your Pokémon have been restored to full health. We hope to see you again!")
+	}
+}
+
+// cmdHeal restores the client's Pokémon. Away from a Pokémon Center it can only be used once
+// every heal cooldown (see WorldConfig).
+func cmdHeal(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	hurt := 0
+	for _, pokemon := range player.Pokemons {
+		if !pokemon.healthy() {
+			hurt++
+		}
+	}
+	if hurt == 0 {
+		fmt.Fprintln(conn, "Your Pokémon are all healthy.")
+		return
+	}
+
+	atCenter := isPokemonCenter(player.X, player.Y)
+	if wait := time.Duration(currentConfig().World.HealCooldown) - pokeworld.clock.Now().Sub(player.LastHeal); !atCenter && wait > 0 {
+		fmt.Fprintf(conn, "You can heal again in %s.", wait.Round(time.Second))
+		if cx, cy, ok := nearestPokemonCenter(player.X, player.Y); ok {
+			fmt.Fprintf(conn, " The nearest Pokémon Center is at (%d, %d).", cx, cy)
+		}
+		fmt.Fprintln(conn)
+		return
+	}
+
+	if !atCenter {
+		player.LastHeal = pokeworld.clock.Now()
+	}
+	healAll(player)
+	fmt.Fprintln(conn, "Your Pokémon have been restored to full health.")
+}
+
+// keepBattleState copies the HP and status conditions of the client's battle team back to their
+// collection and saves it, so they last until healed.
+func keepBattleState(client *Client) {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	changed := false
+	for _, p := range client.roster {
+		pokemon, ok := player.Pokemons[p.collectionKey]
+		if p.collectionKey == 0 || !ok || (pokemon.Status == p.Status && pokemon.HPLost == p.HPLost) {
+			continue
+		}
+		pokemon.Status = p.Status
+		pokemon.HPLost = p.HPLost
+		player.Pokemons[p.collectionKey] = pokemon
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+}
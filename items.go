@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of items.
+const (
+	itemBall   = "ball"   // Catches wild Pokémon
+	itemPotion = "potion" // Restores HP
+	itemHeal   = "heal"   // Cures status conditions
+	itemHeld   = "held"   // Held by a Pokémon, boosting one of its stats in battle
+)
+
+// Item is something a player can carry in their bag.
+type Item struct {
+	Name       string
+	Kind       string
+	CatchBonus float64  // Factor on the catch chance of a ball
+	Heal       int      // HP restored by a potion
+	Cures      []string // Statuses cured by a heal; empty cures all of them
+	Stat       int      // Stat boosted by a held item
+	Boost      float64  // Factor on Stat
+	findWeight int      // Relative chance of finding the item in the world; 0 if it can't be found
+}
+
+// items are all known items, keyed by the name players type, e.g. "great-ball".
+var items = map[string]*Item{
+	"poke-ball":     {Name: "Poké Ball", Kind: itemBall, CatchBonus: 1, findWeight: 40},
+	"great-ball":    {Name: "Great Ball", Kind: itemBall, CatchBonus: 1.5, findWeight: 15},
+	"ultra-ball":    {Name: "Ultra Ball", Kind: itemBall, CatchBonus: 2, findWeight: 5},
+	"potion":        {Name: "Potion", Kind: itemPotion, Heal: 20, findWeight: 25},
+	"super-potion":  {Name: "Super Potion", Kind: itemPotion, Heal: 50, findWeight: 8},
+	"antidote":      {Name: "Antidote", Kind: itemHeal, Cures: []string{statusPoison}, findWeight: 6},
+	"burn-heal":     {Name: "Burn Heal", Kind: itemHeal, Cures: []string{statusBurn}, findWeight: 6},
+	"paralyze-heal": {Name: "Paralyze Heal", Kind: itemHeal, Cures: []string{statusParalysis}, findWeight: 6},
+	"awakening":     {Name: "Awakening", Kind: itemHeal, Cures: []string{statusSleep}, findWeight: 6},
+	"ice-heal":      {Name: "Ice Heal", Kind: itemHeal, Cures: []string{statusFreeze}, findWeight: 6},
+	"full-heal":     {Name: "Full Heal", Kind: itemHeal, findWeight: 2},
+	"muscle-band":   {Name: "Muscle Band", Kind: itemHeld, Stat: statAttack, Boost: 1.1, findWeight: 1},
+	"wise-glasses":  {Name: "Wise Glasses", Kind: itemHeld, Stat: statSpecialAttack, Boost: 1.1, findWeight: 1},
+	"assault-vest":  {Name: "Assault Vest", Kind: itemHeld, Stat: statSpecialDefense, Boost: 1.5, findWeight: 1},
+	"choice-scarf":  {Name: "Choice Scarf", Kind: itemHeld, Stat: statSpeed, Boost: 1.5, findWeight: 1},
+}
+
+// starterBag is what new players start with.
+var starterBag = Bag{"poke-ball": 10, "potion": 3}
+
+// itemFindChance is the chance of finding an item on a tile without a wild Pokémon.
+const itemFindChance = 0.05
+
+// itemName returns the display name of an item, or its key if it is unknown.
+func itemName(key string) string {
+	if item, ok := items[key]; ok {
+		return item.Name
+	}
+	return key
+}
+
+// itemKey turns an item name as typed by a player, e.g. "Great Ball", into its key in items.
+func itemKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+}
+
+// describe summarizes what an item does.
+func (item *Item) describe() string {
+	switch item.Kind {
+	case itemBall:
+		return fmt.Sprintf("catch rate x%.1f", item.CatchBonus)
+	case itemPotion:
+		return fmt.Sprintf("restores %d HP", item.Heal)
+	case itemHeal:
+		if len(item.Cures) == 0 {
+			return "cures any status condition"
+		}
+		return "cures " + strings.Join(item.Cures, ", ")
+	case itemHeld:
+		return fmt.Sprintf("held: %s x%.1f in battle", stageNames[item.Stat], item.Boost)
+	}
+	return ""
+}
+
+// cures reports whether a heal cures a status.
+func (item *Item) cures(status string) bool {
+	if status == "" || item.Kind != itemHeal {
+		return false
+	}
+	if len(item.Cures) == 0 {
+		return true
+	}
+	for _, s := range item.Cures {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Bag counts the items a player carries by key.
+type Bag map[string]int
+
+// add puts n of an item into the bag.
+func (bag Bag) add(key string, n int) {
+	bag[key] += n
+}
+
+// take removes one of an item from the bag and reports whether there was one.
+func (bag Bag) take(key string) bool {
+	if bag[key] <= 0 {
+		return false
+	}
+	bag[key]--
+	if bag[key] == 0 {
+		delete(bag, key)
+	}
+	return true
+}
+
+// keys returns the keys of the items in the bag, sorted.
+func (bag Bag) keys() []string {
+	keys := make([]string, 0, len(bag))
+	for key := range bag {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clone copies the bag.
+func (bag Bag) clone() Bag {
+	copied := make(Bag, len(bag))
+	for key, n := range bag {
+		copied[key] = n
+	}
+	return copied
+}
+
+// defaultBall returns the weakest ball the player has, so that better ones are kept for
+// harder catches, or "" if they have none.
+func defaultBall(bag Bag) string {
+	best := ""
+	for key, n := range bag {
+		item := items[key]
+		if n <= 0 || item == nil || item.Kind != itemBall {
+			continue
+		}
+		if best == "" || item.CatchBonus < items[best].CatchBonus {
+			best = key
+		}
+	}
+	return best
+}
+
+// holdItem applies the held item of a Pokémon to a battle copy of its stats.
+func holdItem(p *Pokemon) {
+	item := items[p.HeldItem]
+	if item == nil || item.Kind != itemHeld {
+		return
+	}
+	boost := func(stat int) int { return int(float64(stat) * item.Boost) }
+	switch item.Stat {
+	case statAttack:
+		p.Attack = boost(p.Attack)
+	case statDefense:
+		p.Defense = boost(p.Defense)
+	case statSpecialAttack:
+		p.SpecialAttack = boost(p.SpecialAttack)
+	case statSpecialDefense:
+		p.SpecialDefense = boost(p.SpecialDefense)
+	case statSpeed:
+		p.Speed = boost(p.Speed)
+	}
+}
+
+// takeItem removes one of an item from the player's bag and saves it.
+func takeItem(player *Player, key string) bool {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	if !player.Bag.take(key) {
+		return false
+	}
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	return true
+}
+
+// countItem returns how many of an item the player has.
+func countItem(player *Player, key string) int {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	return player.Bag[key]
+}
+
+// putItem adds one of an item to the player's bag and saves it.
+func putItem(player *Player, key string) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	player.Bag.add(key, 1)
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+}
+
+// giveItem adds an item to the client's bag, saves it and tells them.
+func giveItem(client *Client, key string, reason string) {
+	putItem(client.player, key)
+	fmt.Fprintf(client.conn, reason+"\n", itemName(key))
+}
+
+// randomItem picks an item by its find weight.
+func (pw *Pokeworld) randomItem() string {
+	keys := make([]string, 0, len(items))
+	total := 0
+	for key, item := range items {
+		if item.findWeight > 0 {
+			keys = append(keys, key)
+			total += item.findWeight
+		}
+	}
+	sort.Strings(keys) // Map order is random; keep the pick reproducible for a seeded world
+	roll := pw.rng.Intn(total)
+	for _, key := range keys {
+		if roll -= items[key].findWeight; roll < 0 {
+			return key
+		}
+	}
+	return keys[len(keys)-1]
+}
+
+// findItem rolls for an item lying on the tile a player stepped on. It returns "" if there is none.
+func (pw *Pokeworld) findItem() string {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.rng.Float64() >= itemFindChance {
+		return ""
+	}
+	return pw.randomItem()
+}
+
+// prizeItem picks the item a trainer earns for winning a battle.
+func (pw *Pokeworld) prizeItem() string {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.randomItem()
+}
+
+// awardPrize gives the winner of a battle against a trainer a random item.
+// Battles outside the world, e.g. in the simulator, have no prizes.
+func awardPrize(client *Client) {
+	if pokeworld == nil {
+		return
+	}
+	giveItem(client, pokeworld.prizeItem(), "You received a %s for winning!")
+}
+
+// takeItem uses up one of an item of a side, if the rules allow items and the side has one left.
+func (b *Battle) takeItem(side int, key string) bool {
+	return b.rules.Items && b.onItem != nil && b.onItem(b, side, key)
+}
+
+// useItem uses a potion or a heal on the active Pokémon of a side.
+func (b *Battle) useItem(side int, key string) {
+	own := b.sides[side]
+	c := own.current()
+	item := items[key]
+	if item == nil {
+		return
+	}
+	if (item.Kind == itemPotion && c.hp >= c.maxHP) || (item.Kind == itemHeal && !item.cures(c.status)) {
+		b.emit(BattleEvent{Kind: "item", Side: side, Pokemon: c.pokemon.Name, Item: item.Name,
+			Text: fmt.Sprintf("%s wants to use a %s, but it won't have any effect.", own.name, item.Name)})
+		return
+	}
+	if !b.takeItem(side, key) {
+		b.emit(BattleEvent{Kind: "item", Side: side, Item: item.Name, Text: fmt.Sprintf("%s has no %s left!", own.name, item.Name)})
+		return
+	}
+
+	used := fmt.Sprintf("%s used a %s! ", own.name, item.Name)
+	switch item.Kind {
+	case itemPotion:
+		healed := item.Heal
+		if healed > c.maxHP-c.hp {
+			healed = c.maxHP - c.hp
+		}
+		c.hp += healed
+		b.emit(BattleEvent{Kind: "item", Side: side, Pokemon: c.pokemon.Name, Item: item.Name, HP: c.hp,
+			Text: used + fmt.Sprintf("%s regained %d HP (%d/%d HP).", own.label(c), healed, c.hp, c.maxHP)})
+	case itemHeal:
+		b.emit(BattleEvent{Kind: "item", Side: side, Pokemon: c.pokemon.Name, Item: item.Name, Text: strings.TrimSpace(used)})
+		b.cureStatus(side, c, "%s was cured!")
+	}
+}
+
+// bagItemHook returns a battle's onItem hook taking items from the bags of the clients of each side.
+// Sides without a client have no bag.
+func bagItemHook(clients ...*Client) func(b *Battle, side int, key string) bool {
+	return func(b *Battle, side int, key string) bool {
+		if side >= len(clients) || clients[side] == nil {
+			return false
+		}
+		return takeItem(clients[side].player, key)
+	}
+}
+
+// cmdBag lists the items in the player's bag.
+func cmdBag(client *Client, args []string, pokedex []Pokemon) {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if len(player.Bag) == 0 {
+		fmt.Fprintln(client.conn, "Your bag is empty.")
+		return
+	}
+	fmt.Fprintln(client.conn, "Your bag:")
+	for _, key := range player.Bag.keys() {
+		item := items[key]
+		if item == nil {
+			continue
+		}
+		fmt.Fprintf(client.conn, "  %-14s x%-3d %s (%s)\n", key, player.Bag[key], item.Name, item.describe())
+	}
+}
+
+// cmdUse uses an item outside of battle: balls are thrown at the wild Pokémon in front of the
+// player, heals and potions are used on one of their Pokémon and held items are given to one.
+func cmdUse(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) == 0 {
+		fmt.Fprintln(conn, "Usage: use <item> [number]")
+		return
+	}
+	target := 0
+	if n, err := strconv.Atoi(args[len(args)-1]); err == nil && len(args) > 1 {
+		target = n
+		args = args[:len(args)-1]
+	}
+	key := itemKey(strings.Join(args, " "))
+	item := items[key]
+	if item == nil {
+		fmt.Fprintf(conn, "There is no item called %q. Type 'bag' to see your items.\n", strings.Join(args, " "))
+		return
+	}
+	if item.Kind == itemBall {
+		throwBall(client, key)
+		return
+	}
+	if target == 0 {
+		fmt.Fprintf(conn, "Usage: use %s <number>\n", key)
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	pokemon, ok := player.Pokemons[target]
+	if !ok {
+		fmt.Fprintf(conn, "You have no Pokémon number %d.\n", target)
+		return
+	}
+	if player.Bag[key] <= 0 {
+		fmt.Fprintf(conn, "You have no %s.\n", item.Name)
+		return
+	}
+
+	switch item.Kind {
+	case itemPotion:
+		if pokemon.fainted() || pokemon.HPLost == 0 {
+			fmt.Fprintln(conn, "It won't have any effect.")
+			return
+		}
+		healed := item.Heal
+		if healed > pokemon.HPLost {
+			healed = pokemon.HPLost
+		}
+		pokemon.HPLost -= healed
+		fmt.Fprintf(conn, "%s regained %d HP (%d/%d HP).\n", pokemon.Name, healed, pokemon.currentHP(), pokemon.HP)
+	case itemHeal:
+		if !item.cures(pokemon.Status) {
+			fmt.Fprintln(conn, "It won't have any effect.")
+			return
+		}
+		pokemon.Status = ""
+		fmt.Fprintf(conn, "%s was cured.\n", pokemon.Name)
+	case itemHeld:
+		if pokemon.HeldItem != "" {
+			player.Bag.add(pokemon.HeldItem, 1)
+			fmt.Fprintf(conn, "%s put its %s back in the bag.\n", pokemon.Name, itemName(pokemon.HeldItem))
+		}
+		pokemon.HeldItem = key
+		fmt.Fprintf(conn, "%s is now holding a %s.\n", pokemon.Name, item.Name)
+	}
+	player.Bag.take(key)
+	player.Pokemons[target] = pokemon
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+}
+
+// cmdTake puts the item a Pokémon is holding back into the bag.
+func cmdTake(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: take <number>")
+		return
+	}
+	key, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintln(conn, "Usage: take <number>")
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	pokemon, ok := player.Pokemons[key]
+	if !ok {
+		fmt.Fprintf(conn, "You have no Pokémon number %d.\n", key)
+		return
+	}
+	if pokemon.HeldItem == "" {
+		fmt.Fprintf(conn, "%s isn't holding anything.\n", pokemon.Name)
+		return
+	}
+	player.Bag.add(pokemon.HeldItem, 1)
+	fmt.Fprintf(conn, "You took the %s from %s.\n", itemName(pokemon.HeldItem), pokemon.Name)
+	pokemon.HeldItem = ""
+	player.Pokemons[key] = pokemon
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+}
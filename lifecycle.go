@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// shutdownMessage is sent to every connected client when the server shuts down.
+const shutdownMessage = "\nThe server is shutting down. Your progress is being saved. See you soon!"
+
+// serve runs the server until ctx is cancelled: it spawns wild Pokémon, reloads the config on
+// SIGHUP, serves the metrics if configured and runs a session for every connection accepted
+// on listener.
+//
+// Once ctx is cancelled it stops accepting connections and ends every session: the clients are
+// told, battles in progress are called off and players are saved as they log out. Then the
+// despawn timers are stopped and the wild Pokémon are saved, to be restored at the next start.
+// serve returns after every goroutine it started has finished.
+func serve(ctx context.Context, listener net.Listener, pokedex []Pokemon, source *configSource) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pokeworld.spawnPokemonLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		watchConfig(ctx, source)
+	}()
+
+	var metrics *http.Server
+	if addr := currentConfig().Metrics; addr != "" {
+		metrics = &http.Server{Addr: addr, Handler: metricsHandler(pokeworld)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := metrics.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+		if metrics != nil {
+			metrics.Close()
+		}
+	})
+	defer stop()
+
+	// Every connection gets its own session: login first, then lobby commands.
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error accepting connection: %v", err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleSession(ctx, conn, pokedex)
+		}()
+	}
+
+	wg.Wait()
+	state := pokeworld.state()
+	pokeworld.stopDespawnTimers()
+	if err := store.SaveWorld(state); err != nil {
+		log.Printf("Error saving world state: %v", err)
+	}
+}
+
+// stopReading makes every read from conn fail, which ends whatever the session was waiting for,
+// while messages can still be written to it. Connections that can't be half-closed are closed.
+func stopReading(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseRead() error }); ok {
+		c.CloseRead()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readUntil reads lines from r until one contains want.
+func readUntil(r *bufio.Reader, want string) error {
+	for {
+		line, err := r.ReadString('\n')
+		if strings.Contains(line, want) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading until %q: %v", want, err)
+		}
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	oldConfig, oldGrid := currentConfig(), world
+	t.Cleanup(func() { setConfig(oldConfig); world = oldGrid })
+	cfg := defaultConfig()
+	cfg.Metrics = ""
+	cfg.World.Width, cfg.World.Height = pokeworld.Width, pokeworld.Height
+	setConfig(cfg)
+	world = make([][]*Player, cfg.World.Width)
+	for x := range world {
+		world[x] = make([]*Player, cfg.World.Height)
+	}
+
+	// The first signal.Notify starts a goroutine of os/signal that runs for good; start it now so
+	// that it doesn't count against serve.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	signal.Stop(hangup)
+	baseline := runtime.NumGoroutine()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, listener, testPokedex, &configSource{flags: make(map[string]string)})
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	if err := readUntil(reader, "register <username> <password>"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(conn, "register ash secret1")
+	fmt.Fprintln(conn, "help")
+	if err := readUntil(reader, "Commands:"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := readUntil(reader, strings.TrimSpace(shutdownMessage)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't return after the context was cancelled")
+	}
+
+	account, err := store.LoadAccount("ash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadPlayer(account.PlayerID); err != nil {
+		t.Errorf("player not saved at shutdown: %v", err)
+	}
+	if state, err := store.LoadWorld(); err != nil || state == nil {
+		t.Errorf("world not saved at shutdown: %v", err)
+	}
+
+	// Goroutines that were told to stop may still be on their way out.
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines after serve returned, %d before it started:\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
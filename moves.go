@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Move categories. Physical moves use Attack against Defense, special moves Special Attack
+// against Special Defense, and status moves deal no damage.
+const (
+	categoryPhysical = "physical"
+	categorySpecial  = "special"
+	categoryStatus   = "status"
+)
+
+// Move is an attack a Pokémon can use in battle.
+type Move struct {
+	Name         string
+	Category     string
+	Type         string                                // Type of the attack; empty for Special Attack, which uses the attacker's types
+	damage       func(attacker, defender *Pokemon) int // nil for status moves
+	Status       string                                // Status condition the move may inflict on the target
+	StatusChance float64                               // Chance of inflicting Status
+	Stages       []StageChange                         // Stat stages the move changes
+}
+
+// basicMoves are the moves every Pokémon knows.
+var basicMoves = []Move{
+	{Name: "Normal Attack", Category: categoryPhysical, Type: "normal", damage: calculateNormalDamage},
+	{Name: "Special Attack", Category: categorySpecial, damage: calculateSpecialDamage},
+}
+
+// typeMoves are the extra moves a Pokémon knows because of its types.
+var typeMoves = map[string][]Move{
+	"fire":     {{Name: "Ember", Category: categorySpecial, damage: calculateSpecialDamage, Status: statusBurn, StatusChance: 0.2}},
+	"poison":   {{Name: "Poison Sting", Category: categoryPhysical, damage: calculateNormalDamage, Status: statusPoison, StatusChance: 0.3}},
+	"electric": {{Name: "Thunder Shock", Category: categorySpecial, damage: calculateSpecialDamage, Status: statusParalysis, StatusChance: 0.2}},
+	"ice":      {{Name: "Powder Snow", Category: categorySpecial, damage: calculateSpecialDamage, Status: statusFreeze, StatusChance: 0.1}},
+	"ghost":    {{Name: "Lick", Category: categoryPhysical, damage: calculateNormalDamage, Status: statusParalysis, StatusChance: 0.3}},
+	"grass":    {{Name: "Sleep Powder", Category: categoryStatus, Status: statusSleep, StatusChance: 0.75}},
+	"psychic": {
+		{Name: "Hypnosis", Category: categoryStatus, Status: statusSleep, StatusChance: 0.6},
+		{Name: "Calm Mind", Category: categoryStatus, Stages: []StageChange{{statSpecialAttack, 1, true}, {statSpecialDefense, 1, true}}},
+	},
+	"normal": {
+		{Name: "Growl", Category: categoryStatus, Stages: []StageChange{{statAttack, -1, false}}},
+		{Name: "Double Team", Category: categoryStatus, Stages: []StageChange{{stageEvasion, 1, true}}},
+	},
+	"fighting": {{Name: "Bulk Up", Category: categoryStatus, Stages: []StageChange{{statAttack, 1, true}, {statDefense, 1, true}}}},
+	"water":    {{Name: "Withdraw", Category: categoryStatus, Stages: []StageChange{{statDefense, 1, true}}}},
+	"rock":     {{Name: "Harden", Category: categoryStatus, Stages: []StageChange{{statDefense, 1, true}}}},
+	"steel":    {{Name: "Iron Defense", Category: categoryStatus, Stages: []StageChange{{statDefense, 2, true}}}},
+	"flying":   {{Name: "Agility", Category: categoryStatus, Stages: []StageChange{{statSpeed, 2, true}}}},
+	"bug":      {{Name: "String Shot", Category: categoryStatus, Stages: []StageChange{{statSpeed, -2, false}}}},
+	"ground":   {{Name: "Sand Attack", Category: categoryStatus, Stages: []StageChange{{stageAccuracy, -1, false}}}},
+	"dragon":   {{Name: "Dragon Dance", Category: categoryStatus, Stages: []StageChange{{statAttack, 1, true}, {statSpeed, 1, true}}}},
+	"fairy":    {{Name: "Charm", Category: categoryStatus, Stages: []StageChange{{statAttack, -2, false}}}},
+	"dark":     {{Name: "Nasty Plot", Category: categoryStatus, Stages: []StageChange{{statSpecialAttack, 2, true}}}},
+}
+
+// movesFor returns the moves a Pokémon can use: the basic moves followed by those of its types,
+// which have the type they are listed under.
+func movesFor(p *Pokemon) []Move {
+	moves := basicMoves
+	for _, t := range p.Type {
+		for _, m := range typeMoves[t] {
+			m.Type = t
+			moves = append(moves[:len(moves):len(moves)], m)
+		}
+	}
+	return moves
+}
+
+// targetsOpponent reports whether a move does anything to the opposing Pokémon, so that it can miss.
+func (m Move) targetsOpponent() bool {
+	if m.damage != nil || m.Status != "" {
+		return true
+	}
+	for _, change := range m.Stages {
+		if !change.Self {
+			return true
+		}
+	}
+	return false
+}
+
+// describeEffects summarizes a move's side effects for the move menu, e.g. " (may cause burn)".
+func (m Move) describeEffects() string {
+	var effects []string
+	if m.Status != "" {
+		effects = append(effects, "may cause "+m.Status)
+	}
+	for _, change := range m.Stages {
+		whose := "target's"
+		if change.Self {
+			whose = "own"
+		}
+		effects = append(effects, fmt.Sprintf("%s %s %+d", whose, stageNames[change.Stat], change.Change))
+	}
+	if len(effects) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(effects, ", ") + ")"
+}
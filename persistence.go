@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// currentSchemaVersion is the version of the player data layout written by savePlayer.
+// Files written with an older version are upgraded by playerMigrations when loaded.
+const currentSchemaVersion = 2
+
+// PlayerData is the persisted form of a player, following the layout of player_data.json.
+type PlayerData struct {
+	SchemaVersion   int
+	PlayerID        string
+	Position        Position
+	AutoMode        AutoModeData
+	Team            []SavedPokemon
+	CapturedPokemon []SavedPokemon
+	BattleHistory   []BattleRecord
+	Bag             Bag
+	LastHeal        time.Time
+}
+
+// Position is a player's location in the game world.
+type Position struct {
+	X int
+	Y int
+}
+
+// AutoModeData describes the auto mode state of a player.
+type AutoModeData struct {
+	Status    bool
+	Duration  int // Duration of auto mode in seconds
+	StartTime time.Time
+}
+
+// SavedPokemon is a Pokémon as stored in a player's team or collection.
+type SavedPokemon struct {
+	ID             int `json:",omitempty"` // Collection key, absent in hand-written files
+	Name           string
+	Type           []string
+	HP             int
+	BaseExp        int
+	Attack         int
+	Defense        int
+	Speed          int
+	SpecialAttack  int
+	SpecialDefense int
+	Level          int
+	AccumExp       int
+	EV             float64
+	EVs            []float64 `json:",omitempty"` // Per-stat spread, absent for Pokémon caught before spreads existed
+	Ability        string    `json:",omitempty"` // Absent for Pokémon caught before abilities existed
+	HeldItem       string    `json:",omitempty"`
+	HPLost         int       `json:",omitempty"` // HP lost in battles until healed
+	Status         string    `json:",omitempty"` // Status condition carried between battles, if any
+	Nickname       string    `json:",omitempty"`
+	Favorite       bool      `json:",omitempty"`
+}
+
+// Results of a battle in a player's history.
+const (
+	resultWin  = "win"
+	resultLoss = "loss"
+	resultDraw = "draw" // A limit was reached and neither side won
+)
+
+// BattleRecord is a single entry of a player's battle history.
+type BattleRecord struct {
+	OpponentID       string
+	Result           string // resultWin, resultLoss or resultDraw
+	ExperienceGained int
+	Timestamp        time.Time
+}
+
+// toSavedPokemon converts a Pokémon from a player's collection into its persisted form.
+func toSavedPokemon(id int, p Pokemon) SavedPokemon {
+	return SavedPokemon{
+		ID:             id,
+		Name:           p.Name,
+		Type:           p.Type,
+		HP:             p.HP,
+		BaseExp:        p.BaseExp,
+		Attack:         p.Attack,
+		Defense:        p.Defense,
+		Speed:          p.Speed,
+		SpecialAttack:  p.SpecialAttack,
+		SpecialDefense: p.SpecialDefense,
+		Level:          p.Level,
+		AccumExp:       p.AccumExp,
+		EV:             p.EV,
+		EVs:            p.EVs,
+		Ability:        p.Ability,
+		HeldItem:       p.HeldItem,
+		HPLost:         p.HPLost,
+		Status:         p.Status,
+		Nickname:       p.Nickname,
+		Favorite:       p.Favorite,
+	}
+}
+
+// toPokemon converts a persisted Pokémon back into a Pokemon.
+func (s SavedPokemon) toPokemon() Pokemon {
+	return Pokemon{
+		Name:           s.Name,
+		Type:           s.Type,
+		HP:             s.HP,
+		BaseExp:        s.BaseExp,
+		Attack:         s.Attack,
+		Defense:        s.Defense,
+		Speed:          s.Speed,
+		SpecialAttack:  s.SpecialAttack,
+		SpecialDefense: s.SpecialDefense,
+		Level:          s.Level,
+		AccumExp:       s.AccumExp,
+		EV:             s.EV,
+		EVs:            s.EVs,
+		Ability:        s.Ability,
+		HeldItem:       s.HeldItem,
+		HPLost:         s.HPLost,
+		Status:         s.Status,
+		Nickname:       s.Nickname,
+		Favorite:       s.Favorite,
+	}
+}
+
+// playerKey returns the PlayerID used in the persisted data for a numeric player ID.
+func playerKey(id int) string {
+	return fmt.Sprintf("player%d", id)
+}
+
+// parsePlayerKey is the inverse of playerKey.
+func parsePlayerKey(key string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(key, "player"))
+}
+
+// playerToData builds the persisted form of a player.
+// The caller must hold player.mutex.
+func playerToData(player *Player) *PlayerData {
+	data := &PlayerData{
+		SchemaVersion: currentSchemaVersion,
+		PlayerID:      playerKey(player.ID),
+		Position:      Position{X: player.X, Y: player.Y},
+		AutoMode: AutoModeData{
+			Status:    player.AutoMode,
+			Duration:  autoModeDurationSec,
+			StartTime: player.AutoStart,
+		},
+		BattleHistory: player.BattleHistory,
+		Bag:           player.Bag,
+		LastHeal:      player.LastHeal,
+	}
+
+	// Team members are written to Team, everything else to CapturedPokemon.
+	inTeam := make(map[int]bool)
+	for _, id := range player.Team {
+		if p, ok := player.Pokemons[id]; ok {
+			data.Team = append(data.Team, toSavedPokemon(id, p))
+			inTeam[id] = true
+		}
+	}
+	ids := make([]int, 0, len(player.Pokemons))
+	for id := range player.Pokemons {
+		if !inTeam[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids) // Keep the file stable between saves
+	for _, id := range ids {
+		data.CapturedPokemon = append(data.CapturedPokemon, toSavedPokemon(id, player.Pokemons[id]))
+	}
+	return data
+}
+
+// dataToPlayer rebuilds a player from its persisted form.
+// Team members that are not part of CapturedPokemon are added to the collection.
+func dataToPlayer(data *PlayerData) (*Player, error) {
+	id, err := parsePlayerKey(data.PlayerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PlayerID %q: %v", data.PlayerID, err)
+	}
+	player := &Player{
+		ID:            id,
+		X:             data.Position.X,
+		Y:             data.Position.Y,
+		Pokemons:      make(map[int]Pokemon),
+		AutoMode:      data.AutoMode.Status,
+		AutoStart:     data.AutoMode.StartTime,
+		BattleHistory: data.BattleHistory,
+		Bag:           data.Bag,
+		LastHeal:      data.LastHeal,
+	}
+	if player.Bag == nil {
+		player.Bag = make(Bag)
+	}
+
+	// Entries without an ID get the next free key after the highest one in use.
+	nextID := 1
+	for _, list := range [][]SavedPokemon{data.CapturedPokemon, data.Team} {
+		for _, s := range list {
+			if s.ID >= nextID {
+				nextID = s.ID + 1
+			}
+		}
+	}
+	add := func(s SavedPokemon) int {
+		key := s.ID
+		if key <= 0 || player.hasPokemon(key) {
+			key = nextID
+			nextID++
+		}
+		player.Pokemons[key] = s.toPokemon()
+		return key
+	}
+
+	for _, s := range data.CapturedPokemon {
+		add(s)
+	}
+	for _, s := range data.Team {
+		player.Team = append(player.Team, add(s))
+	}
+	return player, nil
+}
+
+// hasPokemon reports whether the player's collection contains the given key.
+func (player *Player) hasPokemon(id int) bool {
+	_, ok := player.Pokemons[id]
+	return ok
+}
+
+// nextPokemonKey returns an unused key for a new Pokémon in the player's collection.
+// The caller must hold player.mutex.
+func (player *Player) nextPokemonKey() int {
+	key := 1
+	for id := range player.Pokemons {
+		if id >= key {
+			key = id + 1
+		}
+	}
+	return key
+}
+
+// decodePlayerData parses a player file of any known schema version.
+func decodePlayerData(raw []byte) (*PlayerData, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	version := schemaVersion(fields)
+	if version > currentSchemaVersion {
+		return nil, fmt.Errorf("player data has schema version %d, newer than supported %d", version, currentSchemaVersion)
+	}
+	for ; version < currentSchemaVersion; version++ {
+		migrated, err := playerMigrations[version](fields)
+		if err != nil {
+			return nil, fmt.Errorf("migrating player data from version %d: %v", version, err)
+		}
+		fields = migrated
+	}
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var data PlayerData
+	if err := json.Unmarshal(migrated, &data); err != nil {
+		return nil, err
+	}
+	data.SchemaVersion = currentSchemaVersion
+	return &data, nil
+}
+
+// schemaVersion detects the layout of a player file.
+// Files without a SchemaVersion are either the original dump of the Player struct (version 0)
+// or hand-written files following player_data.json (version 1).
+func schemaVersion(fields map[string]json.RawMessage) int {
+	if v, ok := fields["SchemaVersion"]; ok {
+		var version int
+		if json.Unmarshal(v, &version) == nil && version > 0 {
+			return version
+		}
+	}
+	if _, ok := fields["PlayerID"]; ok {
+		return 1
+	}
+	return 0
+}
+
+// playerMigrations upgrades player data by one schema version each;
+// playerMigrations[i] converts version i into version i+1.
+var playerMigrations = []func(map[string]json.RawMessage) (map[string]json.RawMessage, error){
+	migratePlayerV0,
+	migratePlayerV1,
+}
+
+// migratePlayerV0 converts the original Player struct dump ({ID, X, Y, Pokemons})
+// into the player_data.json layout.
+func migratePlayerV0(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var legacy struct {
+		ID       int
+		X, Y     int
+		Pokemons map[int]Pokemon
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return nil, err
+	}
+
+	data := PlayerData{
+		SchemaVersion: 1,
+		PlayerID:      playerKey(legacy.ID),
+		Position:      Position{X: legacy.X, Y: legacy.Y},
+	}
+	ids := make([]int, 0, len(legacy.Pokemons))
+	for id := range legacy.Pokemons {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		data.CapturedPokemon = append(data.CapturedPokemon, toSavedPokemon(id, legacy.Pokemons[id]))
+	}
+
+	raw, err = json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var migrated map[string]json.RawMessage
+	err = json.Unmarshal(raw, &migrated)
+	return migrated, err
+}
+
+// migratePlayerV1 gives players from before bags existed the items new players start with.
+// Players migrated from version 0 have a null bag.
+func migratePlayerV1(fields map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	if bag, ok := fields["Bag"]; !ok || string(bag) == "null" {
+		raw, err := json.Marshal(starterBag)
+		if err != nil {
+			return nil, err
+		}
+		fields["Bag"] = raw
+	}
+	return fields, nil
+}
+
+// savePlayer persists the current state of a player through the configured store.
+// The caller must hold player.mutex.
+func savePlayer(player *Player) error {
+	return store.SavePlayer(playerToData(player))
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and renames it into place,
+// so readers never observe a partially written file. Missing directories are created.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Remove the temporary file if anything below fails.
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
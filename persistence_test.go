@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// playerFixtures are player files as each schema version wrote them.
+var playerFixtures = map[string]string{
+	// The original dump of the Player struct, keyed by collection key.
+	"v0": `{"ID": 3, "X": 5, "Y": 6, "Pokemons": {
+		"7": {"name": "pikachu", "type": ["electric"], "hp": 35, "attack": 55, "level": 10, "accum_exp": 1500, "ev": 1.2},
+		"2": {"name": "rattata", "type": ["normal"], "hp": 30, "attack": 56, "level": 4, "ev": 0.5}
+	}}`,
+	// A hand-written file following player_data.json: no IDs, no bag.
+	"v1": `{"PlayerID": "player4", "Position": {"X": 200, "Y": 450},
+		"Team": [
+			{"Name": "Pikachu", "Type": ["Electric"], "HP": 35, "Level": 10},
+			{"Name": "Bulbasaur", "Type": ["Grass", "Poison"], "HP": 45, "Level": 12}
+		],
+		"CapturedPokemon": [{"Name": "Charmander", "Type": ["Fire"], "HP": 39, "Level": 5}],
+		"BattleHistory": [{"OpponentID": "player5", "Result": "win", "ExperienceGained": 120}]
+	}`,
+	// A file written by a server with bags.
+	"v2": `{"SchemaVersion": 2, "PlayerID": "player4", "Bag": {"great-ball": 1},
+		"Team": [{"ID": 2, "Name": "pikachu", "HP": 35, "Level": 10, "HPLost": 5, "Status": "poison"}],
+		"CapturedPokemon": [{"ID": 1, "Name": "rattata", "HP": 30, "Level": 4, "Nickname": "Rat", "Favorite": true}]
+	}`,
+}
+
+func TestDecodePlayerData(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		id       int
+		x, y     int
+		bag      Bag
+		team     []string       // Names of the team, in order
+		captured map[int]string // Collection keys and names of the rest of the collection
+	}{
+		{"v0", 3, 5, 6, starterBag, nil, map[int]string{2: "rattata", 7: "pikachu"}},
+		{"v1", 4, 200, 450, starterBag, []string{"Pikachu", "Bulbasaur"}, map[int]string{1: "Charmander"}},
+		{"v2", 4, 0, 0, Bag{"great-ball": 1}, []string{"pikachu"}, map[int]string{1: "rattata"}},
+	}
+	for _, tt := range tests {
+		data, err := decodePlayerData([]byte(playerFixtures[tt.fixture]))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+		if data.SchemaVersion != currentSchemaVersion {
+			t.Errorf("%s: schema version %d, want %d", tt.fixture, data.SchemaVersion, currentSchemaVersion)
+		}
+		player, err := dataToPlayer(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+		if player.ID != tt.id || player.X != tt.x || player.Y != tt.y {
+			t.Errorf("%s: player %d at (%d, %d), want %d at (%d, %d)", tt.fixture, player.ID, player.X, player.Y, tt.id, tt.x, tt.y)
+		}
+		if !reflect.DeepEqual(player.Bag, tt.bag) {
+			t.Errorf("%s: bag %v, want %v", tt.fixture, player.Bag, tt.bag)
+		}
+		var team []string
+		inTeam := make(map[int]bool)
+		for _, key := range player.Team {
+			team = append(team, player.Pokemons[key].Name)
+			inTeam[key] = true
+		}
+		if !reflect.DeepEqual(team, tt.team) {
+			t.Errorf("%s: team %v, want %v", tt.fixture, team, tt.team)
+		}
+		captured := make(map[int]string)
+		for key, p := range player.Pokemons {
+			if !inTeam[key] {
+				captured[key] = p.Name
+			}
+		}
+		if !reflect.DeepEqual(captured, tt.captured) {
+			t.Errorf("%s: rest of the collection %v, want %v", tt.fixture, captured, tt.captured)
+		}
+	}
+}
+
+// TestDecodePlayerDataKeepsFields checks the fields each version has beyond the names.
+func TestDecodePlayerDataKeepsFields(t *testing.T) {
+	v0, err := decodePlayerData([]byte(playerFixtures["v0"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := v0.CapturedPokemon[1]; p.ID != 7 || p.Attack != 55 || p.AccumExp != 1500 || p.EV != 1.2 {
+		t.Errorf("v0 pikachu saved as %+v", p)
+	}
+
+	v1, err := decodePlayerData([]byte(playerFixtures["v1"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := v1.BattleHistory; len(h) != 1 || h[0].OpponentID != "player5" || h[0].ExperienceGained != 120 {
+		t.Errorf("v1 battle history %+v", h)
+	}
+
+	v2, err := decodePlayerData([]byte(playerFixtures["v2"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, err := dataToPlayer(v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := player.Pokemons[2]; p.HPLost != 5 || p.Status != statusPoison {
+		t.Errorf("v2 pikachu loaded as %+v", p)
+	}
+	if p := player.Pokemons[1]; p.Nickname != "Rat" || !p.Favorite {
+		t.Errorf("v2 rattata loaded as %+v", p)
+	}
+}
+
+func TestDecodePlayerDataErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"newer version", `{"SchemaVersion": 3, "PlayerID": "player1"}`, "newer than supported"},
+		{"not an object", `[1, 2]`, "cannot unmarshal"},
+		{"v0 with a bad collection", `{"ID": 1, "Pokemons": [1]}`, "migrating player data from version 0"},
+	}
+	for _, tt := range tests {
+		if _, err := decodePlayerData([]byte(tt.raw)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestDataToPlayerReassignsKeys loads entries without an ID and entries whose ID is taken, and
+// checks that they get the free keys after the highest one in use.
+func TestDataToPlayerReassignsKeys(t *testing.T) {
+	saved := func(id int, name string) SavedPokemon { return SavedPokemon{ID: id, Name: name, HP: 10, Level: 1} }
+	data := &PlayerData{
+		SchemaVersion:   currentSchemaVersion,
+		PlayerID:        playerKey(1),
+		CapturedPokemon: []SavedPokemon{saved(5, "rattata"), saved(0, "pidgey"), saved(2, "zubat"), saved(-1, "oddish")},
+		Team:            []SavedPokemon{saved(5, "geodude"), saved(0, "magikarp")},
+	}
+	player, err := dataToPlayer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{2: "zubat", 5: "rattata", 6: "pidgey", 7: "oddish", 8: "geodude", 9: "magikarp"}
+	got := make(map[int]string)
+	for key, p := range player.Pokemons {
+		got[key] = p.Name
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collection %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(player.Team, []int{8, 9}) {
+		t.Errorf("team %v, want [8 9]", player.Team)
+	}
+
+	// Saving and loading again keeps the keys.
+	again, err := dataToPlayer(playerToData(player))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]int, 0, len(again.Pokemons))
+	for key := range again.Pokemons {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	if !reflect.DeepEqual(keys, []int{2, 5, 6, 7, 8, 9}) || !reflect.DeepEqual(again.Team, player.Team) {
+		t.Errorf("keys %v and team %v after saving, want them kept", keys, again.Team)
+	}
+
+	if _, err := dataToPlayer(&PlayerData{PlayerID: "trainer1"}); err == nil {
+		t.Error("loaded a player with an invalid PlayerID")
+	}
+}
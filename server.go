@@ -2,25 +2,33 @@ package main
 
 import (
     "bufio"
+    "context"
     "encoding/json"
+    "flag"
     "fmt"
     "log"
-    "math/rand"
     "net"
     "net/http"
     "os"
-    "strconv"
+    "os/signal"
     "strings"
     "sync"
+    "syscall"
     "time"
-    "io/ioutil"
 )
 // Player represents a player in the game world with their attributes and Pokémon collection.
 type Player struct {
-	ID       int
-	X, Y     int
-	Pokemons map[int]Pokemon // Map of Pokémon owned by the player
-	mutex    sync.Mutex // Mutex for synchronizing access to player data
+	ID            int
+	X, Y          int
+	Pokemons      map[int]Pokemon // Map of Pokémon owned by the player
+	Team          []int           // Keys into Pokemons forming the player's team
+	AutoMode      bool            // Indicates if the player is in auto mode
+	AutoStart     time.Time       // Time at which auto mode was started
+	BattleHistory []BattleRecord  // Results of the player's past battles
+	Bag           Bag             // Items the player carries
+	LastHeal      time.Time       // Last use of the heal command
+	evolutions    map[int]Timer   // Pending evolutions by collection key
+	mutex         sync.Mutex // Mutex for synchronizing access to player data
 }
 
 var (
@@ -32,14 +40,7 @@ var (
 	players     []*Player
 )
 
-const (
-	worldSizeX          = 1000
-	worldSizeY          = 1000
-	autoModeDurationSec = 120
-	pokemonSpawnRate    = 1 * time.Minute
-	pokemonDespawnTime  = 5 * time.Minute
-	maxPokemonPerPlayer = 200
-)
+const autoModeDurationSec = 120
 
 type Pokemon struct {
     Name           string   `json:"name"`
@@ -54,7 +55,19 @@ type Pokemon struct {
     Level          int      `json:"level"`
     AccumExp       int      `json:"accum_exp"`
     EV             float64  `json:"ev"`  
+    EVs            []float64 `json:"evs,omitempty"`            // Per-stat EV spread, indexed by the stat constants
+    EvolvesTo      string   `json:"evolves_to,omitempty"`      // Species this one evolves into
+    EvolutionLevel int      `json:"evolution_level,omitempty"` // Level at which it evolves
+    Abilities      []string `json:"abilities,omitempty"`       // Abilities of the species, hidden ability last
+    Ability        string   `json:"ability,omitempty"`         // Ability of this instance, one of Abilities
+    HeldItem       string   `json:"held_item,omitempty"`       // Key of the item the Pokémon holds in battle
+    HPLost         int      `json:"hp_lost,omitempty"`         // HP lost in battles until healed; the current HP is HP - HPLost
+    Status         string   `json:"status,omitempty"`          // Status condition until healed, e.g. "burn"
+    Nickname       string   `json:"nickname,omitempty"`        // Name given by the owner, if any
+    Favorite       bool     `json:"favorite,omitempty"`        // Marked by the owner, who can't release it
     Owner          *Client  
+    collectionKey  int      // Key in the owner's collection, 0 if the Pokémon is not owned
+    wildID         uint64   // Entity ID while the Pokémon is wild in the world, 0 otherwise
 }
 
 
@@ -66,10 +79,17 @@ type Pokeworld struct {
     PokemonSpawnRate   time.Duration    // Rate at which Pokémon spawn
     PokemonDespawnTime time.Duration    // Time after which Pokémon despawn
     PokemonPerSpawn    int              // Number of Pokémon to spawn at once
-    rng                *rand.Rand       // Random number generator for spawning Pokémon
+    MaxPokemon         int              // Number of Pokémon in the world above which none spawn
+    rng                RNG              // Random number generator for spawning Pokémon
+    clock              Clock            // Clock of spawns, despawns and other timers
+    biomes             biomeMap         // Biome of every tile
+    spawnTables        map[string]*spawnTable // Wild Pokémon of each biome
     NextSpawn          time.Time        // Next time Pokémon will spawn
-    despawnTimers      map[*Pokemon]*time.Timer // Timers for despawning Pokémon
+    despawnTimers      map[uint64]wildTimer // Timers for despawning Pokémon, by entity ID
+    nextWildID         uint64           // Entity ID of the last wild Pokémon spawned
     TotalPokemon       int              // Total number of Pokémon currently in the world
+    metrics            spawnMetrics     // Counters of spawns and of Pokémon leaving the world
+    events             *eventBus        // World events for the connected clients
     sync.Mutex                          // Mutex for synchronizing access to Pokeworld data
 }
 
@@ -82,6 +102,14 @@ type Client struct {
     X, Y          int            // Coordinates of the client in the game world
     AutoMode      bool           // Indicates if the client is in auto mode
     AutoUntil     time.Time      // Time until which auto mode is active
+    account       *Account       // Account the client is logged in with
+    player        *Player        // Player profile of the logged in account
+    roster        []*Pokemon     // Every Pokémon the client brought into the current battle
+    expGained     map[int]int    // Experience earned in the current battle by collection key
+    encounter     *Encounter     // Wild Pokémon the client is facing in the world, if any
+    events        *subscriber    // Notifications of world events around the player
+    jsonEvents    bool           // Battle events are sent as JSON lines instead of text
+    ctx           context.Context // Cancelled when the server shuts down
     sync.Mutex                   // Mutex for synchronizing access to client data
 }
 
@@ -190,6 +218,13 @@ func fetchPokemonData(id int) (*Pokemon, error) {
         types = append(types, typeName)
     }
 
+    // Extract the abilities in slot order, which puts the hidden ability last.
+    abilities := []string{}
+    for _, a := range result["abilities"].([]interface{}) {
+        abilityName := a.(map[string]interface{})["ability"].(map[string]interface{})["name"].(string)
+        abilities = append(abilities, abilityName)
+    }
+
     // Extract and process base stats from the JSON response.
     stats := make(map[string]int)
     for _, s := range result["stats"].([]interface{}) {
@@ -212,11 +247,82 @@ func fetchPokemonData(id int) (*Pokemon, error) {
         Level:          1,
         AccumExp:       0,
         EV:             0.5, 
+        Abilities:      abilities,
+    }
+
+    // Look up the species' evolution chain to find what it evolves into.
+    speciesURL := result["species"].(map[string]interface{})["url"].(string)
+    pokemon.EvolvesTo, pokemon.EvolutionLevel, err = fetchEvolution(speciesURL, pokemon.Name)
+    if err != nil {
+        return nil, err
     }
 
     return pokemon, nil
 }
 
+// fetchJSON fetches a PokeAPI resource and decodes it into a generic map.
+func fetchJSON(url string) (map[string]interface{}, error) {
+    resp, err := http.Get(url)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    var result map[string]interface{}
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// evolutionChains caches the evolution chains fetched by fetchEvolution, keyed by URL,
+// since every species of a family shares the same chain.
+var evolutionChains = make(map[string]map[string]interface{})
+
+// fetchEvolution returns the species that the named Pokémon evolves into by leveling up,
+// and the level at which it does. Evolutions triggered by stones, trades or friendship are ignored.
+func fetchEvolution(speciesURL, name string) (string, int, error) {
+    speciesData, err := fetchJSON(speciesURL)
+    if err != nil {
+        return "", 0, err
+    }
+    chainURL := speciesData["evolution_chain"].(map[string]interface{})["url"].(string)
+
+    chain, ok := evolutionChains[chainURL]
+    if !ok {
+        chain, err = fetchJSON(chainURL)
+        if err != nil {
+            return "", 0, err
+        }
+        evolutionChains[chainURL] = chain
+    }
+
+    // Walk the chain until we find the node of this species.
+    nodes := []interface{}{chain["chain"]}
+    for len(nodes) > 0 {
+        node := nodes[0].(map[string]interface{})
+        nodes = nodes[1:]
+        next := node["evolves_to"].([]interface{})
+        if node["species"].(map[string]interface{})["name"].(string) != name {
+            nodes = append(nodes, next...)
+            continue
+        }
+
+        for _, n := range next {
+            target := n.(map[string]interface{})
+            for _, d := range target["evolution_details"].([]interface{}) {
+                details := d.(map[string]interface{})
+                trigger := details["trigger"].(map[string]interface{})["name"].(string)
+                if minLevel, ok := details["min_level"].(float64); ok && trigger == "level-up" {
+                    return target["species"].(map[string]interface{})["name"].(string), int(minLevel), nil
+                }
+            }
+        }
+        break
+    }
+    return "", 0, nil
+}
+
 func FetchAllPokemonData() {
     var pokemons []Pokemon // Create a slice to store all Pokémon data.
 
@@ -249,10 +355,29 @@ func FetchAllPokemonData() {
 }
 
 //Pokecat
-func (pw *Pokeworld) spawnPokemonLoop() {
+// spawnPokemonLoop spawns a wave of wild Pokémon every PokemonSpawnRate until ctx is cancelled.
+func (pw *Pokeworld) spawnPokemonLoop(ctx context.Context) {
     for {
-        time.Sleep(pokemonSpawnRate) // Spawn every minute
-        pw.spawnPokemon(50) // Spawn 50 Pokémon
+        // The settings are read on every wave, as they may be reloaded. A next spawn restored
+        // from the saved world state is kept.
+        pw.Lock()
+        now := pw.clock.Now()
+        if !pw.NextSpawn.After(now) {
+            pw.NextSpawn = now.Add(pw.PokemonSpawnRate)
+        }
+        wait := pw.NextSpawn.Sub(now)
+        pw.Unlock()
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-pw.clock.After(wait):
+        }
+
+        pw.Lock()
+        count := pw.PokemonPerSpawn
+        pw.Unlock()
+        pw.spawnPokemon(count)
     }
 }
 
@@ -262,35 +387,30 @@ func (pw *Pokeworld) spawnPokemon(numPokemon int) {
     pw.Lock()
     defer pw.Unlock()
 
-    // Loop to spawn the specified number of Pokemon (up to the world limit of 50).
-    for i := 0; i < numPokemon && pw.TotalPokemon < 50; i++ {
+    // Loop to spawn the specified number of Pokemon (up to the world limit).
+    for i := 0; i < numPokemon && pw.TotalPokemon < pw.MaxPokemon; i++ {
         // Generate random coordinates within the world.
-        x, y := rand.Intn(worldSizeX), rand.Intn(worldSizeY)
+        x, y := pw.rng.Intn(pw.Width), pw.rng.Intn(pw.Height)
         var pokemon *Pokemon
 
         // Check if the grid cell is empty.
-        if pw.grid[x][y] == nil {
-            // Randomly select a Pokemon from the pokedex. 
-            pokemonIndex := rand.Intn(len(pw.pokedex)) // Assign the selected Pokémon
-            pokemon = &pw.pokedex[pokemonIndex] 
-            // Set random level and EV for the Pokemon.
-            pokemon.Level = rand.Intn(100) + 1
-            pokemon.EV = rand.Float64()*0.5 + 0.5
-            pw.grid[x][y] = pokemon
-
-            //start a timer for despawning this Pokémon
-            despawnTimer := time.AfterFunc(pw.PokemonDespawnTime, func() {
-                pw.Lock()
-                defer pw.Unlock()
-
-                // Ensure the Pokémon still exists before despawning
-                if pw.grid[x][y] == pokemon {
-                    pw.grid[x][y] = nil
-                    delete(pw.despawnTimers, pokemon)
-                    pw.TotalPokemon--
-                }
-            })
-            pw.despawnTimers[pokemon] = despawnTimer // Store the timer
+        if pw.grid[x][y] == nil && !isPokemonCenter(x, y) {
+            // Pick the species and level from the spawn table of the tile's biome,
+            // or any species at any level if the biome has no table.
+            species, level, ok := pw.spawnTables[pw.biomes.biomeAt(x, y)].pick(pw.rng)
+            if !ok {
+                species, level = &pw.pokedex[pw.rng.Intn(len(pw.pokedex))], pw.rng.Intn(100)+1
+            }
+            pokemon = new(Pokemon)
+            *pokemon = *species // Each spawn is its own instance of the species
+            // Set the level and a random per-stat EV spread for the Pokemon.
+            pokemon.Level = level
+            setSpread(pokemon, generateRandomEVs(pw.rng))
+            recomputeStats(pokemon)
+            if len(pokemon.Abilities) > 0 {
+                pokemon.Ability = pokemon.Abilities[pw.rng.Intn(len(pokemon.Abilities))]
+            }
+            pw.addWild(x, y, pokemon) // Places it and starts its despawn timer
         }
     }
 }
@@ -299,106 +419,138 @@ func (pw *Pokeworld) spawnPokemon(numPokemon int) {
 // adds them to the global player list, and places them in the game world.
 // It returns a pointer to the newly created player.
 func addPlayer(x, y int) *Player {
-    // Ensure exclusive access to the player list while modifying it.
 	playerMutex.Lock()
-	defer playerMutex.Unlock() // Unlock when the function exits.
-
 	playerID++
-    // Create a new Player struct with the assigned ID, position, and empty Pokemons map.
-	player := &Player{
-		ID:       playerID,
-		X:        x,
-		Y:        y,
-		Pokemons: make(map[int]Pokemon),
+	id := playerID
+	playerMutex.Unlock()
+
+	return loginPlayer(id, x, y)
+}
+
+// loginPlayer loads the saved data of the player with the given ID and places them in the game world.
+// Players without saved data start with an empty collection at (x, y).
+func loginPlayer(id, x, y int) *Player {
+	var player *Player
+	data, err := store.LoadPlayer(id)
+	if err == nil {
+		player, err = dataToPlayer(data)
+	}
+	if err != nil {
+		if err != errNoPlayerData {
+			log.Printf("Error loading data for player %d: %v", id, err)
+		}
+		// Create a new Player struct with the assigned ID, position, and empty Pokemons map.
+		player = &Player{
+			ID:       id,
+			X:        x,
+			Y:        y,
+			Pokemons: make(map[int]Pokemon),
+			Bag:      starterBag.clone(),
+		}
+	}
+
+	// Auto mode runs out while the player is away too.
+	if player.AutoMode && !player.autoModeActive(pokeworld.clock.Now()) {
+		player.AutoMode = false
 	}
 
+	// Ensure exclusive access to the player list while modifying it.
+	playerMutex.Lock()
+	defer playerMutex.Unlock() // Unlock when the function exits.
+
 	players = append(players, player) // Add the player to the global slice of players.
 	worldMutex.Lock() // Ensure exclusive access to the game world while placing the player.
-	world[x][y] = player //Update the game world grid to place the player at the specified location.
+	world[player.X][player.Y] = player //Update the game world grid to place the player at their location.
 	worldMutex.Unlock()
 
 	return player
 }
 
-func handlePlayerMovement(player *Player, direction string) {
+// autoModeActive reports whether the player's auto mode is on and has not run out at now.
+func (player *Player) autoModeActive(now time.Time) bool {
+	return player.AutoMode && now.Before(player.AutoStart.Add(autoModeDurationSec*time.Second))
+}
+
+// logoutPlayer saves the player's data and removes them from the game world.
+func logoutPlayer(player *Player) {
+	player.mutex.Lock()
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	x, y := player.X, player.Y
+	player.mutex.Unlock()
+
+	playerMutex.Lock()
+	defer playerMutex.Unlock()
+
+	for i, p := range players {
+		if p == player {
+			players = append(players[:i], players[i+1:]...)
+			break
+		}
+	}
+	worldMutex.Lock()
+	if world[x][y] == player {
+		world[x][y] = nil
+	}
+	worldMutex.Unlock()
+}
+
+// handlePlayerMovement moves a player one tile in the given direction.
+// It reports whether the player moved; the move fails for unknown directions
+// and when the target tile is occupied by another player.
+func handlePlayerMovement(player *Player, direction string) bool {
 	player.mutex.Lock()
 	defer player.mutex.Unlock()
 
-	worldSize := len(world) // Get world dimensions
+	width, height := len(world), len(world[0]) // Get world dimensions
 
 	// Store original position (for potential reset due to out-of-bounds)
 	originalX, originalY := player.X, player.Y
 
 	switch direction {
 	case "up":
-		player.Y = (player.Y - 1 + worldSize) % worldSize // Wrap around if at top edge
+		player.Y = (player.Y - 1 + height) % height // Wrap around if at top edge
 	case "down":
-		player.Y = (player.Y + 1) % worldSize // Wrap around if at bottom edge
+		player.Y = (player.Y + 1) % height // Wrap around if at bottom edge
 	case "left":
-		player.X = (player.X - 1 + worldSize) % worldSize // Wrap around if at left edge
+		player.X = (player.X - 1 + width) % width // Wrap around if at left edge
 	case "right":
-		player.X = (player.X + 1) % worldSize // Wrap around if at right edge
+		player.X = (player.X + 1) % width // Wrap around if at right edge
 	default:
-		return // Invalid direction, do nothing
+		return false // Invalid direction, do nothing
 	}
 
 	// Check if new position is already occupied by another player
 	worldMutex.Lock()
-	if world[player.X][player.Y] != nil && len(world[player.X][player.Y].Pokemons) == 0 {
+	if world[player.X][player.Y] != nil {
 		// Reset to original position if occupied
 		player.X, player.Y = originalX, originalY
 		worldMutex.Unlock()
-		return
+		return false
 	}
 
 	// Update world
 	world[originalX][originalY] = nil
 	world[player.X][player.Y] = player
 	worldMutex.Unlock()
-
-	// Check for Pokemon encounter
-	if pokemon := world[player.X][player.Y]; pokemon != nil && len(pokemon.Pokemons) > 0 {
-		handleEncounter(player, pokemon)
-	}
+	return true
 }
 
-func movePlayer(player *Player, direction string) {
-	handlePlayerMovement(player, direction)
-}
-
-func handleEncounter(player *Player, pokemon *Player) {
-	player.mutex.Lock()
-	defer player.mutex.Unlock()
-
-	worldMutex.Lock()
-	defer worldMutex.Unlock()
-
-	if len(player.Pokemons) < 200 {
-		// Auto-capture
-		for pokemonId, pokemonData := range pokemon.Pokemons {
-			player.Pokemons[pokemonId] = pokemonData
-		}
-		world[player.X][player.Y] = nil // Remove from world
-
-		// Save player data (update their file)
-		saveData(player, fmt.Sprintf("player_data/player%d_data.json", player.ID))
-	}
+func movePlayer(player *Player, direction string) bool {
+	return handlePlayerMovement(player, direction)
 }
 
-func generateRandomEVs() []float64 {
+// generateRandomEVs returns a random EV spread, one multiplier per stat
+// in the order HP, Attack, Defense, Sp. Atk, Sp. Def, Speed.
+func generateRandomEVs(rng RNG) []float64 {
 	EVs := make([]float64, 6)
 	for i := range EVs {
-		EVs[i] = rand.Float64()*(1-0.5) + 0.5
+		EVs[i] = rng.Float64()*(1-0.5) + 0.5
 	}
 	return EVs
 }
 
-// Save player data
-func saveData(player *Player, filename string) {
-	data, _ := json.MarshalIndent(player, "", "  ")
-	_ = ioutil.WriteFile(filename, data, 0644)
-}
-
 // Additional helper functions
 func getAllPlayers() []*Player {
 	worldMutex.Lock()
@@ -406,9 +558,9 @@ func getAllPlayers() []*Player {
 	return players
 }
 
-func randomDirection() string {
+func randomDirection(rng RNG) string {
 	directions := []string{"up", "down", "left", "right"}
-	return directions[rand.Intn(len(directions))]
+	return directions[rng.Intn(len(directions))]
 }
 
 //Pokebat
@@ -424,15 +576,25 @@ func calculateNormalDamage(attacker *Pokemon, defender *Pokemon) int {
     return damage
 }
 
+// calculateTypeEffectiveness returns the damage multiplier of an attacking type against a defending type.
+// The Pokédex stores types in lower case ("fire") while typeChart uses capitalized names ("Fire").
 func calculateTypeEffectiveness(attackerType, defenderType string) float64 {
-    if defenderTypes, ok := typeChart[attackerType]; ok {
-        if multiplier, exists := defenderTypes[defenderType]; exists {
+    if defenderTypes, ok := typeChart[typeName(attackerType)]; ok {
+        if multiplier, exists := defenderTypes[typeName(defenderType)]; exists {
             return multiplier
         }
     }
     return 1.0 // Default effectiveness (no effect)
 }
 
+// typeName returns a type as it is written in typeChart.
+func typeName(t string) string {
+    if t == "" {
+        return t
+    }
+    return strings.ToUpper(t[:1]) + strings.ToLower(t[1:])
+}
+
 func calculateSpecialDamage(attacker, defender *Pokemon) int {
     maxMultiplier := 1.0
     for _, attackerType := range attacker.Type {
@@ -450,212 +612,140 @@ func calculateSpecialDamage(attacker, defender *Pokemon) int {
     return damage
 }
 
-// handleConnection manages a single client connection in the Pokémon battle.
-func handleConnection(conn net.Conn, pokedex []Pokemon, clients map[net.Conn]*Client, done chan struct{}) {
-    defer conn.Close()
-
-    // Create a new client struct for the connection and add to the clients map.
-    client := &Client{conn: conn, isActive: false, reader: bufio.NewReader(conn)}
-    clients[conn] = client
-
-    fmt.Fprintln(conn, "Welcome to the Pokémon battle!")
-    fmt.Fprintln(conn, "Choose 3 Pokémon for your team:")
-    for i, p := range pokedex {
-        fmt.Fprintf(conn, "%d. %s (%v)\n", i+1, p.Name, p.Type)
+// handleConnection prepares a client for a Pokémon battle: the client chooses a team and
+// the battle room is notified once they are ready, or gave up. The room then runs the battle.
+func handleConnection(client *Client, pokedex []Pokemon, format string, done chan struct{}) {
+    fmt.Fprintln(client.conn, "Welcome to the Pokémon battle!")
+    if err := chooseTeam(client, format, pokedex); err != nil {
+        fmt.Println("Error reading from client:", err)
     }
-    // Loop to get the player's team choices.
-    for i := 0; i < 3; i++ {
-        fmt.Fprint(conn, "Enter number for Pokémon: ")
-        input, err := client.reader.ReadString('\n') // Read the player's choice.
-        if err != nil {
-            fmt.Println("Error reading from client:", err)
-            return
-        }
-        input = strings.TrimSpace(input)
 
-        // Convert input to integer and validate the choice.
-        choice, err := strconv.Atoi(input)
-        if err != nil || choice < 1 || choice > len(pokedex) {
-            fmt.Fprintln(conn, "Invalid choice. Try again.")
-            i--
-            continue
-        }
-
-        // Add the chosen Pokémon to the client's team.
-        client.team = append(client.team, &pokedex[choice-1])
-    }
-
-    // Notify the main server that a client is ready (using the 'done' channel).
+    // Notify the battle room that the client is ready (using the 'done' channel).
     done <- struct{}{}
-
-    for {
-        // Check if it's this client's turn and if they have Pokémon remaining.
-        if client.isActive && len(client.team) > 0 {
-            reader := bufio.NewReader(conn)
-            activePokemon := client.team[0]
-
-            fmt.Fprintf(conn, "Available attacks for %s:\n", activePokemon.Name)
-            fmt.Fprintln(conn, "1. Normal Attack")
-            fmt.Fprintln(conn, "2. Special Attack")
-
-            fmt.Fprintln(conn, "Enter attack number:")
-            attackInput, _ := reader.ReadString('\n')
-            attackInput = strings.TrimSpace(attackInput)
-            attackChoice, err := strconv.Atoi(attackInput)
-            if err != nil || attackChoice < 1 || attackChoice > 2 {
-                fmt.Fprintln(conn, "Invalid attack choice. Try again.")
-                continue
-            }
-
-            attacker := client.team[0]
-
-            // Find opponent client
-            var opponentClient *Client
-            for otherConn, otherClient := range clients {
-                if otherConn != conn {
-                    opponentClient = otherClient
-                    break
-                }
-            }
-
-            if opponentClient == nil || len(opponentClient.team) == 0 {
-                fmt.Fprintln(conn, "Opponent has no Pokémon left!")
-                continue
-            }
-
-            // Select the opponent's active Pokémon as defender
-            defender := opponentClient.team[0]
-
-            var damage int
-            switch attackChoice {
-            case 1:
-                damage = calculateNormalDamage(attacker, defender)
-            case 2:
-                damage = calculateSpecialDamage(attacker, defender)
-            }
-
-            fmt.Fprintf(conn, "%s attacks %s for %d damage!\n", attacker.Name, defender.Name, damage)
-            defender.HP -= damage
-
-            // Fainting and Winner Determination
-            if defender.HP <= 0 {
-                fmt.Fprintf(conn, "%s fainted!\n", defender.Name)
-                fmt.Fprintf(opponentClient.conn, "%s fainted!\n", defender.Name)
-
-                // Remove fainted Pokémon from the opponent's team
-                opponentClient.team = append(opponentClient.team[:0], opponentClient.team[1:]...)
-
-                if len(opponentClient.team) == 0 {
-                    // Announce the winner
-                    fmt.Fprintln(conn, "All opponent's Pokémon have fainted. You win!")
-                    fmt.Fprintln(opponentClient.conn, "All your Pokémon have fainted. You lose!")
-                    break // Exit the loop
-                }
-            }
-
-            // Switch Turns
-            client.isActive = false
-            opponentClient.isActive = true
-            fmt.Fprintln(opponentClient.conn, "Your turn!")
-        } else {
-            // Wait for your turn
-            fmt.Fprintln(conn, "Waiting for your turn...")
-            time.Sleep(4 * time.Second) // Adjust wait time as needed
-        }
-    }
-
-    for otherConn, otherClient := range clients {
-        if otherConn != conn && len(otherClient.team) > 0 {
-            fmt.Fprintln(otherConn, fmt.Sprintf("%s wins!", client.conn.RemoteAddr().String()))
-        }
-    }
 }
 
-func main() {
-    rand.Seed(time.Now().UnixNano())
-    FetchAllPokemonData()
-
-    // Open the saved Pokedex data from the JSON file.
-    pokedexFile, err := os.Open("pokedex.json")
+// loadPokedex reads the saved Pokedex data from a JSON file.
+func loadPokedex(filename string) ([]Pokemon, error) {
+    pokedexFile, err := os.Open(filename)
     if err != nil {
-        log.Fatalf("Error opening pokedex.json: %v", err)
+        return nil, fmt.Errorf("error opening %s: %v", filename, err)
     }
     defer pokedexFile.Close()
 
     // Decode the JSON data into a slice of Pokemon structs.
     var pokedex []Pokemon
     if err := json.NewDecoder(pokedexFile).Decode(&pokedex); err != nil {
-        log.Fatalf("Error decoding pokedex.json: %v", err)
+        return nil, fmt.Errorf("error decoding %s: %v", filename, err)
     }
+    return pokedex, nil
+}
 
-    listener, err := net.Listen("tcp", ":8080")
-    if err != nil {
-        log.Fatalf("Error listening: %v", err)
+func main() {
+    configSource := addConfigFlags()
+    migrateTo := flag.String("migrate-to", "", "copy all data from the store into this store and exit")
+    fetchPokedex := flag.Bool("fetch-pokedex", false, "rebuild pokedex.json from the PokeAPI before starting")
+    simulation := addSimulationFlags()
+    flag.Parse()
+
+    // The simulator only needs the saved Pokedex: no store, network or world.
+    if *simulation.battles > 0 {
+        pokedex, err := loadPokedex("pokedex.json")
+        if err != nil {
+            log.Fatal(err)
+        }
+        indexPokedex(pokedex)
+        if err := runSimulation(simulation, pokedex); err != nil {
+            log.Fatalf("Error running simulation: %v", err)
+        }
+        return
     }
-    defer listener.Close()
 
-    fmt.Println("Server listening on port 8080...")
+    cfg, err := configSource.load()
+    if err != nil {
+        log.Fatalf("Error in config: %v", err)
+    }
+    setConfig(cfg)
 
-    var player1Conn, player2Conn net.Conn
-    clients := make(map[net.Conn]*Client)
+    store, err = openStore(cfg.Store)
+    if err != nil {
+        log.Fatalf("Error opening store: %v", err)
+    }
+    defer store.Close()
 
-    // Wait for two players to connect.
-    for {
-        conn, err := listener.Accept()
+    if *migrateTo != "" {
+        target, err := openStore(*migrateTo)
         if err != nil {
-            log.Printf("Error accepting connection: %v", err)
-            continue
+            log.Fatalf("Error opening store: %v", err)
         }
-
-        //go pokeworld.handlePlayer(conn)
-
-        //Assign connection to player1 or player2
-        if player1Conn == nil {
-            fmt.Println("Player 1 connected!")
-            player1Conn = conn
-        } else if player2Conn == nil {
-            fmt.Println("Player 2 connected!")
-            player2Conn = conn
-            break
+        if err := migrateStore(store, target); err != nil {
+            log.Fatalf("Error migrating %s to %s: %v", cfg.Store, *migrateTo, err)
+        }
+        if err := target.Close(); err != nil {
+            log.Fatalf("Error closing %s: %v", *migrateTo, err)
         }
+        fmt.Printf("Migrated %s to %s\n", cfg.Store, *migrateTo)
+        return
     }
 
-    //Channel to signal when both players are ready to start.
-    done := make(chan struct{}, 2)
-
-    // Start handling connections for both players concurrently.
-    go handleConnection(player1Conn, pokedex, clients, done)
-    go handleConnection(player2Conn, pokedex, clients, done)
+    // Build the Pokedex from the PokeAPI when it is missing or a refresh is requested.
+    if _, err := os.Stat("pokedex.json"); *fetchPokedex || os.IsNotExist(err) {
+        FetchAllPokemonData()
+    }
 
-    <-done // Wait for both players to be ready before starting the battle.
-    <-done
+    pokedex, err := loadPokedex("pokedex.json")
+    if err != nil {
+        log.Fatal(err)
+    }
+    indexPokedex(pokedex)
 
-    fmt.Println("Both players are ready! Let the battle begin!")
+    // Create the game world that logged in players are placed in.
+    world = make([][]*Player, cfg.World.Width)
+    for x := range world {
+        world[x] = make([]*Player, cfg.World.Height)
+    }
 
-    // Determine which player has the Pokémon with higher speed
-    player1Speed := clients[player1Conn].team[0].Speed
-    player2Speed := clients[player2Conn].team[0].Speed
+    // Populate the world with wild Pokémon and keep spawning new ones.
+    if cfg.World.Seed == 0 {
+        cfg.World.Seed = time.Now().UnixNano()
+    }
+    pokeworld = newPokeworld(pokedex, cfg.World, realClock{})
+    if cfg.World.Map != "" {
+        biomes, err := loadBiomeMap(cfg.World.Map, cfg.World.Width, cfg.World.Height)
+        if err != nil {
+            log.Fatalf("Error loading world map: %v", err)
+        }
+        pokeworld.biomes = biomes
+    }
+    if cfg.World.SpawnTables != "" {
+        tables, err := loadSpawnTables(cfg.World.SpawnTables)
+        if err != nil {
+            log.Fatalf("Error loading spawn tables: %v", err)
+        }
+        pokeworld.spawnTables = buildSpawnTables(tables, pokedex)
+    }
 
-    if player1Speed > player2Speed {
-        clients[player1Conn].isActive = true
-        fmt.Fprintln(player1Conn, "Your Pokémon is faster, you go first!")
-        fmt.Fprintln(player2Conn, "Opponent's Pokémon is faster. They go first!")
-    } else if player2Speed > player1Speed {
-        clients[player2Conn].isActive = true
-        fmt.Fprintln(player1Conn, "Your Pokémon is faster, you go first!")
-        fmt.Fprintln(player2Conn, "Opponent's Pokémon is faster. They go first!")
+    // Bring back the wild Pokémon saved at the last shutdown, or spawn the first wave.
+    state, err := store.LoadWorld()
+    if err != nil {
+        log.Fatalf("Error loading world state: %v", err)
+    }
+    if state != nil {
+        pokeworld.restore(state)
     } else {
-        // If speeds are equal, randomly choose which player goes first
-        if rand.Intn(2) == 0 {
-            clients[player1Conn].isActive = true
-            fmt.Fprintln(player1Conn, "Your Pokémon is faster, you go first!")
-            fmt.Fprintln(player2Conn, "Opponent's Pokémon is faster. They go first!")
-        } else {
-            clients[player2Conn].isActive = true
-            fmt.Fprintln(player1Conn, "Your Pokémon is faster, you go first!")
-            fmt.Fprintln(player2Conn, "Opponent's Pokémon is faster. They go first!")
-        }
+        pokeworld.spawnPokemon(pokeworld.PokemonPerSpawn)
+    }
+
+    listener, err := net.Listen("tcp", cfg.Listen)
+    if err != nil {
+        log.Fatalf("Error listening: %v", err)
     }
+    defer listener.Close()
+
+    fmt.Printf("Server listening on %s...\n", cfg.Listen)
 
-    select {} // keep server running
+    // Run until SIGINT or SIGTERM, then shut down gracefully.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    serve(ctx, listener, pokedex, configSource)
+    fmt.Println("Server stopped.")
 }
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLoginAttempts is the number of failed logins after which a connection is closed.
+const maxLoginAttempts = 3
+
+// command is a lobby command available to logged in clients.
+type command struct {
+	name  string
+	usage string
+	help  string
+	run   func(client *Client, args []string, pokedex []Pokemon)
+}
+
+// commands lists the lobby commands in the order shown by "help".
+var commands []command
+
+func init() {
+	commands = []command{
+		{"battle", "battle [ai <level>] [draft]", "Battle another player or a random, greedy or planner AI, with your Pokémon or any Pokémon in a draft", cmdBattle},
+		{"team", "team [set <number>...]", "Show your Pokémon and default team, or choose a new default team", cmdTeam},
+		{"move", "move <up|down|left|right>", "Walk one tile through the world", cmdMove},
+		{"where", "where", "Show your position in the world", cmdWhere},
+		{"fight", "fight", "Battle the wild Pokémon in front of you with your lead Pokémon", cmdFight},
+		{"catch", "catch [ball]", "Throw a Poké Ball at the wild Pokémon in front of you", cmdCatch},
+		{"flee", "flee", "Run away from a wild Pokémon", cmdFlee},
+		{"heal", "heal", "Restore your Pokémon's HP and cure their status; away from a Pokémon Center once per cooldown", cmdHeal},
+		{"bag", "bag", "Show the items in your bag", cmdBag},
+		{"use", "use <item> [number]", "Use an item, or give a held item to one of your Pokémon", cmdUse},
+		{"take", "take <number>", "Put the item a Pokémon is holding back in your bag", cmdTake},
+		{"trade", "trade [with|offer|confirm|cancel]", "Trade Pokémon with a player who is online: 'trade with <player> <number>...' proposes, 'trade offer <number>...' answers, and the trade completes once both confirm", cmdTrade},
+		{"list", "list [<filter>...] [sort <field>]", "Page through your Pokémon, filtered by species, type, level or stats and sorted by any of them; 'list help' for details", cmdList},
+		{"inspect", "inspect <number>", "Show the stats, EV spread and experience of one of your Pokémon", cmdInspect},
+		{"nickname", "nickname <number> [name]", "Give one of your Pokémon a nickname, or remove it", cmdNickname},
+		{"favorite", "favorite <number>", "Mark one of your Pokémon as a favorite, which can't be released, or unmark it", cmdFavorite},
+		{"release", "release <number>...", "Let Pokémon go back to the wild next to you, making room in your collection", cmdRelease},
+		{"cancel", "cancel [number]", "Stop a Pokémon from evolving", cmdCancel},
+		{"events", "events [text|json]", "Choose whether battle events are sent as text or as JSON lines", cmdEvents},
+		{"history", "history", "Show your battle history", cmdHistory},
+		{"help", "help", "Show this list of commands", cmdHelp},
+		{"quit", "quit", "Log out and disconnect", nil}, // Handled by handleSession
+	}
+}
+
+// handleSession runs a client connection: login or registration first, then lobby commands.
+// When ctx is cancelled the client is told that the server is shutting down and can't send
+// anything more, so the session ends, battles included, and the player is saved.
+func handleSession(ctx context.Context, conn net.Conn, pokedex []Pokemon) {
+	defer conn.Close()
+
+	client := &Client{conn: conn, reader: bufio.NewReader(conn), ctx: ctx}
+	stop := context.AfterFunc(ctx, func() {
+		fmt.Fprintln(conn, shutdownMessage)
+		stopReading(conn)
+	})
+	defer stop()
+
+	account, err := loginPrompt(client)
+	if err != nil {
+		log.Printf("Login from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer endSession(account)
+
+	// Returning players continue where they left off; new players start at a random position.
+	x, y := pokeworld.randomPosition()
+	player := loginPlayer(account.PlayerID, x, y)
+	defer logoutPlayer(player)
+
+	client.account = account
+	client.player = player
+	defer endTrade(client, account.Username+" logged out")
+
+	// Tell the client what happens around them, and the players around about them.
+	player.mutex.Lock()
+	x, y = player.X, player.Y
+	player.mutex.Unlock()
+	client.events = pokeworld.events.subscribe(player, x, y)
+	delivered := make(chan struct{})
+	go func() {
+		pokeworld.events.deliverEvents(conn, client.events)
+		close(delivered)
+	}()
+	pokeworld.events.publish(WorldEvent{Kind: eventJoin, X: x, Y: y, Player: account.Username, player: player})
+	defer func() {
+		player.mutex.Lock()
+		x, y := player.X, player.Y
+		player.mutex.Unlock()
+		pokeworld.events.publish(WorldEvent{Kind: eventLeave, X: x, Y: y, Player: account.Username, player: player})
+		pokeworld.events.unsubscribe(client.events)
+		<-delivered
+	}()
+
+	// Evolutions that were not cancelled complete before the player is saved.
+	defer func() {
+		player.mutex.Lock()
+		finishEvolutions(client)
+		player.mutex.Unlock()
+	}()
+
+	fmt.Fprintf(conn, "Welcome, %s!\n", account.Username)
+	cmdHelp(client, nil, pokedex)
+
+	for {
+		fmt.Fprint(conn, "> ")
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		if name == "quit" || name == "logout" {
+			fmt.Fprintln(conn, "Goodbye!")
+			return
+		}
+		found := false
+		for _, cmd := range commands {
+			if cmd.name == name && cmd.run != nil {
+				cmd.run(client, args[1:], pokedex)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(conn, "Unknown command %q. Type 'help' for a list of commands.\n", args[0])
+		}
+	}
+}
+
+// loginPrompt asks the client to register or log in until it succeeds.
+func loginPrompt(client *Client) (*Account, error) {
+	conn := client.conn
+	fmt.Fprintln(conn, "Welcome to the Pokémon server!")
+
+	for attempts := 0; attempts < maxLoginAttempts; {
+		fmt.Fprintln(conn, "Type 'register <username> <password>' to create an account or 'login <username> <password>' to sign in.")
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args := strings.Fields(line)
+		if len(args) != 3 {
+			fmt.Fprintln(conn, "Invalid command.")
+			continue
+		}
+
+		var account *Account
+		switch strings.ToLower(args[0]) {
+		case "register":
+			account, err = registerAccount(args[1], args[2])
+		case "login":
+			account, err = authenticate(args[1], args[2])
+		default:
+			fmt.Fprintln(conn, "Invalid command.")
+			continue
+		}
+		if err == nil {
+			err = startSession(account, client)
+		}
+		if err != nil {
+			fmt.Fprintf(conn, "Error: %v\n", err)
+			attempts++
+			continue
+		}
+		return account, nil
+	}
+
+	fmt.Fprintln(conn, "Too many failed attempts.")
+	return nil, fmt.Errorf("too many failed attempts")
+}
+
+func cmdHelp(client *Client, args []string, pokedex []Pokemon) {
+	fmt.Fprintln(client.conn, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(client.conn, "  %-30s %s\n", cmd.usage, cmd.help)
+	}
+}
+
+func cmdHistory(client *Client, args []string, pokedex []Pokemon) {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	if len(player.BattleHistory) == 0 {
+		fmt.Fprintln(client.conn, "You have not battled yet.")
+		return
+	}
+	for _, record := range player.BattleHistory {
+		fmt.Fprintf(client.conn, "%s  %-4s vs %s (+%d exp)\n",
+			record.Timestamp.Format(time.RFC822), record.Result, record.OpponentID, record.ExperienceGained)
+	}
+}
+
+// battleRoom pairs two clients for a battle.
+type battleRoom struct {
+	order []*Client     // Clients in the order they joined
+	ready chan struct{} // Closed once the room is full
+	done  chan struct{} // Signalled by each client after choosing a team
+	over  chan struct{} // Closed once the result is recorded
+}
+
+var (
+	lobbies    = make(map[string]*battleRoom) // Rooms waiting for a second client, by format
+	lobbyMutex sync.Mutex
+)
+
+// cmdBattle finds an opponent for the client: another player, or a computer opponent
+// with "battle ai <level>".
+func cmdBattle(client *Client, args []string, pokedex []Pokemon) {
+	aiLevel := ""
+	if len(args) > 0 && strings.ToLower(args[0]) == "ai" {
+		if len(args) < 2 {
+			fmt.Fprintf(client.conn, "Usage: battle ai <%s> [draft]\n", strings.Join(aiLevels, "|"))
+			return
+		}
+		aiLevel = strings.ToLower(args[1])
+		args = args[2:]
+		if _, err := newAIController(aiLevel, nil); err != nil {
+			fmt.Fprintf(client.conn, "Error: %v\n", err)
+			return
+		}
+	}
+
+	format := formatCollection
+	if len(args) > 0 {
+		format = strings.ToLower(args[0])
+	}
+	switch format {
+	case formatCollection:
+		client.player.mutex.Lock()
+		owned, able := len(client.player.Pokemons), len(client.player.ableKeys())
+		client.player.mutex.Unlock()
+		if owned == 0 {
+			fmt.Fprintln(client.conn, "You have no Pokémon yet. Capture some first, or use 'battle draft'.")
+			return
+		}
+		if able == 0 {
+			fmt.Fprintln(client.conn, "All your Pokémon have fainted! Heal them with 'heal' or at a Pokémon Center.")
+			return
+		}
+	case formatDraft:
+	default:
+		fmt.Fprintln(client.conn, "Usage: battle [ai <level>] [draft]")
+		return
+	}
+
+	client.setBattling(true)
+	defer client.setBattling(false)
+
+	// Start from a clean state in case the client battled before.
+	client.team = nil
+	client.roster = nil
+	client.expGained = nil
+
+	if aiLevel != "" {
+		battleAI(client, aiLevel, format, pokedex)
+		return
+	}
+
+	lobbyMutex.Lock()
+	room := lobbies[format]
+	if room == nil {
+		room = &battleRoom{
+			ready: make(chan struct{}),
+			done:  make(chan struct{}, 2),
+			over:  make(chan struct{}),
+		}
+		lobbies[format] = room
+	}
+	room.order = append(room.order, client)
+	if len(room.order) == 2 {
+		delete(lobbies, format)
+		close(room.ready)
+		go room.run()
+	}
+	lobbyMutex.Unlock()
+
+	select {
+	case <-room.ready:
+	default:
+		fmt.Fprintln(client.conn, "Waiting for an opponent...")
+		select {
+		case <-room.ready:
+		case <-client.ctx.Done():
+			// Leave the room unless an opponent joined meanwhile; then the battle is called off.
+			lobbyMutex.Lock()
+			alone := lobbies[format] == room
+			if alone {
+				delete(lobbies, format)
+			}
+			lobbyMutex.Unlock()
+			if alone {
+				return
+			}
+			<-room.ready
+		}
+	}
+
+	handleConnection(client, pokedex, format, room.done)
+	<-room.over // The room runs the battle; return to the lobby together
+}
+
+// run plays the battle once both clients have chosen their teams and records the result.
+func (room *battleRoom) run() {
+	<-room.done // Wait for both players to be ready before starting the battle.
+	<-room.done
+
+	a, b := room.order[0], room.order[1]
+	draw := false
+	switch {
+	case len(a.team) > 0 && len(b.team) > 0:
+		fmt.Println("Both players are ready! Let the battle begin!")
+		battle := newBattle(
+			newTeamSide(a.account.Username, a.team, &humanController{client: a}),
+			newTeamSide(b.account.Username, b.team, &humanController{client: b}),
+			currentConfig().Battle.rules(),
+			pokeworld.forkRNG(),
+		)
+		battle.onFaint = awardFaintExperience
+		battle.onItem = bagItemHook(a, b)
+		battle.run(a.ctx)
+		keepBattleState(a)
+		keepBattleState(b)
+
+		// Only the winner keeps a team, so the result is recorded below.
+		a.team, b.team = nil, nil
+		if battle.winner >= 0 {
+			room.order[battle.winner].team = survivors(battle.sides[battle.winner])
+		}
+		draw = battle.outcome == outcomeStalemate
+	case len(a.team) > 0:
+		fmt.Fprintln(a.conn, "Your opponent left the battle. You win!")
+	case len(b.team) > 0:
+		fmt.Fprintln(b.conn, "Your opponent left the battle. You win!")
+	}
+
+	switch {
+	case draw:
+		recordDraw(a, b)
+	case len(a.team) > 0 && len(b.team) == 0:
+		recordBattle(a, b)
+	case len(b.team) > 0 && len(a.team) == 0:
+		recordBattle(b, a)
+	}
+	close(room.over)
+}
+
+// recordBattle awards experience and adds the result of a battle to the history of both players.
+func recordBattle(winner, loser *Client) {
+	awardWinExperience(winner, loser.roster)
+	awardPrize(winner)
+
+	now := pokeworld.clock.Now()
+	addBattleRecord(winner.player, BattleRecord{
+		OpponentID:       playerKey(loser.player.ID),
+		Result:           resultWin,
+		ExperienceGained: applyBattleExperience(winner),
+		Timestamp:        now,
+	})
+	addBattleRecord(loser.player, BattleRecord{
+		OpponentID:       playerKey(winner.player.ID),
+		Result:           resultLoss,
+		ExperienceGained: applyBattleExperience(loser),
+		Timestamp:        now,
+	})
+}
+
+// recordDraw adds a draw to the history of both players. Neither gets the winner's experience
+// or prize, only what their Pokémon earned during the battle.
+func recordDraw(a, b *Client) {
+	now := pokeworld.clock.Now()
+	for _, pair := range [][2]*Client{{a, b}, {b, a}} {
+		client, opponent := pair[0], pair[1]
+		addBattleRecord(client.player, BattleRecord{
+			OpponentID:       playerKey(opponent.player.ID),
+			Result:           resultDraw,
+			ExperienceGained: applyBattleExperience(client),
+			Timestamp:        now,
+		})
+	}
+}
+
+// addBattleRecord appends a record to a player's history and saves it.
+func addBattleRecord(player *Player, record BattleRecord) {
+	player.mutex.Lock()
+	player.BattleHistory = append(player.BattleHistory, record)
+	player.mutex.Unlock()
+
+	if err := store.AppendBattle(player.ID, record); err != nil {
+		log.Printf("Error saving battle history of player %d: %v", player.ID, err)
+	}
+}
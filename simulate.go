@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// simulationOptions configure a headless run of AI-vs-AI battles.
+type simulationOptions struct {
+	battles    *int
+	seed       *int64
+	teamA      *string
+	teamB      *string
+	aiA        *string
+	aiB        *string
+	level      *int
+	maxTurns   *int
+	noProgress *int
+	format     *string
+	out        *string
+}
+
+// addSimulationFlags registers the command line flags of the battle simulator.
+func addSimulationFlags() *simulationOptions {
+	return &simulationOptions{
+		battles:  flag.Int("simulate", 0, "run this many AI-vs-AI battles offline, report statistics and exit"),
+		seed:     flag.Int64("sim-seed", 1, "random seed of the simulation"),
+		teamA:    flag.String("sim-team-a", "", "comma-separated species of the first team (random teams if empty)"),
+		teamB:    flag.String("sim-team-b", "", "comma-separated species of the second team (random teams if empty)"),
+		aiA:      flag.String("sim-ai-a", aiGreedy, "AI level of the first team: "+strings.Join(aiLevels, ", ")),
+		aiB:      flag.String("sim-ai-b", aiGreedy, "AI level of the second team: "+strings.Join(aiLevels, ", ")),
+		level:    flag.Int("sim-level", 50, "level of every Pokémon; 0 uses the Pokédex stats as in a draft"),
+		maxTurns: flag.Int("sim-max-turns", defaultBattleRules.MaxTurns, "turns after which a battle is stopped as a stalemate"),
+		noProgress: flag.Int("sim-no-progress", defaultBattleRules.NoProgressTurns,
+			"turns in a row without HP changes after which a battle is stopped as a stalemate (0 disables)"),
+		format: flag.String("sim-format", "csv", "report format: csv or json"),
+		out:    flag.String("sim-out", "", "write the report to this file instead of standard output"),
+	}
+}
+
+// SimulationRow holds the results of one group of battlers: a side, a species or a type.
+type SimulationRow struct {
+	Category      string  `json:"category"` // "side", "species" or "type"
+	Name          string  `json:"name"`
+	Battles       int     `json:"battles"`
+	Wins          int     `json:"wins"`
+	Losses        int     `json:"losses"`
+	Stalemates    int     `json:"stalemates"` // Battles stopped without a winner
+	Tiebreaks     int     `json:"tiebreaks"`  // Battles stopped and won on tiebreak, also counted as wins and losses
+	WinRate       float64 `json:"win_rate"`
+	StalemateRate float64 `json:"stalemate_rate"`
+	AverageTurns  float64 `json:"average_turns"`
+	turns         int
+}
+
+// add counts one battle of the group.
+func (r *SimulationRow) add(b *Battle, side int) {
+	r.Battles++
+	r.turns += b.turn
+	switch {
+	case b.winner == side:
+		r.Wins++
+	case b.winner >= 0:
+		r.Losses++
+	}
+	switch b.outcome {
+	case outcomeStalemate:
+		r.Stalemates++
+	case outcomeTiebreak:
+		r.Tiebreaks++
+	}
+}
+
+// finish computes the rates of the group.
+func (r *SimulationRow) finish() {
+	if r.Battles == 0 {
+		return
+	}
+	r.WinRate = float64(r.Wins) / float64(r.Battles)
+	r.StalemateRate = float64(r.Stalemates) / float64(r.Battles)
+	r.AverageTurns = float64(r.turns) / float64(r.Battles)
+}
+
+// SimulationReport is the outcome of a simulation.
+type SimulationReport struct {
+	Seed          int64            `json:"seed"`
+	Battles       int              `json:"battles"`
+	Stalemates    int              `json:"stalemates"`
+	Tiebreaks     int              `json:"tiebreaks"`
+	StalemateRate float64          `json:"stalemate_rate"`
+	AverageTurns  float64          `json:"average_turns"`
+	Sides         []*SimulationRow `json:"sides"`
+	Species       []*SimulationRow `json:"species"`
+	Types         []*SimulationRow `json:"types"`
+}
+
+// simulation runs seeded AI-vs-AI battles. Empty teams are replaced by random ones for every battle.
+type simulation struct {
+	battles int
+	seed    int64
+	teams   [2][]string
+	ai      [2]string
+	level   int
+	rules   BattleRules
+}
+
+// buildTeam creates fresh battlers for a list of species at the simulation's level, with their
+// abilities and EV spreads rolled like those of random teams.
+func (sim *simulation) buildTeam(names []string, rng RNG) ([]*Pokemon, error) {
+	team := make([]*Pokemon, 0, len(names))
+	for _, name := range names {
+		pokemon, ok := species[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown species %q", name)
+		}
+		team = append(team, teamMember(pokemon, sim.level, rng))
+	}
+	return team, nil
+}
+
+// run plays every battle and collects the statistics.
+func (sim *simulation) run(pokedex []Pokemon) (*SimulationReport, error) {
+	rng := newRNG(sim.seed)
+	report := &SimulationReport{Seed: sim.seed, Battles: sim.battles}
+	sides := [2]*SimulationRow{
+		{Category: "side", Name: "A (" + sim.ai[0] + ")"},
+		{Category: "side", Name: "B (" + sim.ai[1] + ")"},
+	}
+	bySpecies := make(map[string]*SimulationRow)
+	byType := make(map[string]*SimulationRow)
+	row := func(rows map[string]*SimulationRow, category, name string) *SimulationRow {
+		if rows[name] == nil {
+			rows[name] = &SimulationRow{Category: category, Name: name}
+		}
+		return rows[name]
+	}
+
+	turns := 0
+	for i := 0; i < sim.battles; i++ {
+		battleRNG := newRNG(rng.Int63())
+		var teams [2][]*Pokemon
+		for side, names := range sim.teams {
+			if len(names) == 0 {
+				teams[side] = randomTeam(pokedex, teamSize, sim.level, battleRNG)
+				continue
+			}
+			team, err := sim.buildTeam(names, battleRNG)
+			if err != nil {
+				return nil, err
+			}
+			teams[side] = team
+		}
+
+		b, err := playAIMatch(teams[0], teams[1], sim.ai[0], sim.ai[1], sim.rules, battleRNG)
+		if err != nil {
+			return nil, err
+		}
+		turns += b.turn
+		switch b.outcome {
+		case outcomeStalemate:
+			report.Stalemates++
+		case outcomeTiebreak:
+			report.Tiebreaks++
+		}
+		for side, team := range teams {
+			sides[side].add(b, side)
+			// A species or type counts once per battle and side, however many of it are in the team.
+			seenSpecies, seenTypes := make(map[string]bool), make(map[string]bool)
+			for _, p := range team {
+				if !seenSpecies[p.Name] {
+					seenSpecies[p.Name] = true
+					row(bySpecies, "species", p.Name).add(b, side)
+				}
+				for _, t := range p.Type {
+					if !seenTypes[t] {
+						seenTypes[t] = true
+						row(byType, "type", t).add(b, side)
+					}
+				}
+			}
+		}
+	}
+
+	if sim.battles > 0 {
+		report.StalemateRate = float64(report.Stalemates) / float64(sim.battles)
+		report.AverageTurns = float64(turns) / float64(sim.battles)
+	}
+	report.Sides = sides[:]
+	report.Species = sortedRows(bySpecies)
+	report.Types = sortedRows(byType)
+	for _, rows := range [][]*SimulationRow{report.Sides, report.Species, report.Types} {
+		for _, r := range rows {
+			r.finish()
+		}
+	}
+	return report, nil
+}
+
+func sortedRows(rows map[string]*SimulationRow) []*SimulationRow {
+	sorted := make([]*SimulationRow, 0, len(rows))
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// writeJSON writes the report as indented JSON.
+func (report *SimulationReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// writeCSV writes one line per side, species and type, after a line summarizing all battles.
+func (report *SimulationReport) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"category", "name", "battles", "wins", "losses", "stalemates", "tiebreaks", "win_rate", "stalemate_rate", "average_turns"})
+	out.Write([]string{"all", "all", strconv.Itoa(report.Battles), "", "", strconv.Itoa(report.Stalemates),
+		strconv.Itoa(report.Tiebreaks), "",
+		formatRate(report.StalemateRate), formatRate(report.AverageTurns)})
+	for _, rows := range [][]*SimulationRow{report.Sides, report.Species, report.Types} {
+		for _, r := range rows {
+			out.Write([]string{r.Category, r.Name, strconv.Itoa(r.Battles), strconv.Itoa(r.Wins), strconv.Itoa(r.Losses),
+				strconv.Itoa(r.Stalemates), strconv.Itoa(r.Tiebreaks), formatRate(r.WinRate), formatRate(r.StalemateRate), formatRate(r.AverageTurns)})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatRate(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+// splitTeam parses a comma-separated list of species.
+func splitTeam(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runSimulation runs the simulator with the command line options and writes the report.
+// It only needs the Pokédex; no network or saved data is used.
+func runSimulation(opts *simulationOptions, pokedex []Pokemon) error {
+	sim := &simulation{
+		battles: *opts.battles,
+		seed:    *opts.seed,
+		teams:   [2][]string{splitTeam(*opts.teamA), splitTeam(*opts.teamB)},
+		ai:      [2]string{strings.ToLower(*opts.aiA), strings.ToLower(*opts.aiB)},
+		level:   *opts.level,
+		rules: BattleRules{
+			MaxTurns:        *opts.maxTurns,
+			NoProgressTurns: *opts.noProgress,
+			Tiebreak:        true,
+		},
+	}
+	for _, level := range sim.ai {
+		if _, err := newAIController(level, nil); err != nil {
+			return err
+		}
+	}
+	for _, names := range sim.teams {
+		if len(names) > teamSize {
+			return fmt.Errorf("a team has at most %d Pokémon", teamSize)
+		}
+	}
+	if sim.rules.MaxTurns < 1 || sim.rules.NoProgressTurns < 0 {
+		return fmt.Errorf("the turn limit must be at least 1 and the no-progress limit at least 0")
+	}
+	if sim.level < 0 || sim.level > maxLevel {
+		return fmt.Errorf("level must be between 0 and %d", maxLevel)
+	}
+	format := strings.ToLower(*opts.format)
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown report format %q (choose csv or json)", *opts.format)
+	}
+
+	report, err := sim.run(pokedex)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *opts.out != "" {
+		file, err := os.Create(*opts.out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if format == "json" {
+		return report.writeJSON(w)
+	}
+	return report.writeCSV(w)
+}
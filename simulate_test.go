@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSimulationRowAdd(t *testing.T) {
+	tests := []struct {
+		winner  int
+		outcome string
+		want    SimulationRow // Of side 0
+	}{
+		{0, outcomeWin, SimulationRow{Battles: 1, Wins: 1}},
+		{1, outcomeWin, SimulationRow{Battles: 1, Losses: 1}},
+		{0, outcomeTiebreak, SimulationRow{Battles: 1, Wins: 1, Tiebreaks: 1}},
+		{1, outcomeTiebreak, SimulationRow{Battles: 1, Losses: 1, Tiebreaks: 1}},
+		{-1, outcomeStalemate, SimulationRow{Battles: 1, Stalemates: 1}},
+	}
+	for _, tt := range tests {
+		var r SimulationRow
+		r.add(&Battle{winner: tt.winner, outcome: tt.outcome}, 0)
+		if r != tt.want {
+			t.Errorf("%s for side %d counted as %+v, want %+v", tt.outcome, tt.winner, r, tt.want)
+		}
+	}
+}
+
+// TestSimulationRun runs short battles, most of which end on tiebreak, and checks that every
+// battle is counted once as a win, a loss or a stalemate.
+func TestSimulationRun(t *testing.T) {
+	sim := &simulation{battles: 20, seed: 1, ai: [2]string{aiGreedy, aiRandom},
+		rules: BattleRules{MaxTurns: 3, NoProgressTurns: 2, Tiebreak: true}}
+	report, err := sim.run(testPokedex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tiebreaks == 0 {
+		t.Fatal("no battle ended on tiebreak")
+	}
+	a, b := report.Sides[0], report.Sides[1]
+	if a.Wins+a.Losses+a.Stalemates != report.Battles {
+		t.Errorf("side A: %d wins, %d losses and %d stalemates in %d battles", a.Wins, a.Losses, a.Stalemates, report.Battles)
+	}
+	if a.Wins != b.Losses || a.Losses != b.Wins || a.Stalemates != report.Stalemates || b.Tiebreaks != report.Tiebreaks {
+		t.Errorf("sides don't add up: A %+v, B %+v, report %d stalemates and %d tiebreaks", a, b, report.Stalemates, report.Tiebreaks)
+	}
+	for _, rows := range [][]*SimulationRow{report.Species, report.Types} {
+		for _, r := range rows {
+			if r.Wins+r.Losses+r.Stalemates != r.Battles {
+				t.Errorf("%s %s: %d wins, %d losses and %d stalemates in %d battles", r.Category, r.Name, r.Wins, r.Losses, r.Stalemates, r.Battles)
+			}
+		}
+	}
+
+	again, err := sim.run(testPokedex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, again) {
+		t.Errorf("the same seed gave %+v, then %+v", report, again)
+	}
+
+	var buf bytes.Buffer
+	if err := report.writeCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 + len(report.Sides) + len(report.Species) + len(report.Types); len(records) != want {
+		t.Errorf("%d CSV lines, want %d", len(records), want)
+	}
+	if records[0][6] != "tiebreaks" || records[1][6] != strconv.Itoa(report.Tiebreaks) {
+		t.Errorf("CSV starts with %v and %v, want the tiebreaks in column 7", records[0], records[1])
+	}
+}
+
+func TestSimulationUnknownSpecies(t *testing.T) {
+	sim := &simulation{battles: 1, seed: 1, teams: [2][]string{{"missingno"}}, ai: [2]string{aiGreedy, aiGreedy},
+		rules: defaultBattleRules}
+	if _, err := sim.run(testPokedex); err == nil {
+		t.Error("simulated a team with an unknown species")
+	}
+}
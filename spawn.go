@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Reasons a wild Pokémon leaves the world.
+const (
+	leftDespawned = "despawned" // Its despawn timer ran out
+	leftCaught    = "caught"
+	leftFled      = "fled"     // It broke free of a Poké Ball and ran away
+	leftDefeated  = "defeated" // It fainted in a battle
+)
+
+// releaseOffsets are the tiles around a player, relative to them, where Pokémon they release are
+// placed: the first one that is free.
+var releaseOffsets = [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}, {1, -1}, {1, 1}, {-1, 1}, {-1, -1}}
+
+// spawnMetrics count what happens to the wild Pokémon of the world. The counters only go up,
+// so TotalPokemon is always spawned minus the sum of removed.
+type spawnMetrics struct {
+	spawned uint64
+	removed map[string]uint64 // By reason
+}
+
+// wildTimer is the despawn timer of a wild Pokémon, with the time it falls due.
+type wildTimer struct {
+	Timer
+	due time.Time
+}
+
+// addWild places a new wild Pokémon at the empty tile (x, y), gives it an entity ID and starts
+// its despawn timer. The caller must hold pw's lock.
+func (pw *Pokeworld) addWild(x, y int, pokemon *Pokemon) {
+	pw.addWildFor(x, y, pokemon, pw.PokemonDespawnTime)
+}
+
+// addWildFor is addWild for a Pokémon that despawns after life rather than the despawn time.
+// The caller must hold pw's lock.
+func (pw *Pokeworld) addWildFor(x, y int, pokemon *Pokemon, life time.Duration) {
+	pw.nextWildID++
+	id := pw.nextWildID
+	pokemon.wildID = id
+	pw.grid[x][y] = pokemon
+	pw.TotalPokemon++
+	pw.metrics.spawned++
+	pw.events.publish(WorldEvent{Kind: eventSpawn, X: x, Y: y, Pokemon: pokemon.Name})
+
+	timer := pw.clock.AfterFunc(life, func() {
+		pw.Lock()
+		defer pw.Unlock()
+		pw.removeWild(x, y, pokemon, leftDespawned) // Unless it left the world already
+	})
+	pw.despawnTimers[id] = wildTimer{Timer: timer, due: pw.clock.Now().Add(life)}
+}
+
+// releaseWild puts a Pokémon a player released back into the world, on a free tile next to (x, y),
+// as a wild Pokémon like any other. If there is no free tile or the world is full, it wanders off
+// for good.
+func (pw *Pokeworld) releaseWild(x, y int, pokemon Pokemon) {
+	pw.Lock()
+	defer pw.Unlock()
+	if pw.TotalPokemon >= pw.MaxPokemon {
+		return
+	}
+	for _, offset := range releaseOffsets {
+		tx, ty := x+offset[0], y+offset[1]
+		if tx < 0 || ty < 0 || tx >= pw.Width || ty >= pw.Height || pw.grid[tx][ty] != nil || isPokemonCenter(tx, ty) {
+			continue
+		}
+		// Back in the wild it is rested and belongs to nobody.
+		wild := pokemon
+		wild.Owner = nil
+		wild.collectionKey = 0
+		wild.Nickname = ""
+		wild.Favorite = false
+		wild.HeldItem = ""
+		wild.HPLost = 0
+		wild.Status = ""
+		recomputeStats(&wild)
+		pw.addWild(tx, ty, &wild)
+		return
+	}
+}
+
+// removeWild takes a wild Pokémon out of the world for the given reason and stops its despawn
+// timer. It reports false if the Pokémon is no longer at (x, y). Catches are announced by the
+// caller, who knows the catcher.
+// The caller must hold pw's lock.
+func (pw *Pokeworld) removeWild(x, y int, pokemon *Pokemon, reason string) bool {
+	if pw.grid[x][y] != pokemon {
+		return false
+	}
+	pw.grid[x][y] = nil
+	if timer, ok := pw.despawnTimers[pokemon.wildID]; ok {
+		timer.Stop()
+		delete(pw.despawnTimers, pokemon.wildID)
+	}
+	pw.TotalPokemon--
+	pw.metrics.removed[reason]++
+	if reason != leftCaught {
+		pw.events.publish(WorldEvent{Kind: eventDespawn, X: x, Y: y, Pokemon: pokemon.Name})
+	}
+	return true
+}
+
+// stopDespawnTimers stops the despawn timers of every wild Pokémon, which stay where they are.
+func (pw *Pokeworld) stopDespawnTimers() {
+	pw.Lock()
+	defer pw.Unlock()
+	for id, timer := range pw.despawnTimers {
+		timer.Stop()
+		delete(pw.despawnTimers, id)
+	}
+}
+
+// state returns the wild Pokémon of the world, with the time each despawns, and the time of
+// the next spawn, to be saved in the store.
+func (pw *Pokeworld) state() *WorldState {
+	pw.Lock()
+	defer pw.Unlock()
+	state := &WorldState{NextSpawn: pw.NextSpawn}
+	for x := range pw.grid {
+		for y, pokemon := range pw.grid[x] {
+			if pokemon == nil {
+				continue
+			}
+			state.Wild = append(state.Wild, WildPokemon{X: x, Y: y, Pokemon: toSavedPokemon(0, *pokemon),
+				DespawnAt: pw.despawnTimers[pokemon.wildID].due})
+		}
+	}
+	return state
+}
+
+// restore puts the wild Pokémon of a saved state back into the world for the time they had
+// left, and keeps the time of the next spawn. Pokémon whose time ran out while the server was
+// stopped, or that no longer fit in the world, e.g. because it got smaller, are left out.
+func (pw *Pokeworld) restore(state *WorldState) {
+	pw.Lock()
+	defer pw.Unlock()
+	now := pw.clock.Now()
+	pw.NextSpawn = state.NextSpawn
+	for _, wild := range state.Wild {
+		life := wild.DespawnAt.Sub(now)
+		x, y := wild.X, wild.Y
+		if life <= 0 || x < 0 || y < 0 || x >= pw.Width || y >= pw.Height || pw.grid[x][y] != nil ||
+			isPokemonCenter(x, y) || pw.TotalPokemon >= pw.MaxPokemon {
+			continue
+		}
+		pokemon := wild.Pokemon.toPokemon()
+		pw.addWildFor(x, y, &pokemon, life)
+	}
+}
+
+// writeMetrics writes the spawn counters and the wild population in the Prometheus text format.
+func (pw *Pokeworld) writeMetrics(w io.Writer) {
+	pw.Lock()
+	spawned, total := pw.metrics.spawned, pw.TotalPokemon
+	removed := make(map[string]uint64, len(pw.metrics.removed))
+	for reason, n := range pw.metrics.removed {
+		removed[reason] = n
+	}
+	pw.Unlock()
+
+	fmt.Fprintln(w, "# HELP pokecat_wild_spawned_total Wild Pokémon spawned in the world.")
+	fmt.Fprintln(w, "# TYPE pokecat_wild_spawned_total counter")
+	fmt.Fprintf(w, "pokecat_wild_spawned_total %d\n", spawned)
+
+	fmt.Fprintln(w, "# HELP pokecat_wild_removed_total Wild Pokémon that left the world, by reason.")
+	fmt.Fprintln(w, "# TYPE pokecat_wild_removed_total counter")
+	for _, reason := range []string{leftCaught, leftDefeated, leftDespawned, leftFled} {
+		fmt.Fprintf(w, "pokecat_wild_removed_total{reason=%q} %d\n", reason, removed[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP pokecat_wild_pokemon Wild Pokémon currently in the world.")
+	fmt.Fprintln(w, "# TYPE pokecat_wild_pokemon gauge")
+	fmt.Fprintf(w, "pokecat_wild_pokemon %d\n", total)
+}
+
+// metricsHandler serves the world's metrics to Prometheus at /metrics.
+func metricsHandler(pw *Pokeworld) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		pw.writeMetrics(w)
+	})
+	return mux
+}
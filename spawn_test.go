@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testPokedex is a small Pokédex for worlds built in tests.
+var testPokedex = []Pokemon{
+	{Name: "rattata", Type: []string{"normal"}, HP: 30, Attack: 56, Defense: 35, SpecialAttack: 25, SpecialDefense: 35, Speed: 72, Level: 1, EV: 0.5},
+	{Name: "magikarp", Type: []string{"water"}, HP: 20, Attack: 10, Defense: 55, SpecialAttack: 15, SpecialDefense: 20, Speed: 80, Level: 1, EV: 0.5},
+	{Name: "geodude", Type: []string{"rock", "ground"}, HP: 40, Attack: 80, Defense: 100, SpecialAttack: 30, SpecialDefense: 30, Speed: 20, Level: 1, EV: 0.5},
+}
+
+// newTestWorld returns a small world seeded with seed whose timers run on clock.
+func newTestWorld(seed int64, clock Clock) *Pokeworld {
+	settings := defaultConfig().World
+	settings.Width, settings.Height = 12, 8
+	settings.Seed = seed
+	settings.MaxWildPokemon = 30
+	settings.PokemonPerSpawn = 5
+	settings.DespawnTime = Duration(5 * time.Minute)
+	return newPokeworld(testPokedex, settings, clock)
+}
+
+// checkPopulation verifies that every count of the wild Pokémon of pw agrees with its grid.
+func checkPopulation(pw *Pokeworld, clock *fakeClock) error {
+	pw.Lock()
+	defer pw.Unlock()
+
+	onGrid := 0
+	ids := make(map[uint64]bool)
+	for x := range pw.grid {
+		for _, p := range pw.grid[x] {
+			if p == nil {
+				continue
+			}
+			onGrid++
+			if p.wildID == 0 || ids[p.wildID] {
+				return fmt.Errorf("wild %s has entity ID %d, which is missing or not unique", p.Name, p.wildID)
+			}
+			ids[p.wildID] = true
+			if _, ok := pw.despawnTimers[p.wildID]; !ok {
+				return fmt.Errorf("wild %s (ID %d) has no despawn timer", p.Name, p.wildID)
+			}
+		}
+	}
+	removed := uint64(0)
+	for _, n := range pw.metrics.removed {
+		removed += n
+	}
+	switch {
+	case pw.TotalPokemon != onGrid:
+		return fmt.Errorf("TotalPokemon is %d, but %d Pokémon are on the grid", pw.TotalPokemon, onGrid)
+	case len(pw.despawnTimers) != onGrid:
+		return fmt.Errorf("%d despawn timers for %d Pokémon", len(pw.despawnTimers), onGrid)
+	case clock.Pending() != onGrid:
+		return fmt.Errorf("%d pending timers for %d Pokémon", clock.Pending(), onGrid)
+	case pw.metrics.spawned-removed != uint64(onGrid):
+		return fmt.Errorf("%d spawned minus %d removed, but %d Pokémon are on the grid", pw.metrics.spawned, removed, onGrid)
+	}
+	return nil
+}
+
+// randomWild returns a random wild Pokémon of the world and its position, or nil if there is none.
+func randomWild(pw *Pokeworld, rng RNG) (x, y int, p *Pokemon) {
+	pw.Lock()
+	defer pw.Unlock()
+	var found [][2]int
+	for x := range pw.grid {
+		for y, p := range pw.grid[x] {
+			if p != nil {
+				found = append(found, [2]int{x, y})
+			}
+		}
+	}
+	if len(found) == 0 {
+		return 0, 0, nil
+	}
+	pos := found[rng.Intn(len(found))]
+	return pos[0], pos[1], pw.grid[pos[0]][pos[1]]
+}
+
+// TestSpawnLifecycle runs random sequences of spawns, releases, removals and despawns and checks
+// after every step that the population counts match the grid.
+func TestSpawnLifecycle(t *testing.T) {
+	reasons := []string{leftCaught, leftFled, leftDefeated}
+	for seed := int64(1); seed <= 200; seed++ {
+		clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		pw := newTestWorld(seed, clock)
+		rng := newRNG(seed)
+
+		for step := 0; step < 100; step++ {
+			var op string
+			switch rng.Intn(6) {
+			case 0:
+				op = "spawn"
+				pw.spawnPokemon(1 + rng.Intn(5))
+			case 1:
+				op = "add"
+				x, y := rng.Intn(pw.Width), rng.Intn(pw.Height)
+				pw.Lock()
+				if pw.grid[x][y] == nil && pw.TotalPokemon < pw.MaxPokemon {
+					p := testPokedex[rng.Intn(len(testPokedex))]
+					pw.addWild(x, y, &p)
+				}
+				pw.Unlock()
+			case 2:
+				op = "remove"
+				if x, y, p := randomWild(pw, rng); p != nil {
+					pw.Lock()
+					if !pw.removeWild(x, y, p, reasons[rng.Intn(len(reasons))]) {
+						t.Errorf("seed %d step %d: removeWild of a Pokémon on the grid failed", seed, step)
+					}
+					// Removing it again, e.g. once its despawn timer fires, changes nothing.
+					if pw.removeWild(x, y, p, leftDespawned) {
+						t.Errorf("seed %d step %d: removeWild removed the same Pokémon twice", seed, step)
+					}
+					pw.Unlock()
+				}
+			case 3:
+				op = "release"
+				p := testPokedex[rng.Intn(len(testPokedex))]
+				pw.releaseWild(rng.Intn(pw.Width), rng.Intn(pw.Height), p)
+			default:
+				op = "advance"
+				clock.Advance(time.Duration(rng.Intn(int(4 * time.Minute))))
+			}
+			if err := checkPopulation(pw, clock); err != nil {
+				t.Fatalf("seed %d step %d (%s): %v", seed, step, op, err)
+			}
+			pw.Lock()
+			total, limit := pw.TotalPokemon, pw.MaxPokemon
+			pw.Unlock()
+			if total > limit {
+				t.Fatalf("seed %d step %d (%s): %d wild Pokémon, more than the maximum of %d", seed, step, op, total, limit)
+			}
+		}
+
+		// Every Pokémon left despawns in time.
+		clock.Advance(5 * time.Minute)
+		if err := checkPopulation(pw, clock); err != nil {
+			t.Fatalf("seed %d after despawning: %v", seed, err)
+		}
+		if pw.TotalPokemon != 0 {
+			t.Errorf("seed %d: %d wild Pokémon left after their despawn time", seed, pw.TotalPokemon)
+		}
+	}
+}
+
+func TestStopDespawnTimers(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	pw := newTestWorld(1, clock)
+	pw.spawnPokemon(10)
+	total := pw.TotalPokemon
+	pw.stopDespawnTimers()
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers still pending", clock.Pending())
+	}
+	clock.Advance(time.Hour)
+	if pw.TotalPokemon != total {
+		t.Errorf("TotalPokemon went from %d to %d after the timers were stopped", total, pw.TotalPokemon)
+	}
+}
+
+func TestWorldStateRestore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	pw := newTestWorld(1, clock)
+	pw.spawnPokemon(5)
+	first := pw.TotalPokemon
+	clock.Advance(2 * time.Minute)
+	pw.spawnPokemon(5) // Despawns 2 minutes after the first wave
+	second := pw.TotalPokemon - first
+	pw.NextSpawn = start.Add(5 * time.Minute)
+	state := pw.state()
+	if len(state.Wild) != pw.TotalPokemon {
+		t.Fatalf("%d wild Pokémon saved of %d", len(state.Wild), pw.TotalPokemon)
+	}
+
+	// The server was stopped for 2 minutes: the first wave has a minute left, the second three.
+	later := newFakeClock(start.Add(4 * time.Minute))
+	restored := newTestWorld(2, later)
+	restored.restore(state)
+	if err := checkPopulation(restored, later); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.NextSpawn.Equal(pw.NextSpawn) {
+		t.Errorf("next spawn restored as %s, want %s", restored.NextSpawn, pw.NextSpawn)
+	}
+	if restored.TotalPokemon != pw.TotalPokemon {
+		t.Fatalf("%d wild Pokémon restored, want %d", restored.TotalPokemon, pw.TotalPokemon)
+	}
+	for _, wild := range state.Wild {
+		p := restored.grid[wild.X][wild.Y]
+		if p == nil || p.Name != wild.Pokemon.Name || p.Level != wild.Pokemon.Level {
+			t.Errorf("(%d, %d) holds %v after restoring, want %s", wild.X, wild.Y, p, wild.Pokemon.Name)
+		}
+	}
+
+	waves := []struct {
+		after time.Duration
+		left  int
+	}{
+		{time.Minute - time.Second, first + second},
+		{time.Second, second},
+		{2 * time.Minute, 0},
+	}
+	for _, wave := range waves {
+		later.Advance(wave.after)
+		if restored.TotalPokemon != wave.left {
+			t.Errorf("%s after the restart: %d wild Pokémon, want %d", later.Now().Sub(start.Add(4*time.Minute)), restored.TotalPokemon, wave.left)
+		}
+	}
+
+	// Once the despawn times passed, nothing comes back.
+	restored = newTestWorld(3, newFakeClock(start.Add(time.Hour)))
+	restored.restore(state)
+	if restored.TotalPokemon != 0 {
+		t.Errorf("%d wild Pokémon restored after their despawn time", restored.TotalPokemon)
+	}
+}
+
+func TestSpawnLoopKeepsRestoredNextSpawn(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	pw := newTestWorld(1, clock)
+	pw.PokemonSpawnRate = time.Hour
+	pw.restore(&WorldState{NextSpawn: start.Add(time.Minute)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pw.spawnPokemonLoop(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Wait for the loop to wait for the restored next spawn rather than an hour.
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	total := func() int {
+		pw.Lock()
+		defer pw.Unlock()
+		return pw.TotalPokemon
+	}
+	for total() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stat stages are indexed like the stats, with accuracy and evasion after them. HP has no stage.
+const (
+	stageAccuracy = numStats + iota
+	stageEvasion
+	numStages
+)
+
+// Limits of a stat stage.
+const (
+	minStage = -6
+	maxStage = 6
+)
+
+// stageNames are the display names of the stages.
+var stageNames = [numStages]string{
+	statAttack:         "Attack",
+	statDefense:        "Defense",
+	statSpecialAttack:  "Sp. Atk",
+	statSpecialDefense: "Sp. Def",
+	statSpeed:          "Speed",
+	stageAccuracy:      "accuracy",
+	stageEvasion:       "evasion",
+}
+
+// stageTags are the short names used in a battle's status line.
+var stageTags = [numStages]string{
+	statAttack:         "Atk",
+	statDefense:        "Def",
+	statSpecialAttack:  "SpA",
+	statSpecialDefense: "SpD",
+	statSpeed:          "Spe",
+	stageAccuracy:      "Acc",
+	stageEvasion:       "Eva",
+}
+
+// StageChange is the effect of a move on one stat stage.
+type StageChange struct {
+	Stat   int
+	Change int
+	Self   bool // Changes the user's stage rather than the target's
+}
+
+// stageMultiplier returns the factor a stage applies to Attack, Defense, Sp. Atk, Sp. Def or Speed:
+// from 2/8 at -6 to 8/2 at +6.
+func stageMultiplier(stage int) float64 {
+	if stage >= 0 {
+		return float64(2+stage) / 2
+	}
+	return 2 / float64(2-stage)
+}
+
+// accuracyMultiplier returns the factor a combined accuracy and evasion stage applies to the chance
+// of hitting: from 3/9 at -6 to 9/3 at +6.
+func accuracyMultiplier(stage int) float64 {
+	if stage > maxStage {
+		stage = maxStage
+	}
+	if stage < minStage {
+		stage = minStage
+	}
+	if stage >= 0 {
+		return float64(3+stage) / 3
+	}
+	return 3 / float64(3-stage)
+}
+
+// staged returns a copy of a combatant's Pokémon with its stat stages and held item applied,
+// which is what the damage calculation uses.
+func (c *combatant) staged() *Pokemon {
+	p := *c.pokemon
+	p.Attack = int(float64(p.Attack) * stageMultiplier(c.stages[statAttack]))
+	p.Defense = int(float64(p.Defense) * stageMultiplier(c.stages[statDefense]))
+	p.SpecialAttack = int(float64(p.SpecialAttack) * stageMultiplier(c.stages[statSpecialAttack]))
+	p.SpecialDefense = int(float64(p.SpecialDefense) * stageMultiplier(c.stages[statSpecialDefense]))
+	p.Speed = int(float64(p.Speed) * stageMultiplier(c.stages[statSpeed]))
+	holdItem(&p)
+	return &p
+}
+
+// resetStages clears a combatant's stat stages, as happens when it leaves the field.
+func (c *combatant) resetStages() {
+	c.stages = [numStages]int{}
+}
+
+// describeStages lists a combatant's changed stages, e.g. " Atk+2 Spe-1".
+func (c *combatant) describeStages() string {
+	var b strings.Builder
+	for stat, stage := range c.stages {
+		if stage != 0 {
+			fmt.Fprintf(&b, " %s%+d", stageTags[stat], stage)
+		}
+	}
+	return b.String()
+}
+
+// hits decides whether a move lands, from the attacker's accuracy and the defender's evasion.
+// Moves always hit while neither stage is changed.
+func (b *Battle) hits(attacker, defender *combatant) bool {
+	chance := accuracyMultiplier(attacker.stages[stageAccuracy] - defender.stages[stageEvasion])
+	return chance >= 1 || b.rng.Float64() < chance
+}
+
+// changeStage applies a move's stage change to a combatant of the given side.
+func (b *Battle) changeStage(side int, c *combatant, change StageChange) bool {
+	label := b.sides[side].label(c)
+	name := stageNames[change.Stat]
+	old := c.stages[change.Stat]
+	stage := old + change.Change
+	if stage > maxStage {
+		stage = maxStage
+	}
+	if stage < minStage {
+		stage = minStage
+	}
+
+	if stage == old {
+		direction := "higher"
+		if change.Change < 0 {
+			direction = "lower"
+		}
+		b.emit(BattleEvent{Kind: "stage", Side: side, Pokemon: c.pokemon.Name, Stat: name, Stage: old,
+			Text: fmt.Sprintf("%s's %s won't go any %s!", label, name, direction)})
+		return false
+	}
+	c.stages[change.Stat] = stage
+
+	var text string
+	switch diff := stage - old; {
+	case diff >= 2:
+		text = "%s's %s rose sharply!"
+	case diff == 1:
+		text = "%s's %s rose!"
+	case diff == -1:
+		text = "%s's %s fell!"
+	default:
+		text = "%s's %s harshly fell!"
+	}
+	b.emit(BattleEvent{Kind: "stage", Side: side, Pokemon: c.pokemon.Name, Stat: name, Stage: stage,
+		Text: fmt.Sprintf(text, label, name)})
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChangeStageClamps(t *testing.T) {
+	tests := []struct {
+		old, change int
+		want        int
+		changed     bool
+	}{
+		{0, 2, 2, true},
+		{5, 2, maxStage, true},
+		{maxStage, 1, maxStage, false},
+		{-5, -2, minStage, true},
+		{minStage, -1, minStage, false},
+		{maxStage, -1, maxStage - 1, true},
+		{minStage, 2, minStage + 2, true},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), newRNG(1))
+		c := b.sides[0].current()
+		c.stages[statAttack] = tt.old
+		changed := b.changeStage(0, c, StageChange{Stat: statAttack, Change: tt.change})
+		if got := c.stages[statAttack]; got != tt.want || changed != tt.changed {
+			t.Errorf("%+d from %+d: stage %+d, changed %v; want %+d, %v", tt.change, tt.old, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestStageMultipliers(t *testing.T) {
+	tests := []struct {
+		stage          int
+		stat, accuracy float64
+	}{
+		{minStage, 2.0 / 8, 3.0 / 9},
+		{-1, 2.0 / 3, 3.0 / 4},
+		{0, 1, 1},
+		{1, 3.0 / 2, 4.0 / 3},
+		{maxStage, 8.0 / 2, 9.0 / 3},
+		// Accuracy minus evasion can go past the limits; the chance stays within them.
+		{2 * maxStage, 0, 9.0 / 3},
+		{2 * minStage, 0, 3.0 / 9},
+	}
+	for _, tt := range tests {
+		if tt.stat != 0 {
+			if got := stageMultiplier(tt.stage); math.Abs(got-tt.stat) > 1e-9 {
+				t.Errorf("stage %+d: stat multiplier %v, want %v", tt.stage, got, tt.stat)
+			}
+		}
+		if got := accuracyMultiplier(tt.stage); math.Abs(got-tt.accuracy) > 1e-9 {
+			t.Errorf("stage %+d: accuracy multiplier %v, want %v", tt.stage, got, tt.accuracy)
+		}
+	}
+}
+
+func TestStagesResetOnFaint(t *testing.T) {
+	b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), newRNG(1))
+	c := b.sides[0].current()
+	c.stages[statAttack], c.stages[stageEvasion] = 3, -2
+	c.hp = 0
+	b.faint(0, c)
+	if c.stages != [numStages]int{} {
+		t.Errorf("stages %v after fainting, want them reset", c.stages)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Indexes of the stats in an EV spread, in the order produced by generateRandomEVs.
+const (
+	statHP = iota
+	statAttack
+	statDefense
+	statSpecialAttack
+	statSpecialDefense
+	statSpeed
+	numStats
+)
+
+// statNames are the display names of the stats, indexed like an EV spread.
+var statNames = [numStats]string{"HP", "Attack", "Defense", "Sp. Atk", "Sp. Def", "Speed"}
+
+// statEV returns the EV multiplier of one stat. Pokémon without a spread use their single EV for every stat.
+func (p *Pokemon) statEV(stat int) float64 {
+	if len(p.EVs) == numStats {
+		return p.EVs[stat]
+	}
+	return p.EV
+}
+
+// setSpread gives a Pokémon a per-stat EV spread. EV is kept as the average of the spread,
+// for code and saved data that only know about a single value.
+func setSpread(p *Pokemon, evs []float64) {
+	p.EVs = evs
+	total := 0.0
+	for _, ev := range evs {
+		total += ev
+	}
+	p.EV = total / float64(len(evs))
+}
+
+// statValues returns the stats of a Pokémon, indexed like an EV spread.
+func statValues(p *Pokemon) [numStats]int {
+	return [numStats]int{p.HP, p.Attack, p.Defense, p.SpecialAttack, p.SpecialDefense, p.Speed}
+}
+
+// cmdInspect shows the full stats and EV spread of a Pokémon in the player's collection.
+func cmdInspect(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: inspect <number>")
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	key, err := strconv.Atoi(args[0])
+	pokemon, ok := player.Pokemons[key]
+	player.mutex.Unlock()
+	if err != nil || !ok {
+		fmt.Fprintf(conn, "You don't have a Pokémon numbered %q.\n", args[0])
+		return
+	}
+
+	// Show the stats the Pokémon battles with, which follow from its level and spread.
+	recomputeStats(&pokemon)
+
+	fmt.Fprintf(conn, "%d. %s\n", key, describePokemon(pokemon))
+	if pokemon.Favorite {
+		fmt.Fprintln(conn, "One of your favorites.")
+	}
+	fmt.Fprintf(conn, "Experience: %d (next level at %d)\n", pokemon.AccumExp, expForLevel(pokemon.Level+1))
+	var b strings.Builder
+	values := statValues(&pokemon)
+	total := 0
+	for stat := 0; stat < numStats; stat++ {
+		fmt.Fprintf(&b, "  %-8s %4d  EV x%.2f\n", statNames[stat], values[stat], pokemon.statEV(stat))
+		total += values[stat]
+	}
+	fmt.Fprintf(&b, "  %-8s %4d\n", "Total", total)
+	fmt.Fprint(conn, b.String())
+}
@@ -0,0 +1,187 @@
+package main
+
+import "fmt"
+
+// Status conditions. A Pokémon has at most one, and keeps it between battles until it is healed.
+const (
+	statusBurn      = "burn"      // Loses 1/16 of its HP every turn and deals half physical damage
+	statusPoison    = "poison"    // Loses 1/8 of its HP every turn
+	statusParalysis = "paralysis" // Half speed, and a chance to be unable to move
+	statusSleep     = "sleep"     // Can't move for 1 to 3 turns
+	statusFreeze    = "freeze"    // Can't move until it thaws
+)
+
+// statusTags are the short forms shown next to a Pokémon's name.
+var statusTags = map[string]string{
+	statusBurn:      "BRN",
+	statusPoison:    "PSN",
+	statusParalysis: "PAR",
+	statusSleep:     "SLP",
+	statusFreeze:    "FRZ",
+}
+
+// statusImmunities lists the types that can't get a status condition.
+var statusImmunities = map[string][]string{
+	statusBurn:      {"fire"},
+	statusPoison:    {"poison", "steel"},
+	statusParalysis: {"electric"},
+	statusFreeze:    {"ice"},
+}
+
+// Chances of status conditions taking effect.
+const (
+	paralysisSkipChance = 0.25 // A paralyzed Pokémon can't move
+	thawChance          = 0.2  // A frozen Pokémon thaws out at the start of its move
+	maxSleepTurns       = 3
+)
+
+// statusTag returns the tag of a status to append to a Pokémon's name, e.g. " [PSN]".
+func statusTag(status string) string {
+	if status == "" {
+		return ""
+	}
+	return " [" + statusTags[status] + "]"
+}
+
+// immuneToStatus reports whether a Pokémon's types protect it from a status.
+func immuneToStatus(p *Pokemon, status string) bool {
+	for _, immune := range statusImmunities[status] {
+		for _, t := range p.Type {
+			if t == immune {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inflictStatus gives a combatant of the given side a status, unless it already has one or is immune.
+func (b *Battle) inflictStatus(side int, c *combatant, status string) bool {
+	if c.status != "" || immuneToStatus(c.pokemon, status) {
+		return false
+	}
+	c.status = status
+	if status == statusSleep {
+		c.sleepTurns = 1 + b.rng.Intn(maxSleepTurns)
+	}
+
+	var text string
+	switch status {
+	case statusBurn:
+		text = "%s was burned!"
+	case statusPoison:
+		text = "%s was poisoned!"
+	case statusParalysis:
+		text = "%s is paralyzed! It may be unable to move!"
+	case statusSleep:
+		text = "%s fell asleep!"
+	case statusFreeze:
+		text = "%s was frozen solid!"
+	}
+	label := b.sides[side].label(c)
+	b.emit(BattleEvent{Kind: "status", Side: side, Pokemon: c.pokemon.Name, Status: status, Text: fmt.Sprintf(text, label)})
+	return true
+}
+
+// cureStatus removes a combatant's status and tells the players why.
+func (b *Battle) cureStatus(side int, c *combatant, text string) {
+	c.status = ""
+	c.sleepTurns = 0
+	b.emit(BattleEvent{Kind: "cure", Side: side, Pokemon: c.pokemon.Name, Text: fmt.Sprintf(text, b.sides[side].label(c))})
+}
+
+// canMove checks whether a combatant's status lets it move this turn.
+func (b *Battle) canMove(side int, c *combatant) bool {
+	label := b.sides[side].label(c)
+	immobile := func(text string) bool {
+		b.emit(BattleEvent{Kind: "immobile", Side: side, Pokemon: c.pokemon.Name, Status: c.status, Text: fmt.Sprintf(text, label)})
+		return false
+	}
+
+	switch c.status {
+	case statusSleep:
+		if c.sleepTurns < 0 {
+			// Fell asleep in an earlier battle.
+			c.sleepTurns = 1 + b.rng.Intn(maxSleepTurns)
+		}
+		if c.sleepTurns > 0 {
+			c.sleepTurns--
+			return immobile("%s is fast asleep.")
+		}
+		b.cureStatus(side, c, "%s woke up!")
+	case statusFreeze:
+		if b.rng.Float64() >= thawChance {
+			return immobile("%s is frozen solid!")
+		}
+		b.cureStatus(side, c, "%s thawed out!")
+	case statusParalysis:
+		if b.rng.Float64() < paralysisSkipChance {
+			return immobile("%s is paralyzed! It can't move!")
+		}
+	}
+	return true
+}
+
+// speed returns the speed a combatant moves with, after its Speed stage; paralysis halves it.
+func (b *Battle) speed(c *combatant) int {
+	speed := c.staged().Speed
+	if c.status == statusParalysis {
+		return speed / 2
+	}
+	return speed
+}
+
+// moveDamage returns the damage of a move with both Pokémon's stat stages and abilities applied;
+// burned Pokémon deal half physical damage.
+func moveDamage(attacker, defender *combatant, move Move) int {
+	if move.damage == nil {
+		return 0
+	}
+	t := attackType(attacker, defender, move)
+	if defender.immuneTo(t) {
+		return 0
+	}
+	damage := move.damage(attacker.staged(), defender.staged())
+	if move.Category == categoryPhysical && attacker.status == statusBurn {
+		damage /= 2
+	}
+	if a := attacker.ability(); a != nil && a.modifyDamage != nil {
+		damage = a.modifyDamage(attacker, t, damage)
+	}
+	return damage
+}
+
+// residualDamage hurts burned and poisoned Pokémon at the end of a turn.
+func (b *Battle) residualDamage() {
+	for side, s := range b.sides {
+		if b.over {
+			return
+		}
+		c := s.current()
+		var damage int
+		var text string
+		switch c.status {
+		case statusBurn:
+			damage, text = c.maxHP/16, "%s is hurt by its burn! (%d/%d HP)"
+		case statusPoison:
+			damage, text = c.maxHP/8, "%s is hurt by poison! (%d/%d HP)"
+		default:
+			continue
+		}
+		if c.fainted() {
+			continue
+		}
+		if damage < 1 {
+			damage = 1
+		}
+		c.hp -= damage
+		if c.hp < 0 {
+			c.hp = 0
+		}
+		b.emit(BattleEvent{Kind: "residual", Side: side, Pokemon: c.pokemon.Name, Status: c.status, Damage: damage, HP: c.hp,
+			Text: fmt.Sprintf(text, s.label(c), c.hp, c.maxHP)})
+		if c.fainted() {
+			b.faint(side, c)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResidualDamage(t *testing.T) {
+	tests := []struct {
+		status string
+		maxHP  int
+		want   int
+	}{
+		{statusBurn, 100, 6},
+		{statusPoison, 100, 12},
+		{statusBurn, 10, 1}, // At least 1
+		{statusPoison, 7, 1},
+		{statusParalysis, 100, 0},
+		{"", 100, 0},
+	}
+	for _, tt := range tests {
+		red := testPokemon("rattata", "")
+		red.HP = tt.maxHP
+		b := testBattle(red, testPokemon("pidgey", ""), newRNG(1))
+		c := b.sides[0].current()
+		c.status = tt.status
+		b.residualDamage()
+		if got := tt.maxHP - c.hp; got != tt.want {
+			t.Errorf("%q at %d HP: lost %d HP, want %d", tt.status, tt.maxHP, got, tt.want)
+		}
+	}
+}
+
+func TestResidualDamageFaints(t *testing.T) {
+	b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), newRNG(1))
+	c := b.sides[0].current()
+	c.status, c.hp = statusPoison, 1
+	b.residualDamage()
+	if !c.fainted() || !b.over || b.winner != 1 || b.outcome != outcomeWin {
+		t.Errorf("poisoned at 1 HP: %d HP, over %v, winner %d, outcome %s", c.hp, b.over, b.winner, b.outcome)
+	}
+}
+
+func TestBurnHalvesPhysicalDamage(t *testing.T) {
+	attacker, defender := newCombatant(testPokemon("charmander", "", "fire")), newCombatant(testPokemon("rattata", ""))
+	attacker.status = statusBurn
+	if got := moveDamage(attacker, defender, fixedMove("normal", categoryPhysical)); got != 20 {
+		t.Errorf("burned physical attack deals %d, want 20", got)
+	}
+	if got := moveDamage(attacker, defender, fixedMove("fire", categorySpecial)); got != 40 {
+		t.Errorf("burned special attack deals %d, want 40", got)
+	}
+}
+
+func TestStatusSkipsTurns(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		sleepTurns int
+		roll       float64
+		moves      bool
+		after      string // Status after the turn
+	}{
+		{"paralyzed and unlucky", statusParalysis, 0, 0.1, false, statusParalysis},
+		{"paralyzed and lucky", statusParalysis, 0, 0.5, true, statusParalysis},
+		{"asleep", statusSleep, 2, 0, false, statusSleep},
+		{"waking up", statusSleep, 0, 0, true, ""},
+		{"asleep since an earlier battle", statusSleep, -1, 0, false, statusSleep}, // Rolls 1 turn, then sleeps it
+		{"frozen", statusFreeze, 0, 0.5, false, statusFreeze},
+		{"thawing out", statusFreeze, 0, 0.1, true, ""},
+		{"healthy", "", 0, 0, true, ""},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), fixedRNG{tt.roll})
+		c := b.sides[0].current()
+		c.status, c.sleepTurns = tt.status, tt.sleepTurns
+		if got := b.canMove(0, c); got != tt.moves || c.status != tt.after {
+			t.Errorf("%s: moves %v with status %q, want %v with %q", tt.name, got, c.status, tt.moves, tt.after)
+		}
+	}
+}
+
+func TestSleepLasts(t *testing.T) {
+	b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), fixedRNG{0.99})
+	c := b.sides[0].current()
+	if !b.inflictStatus(0, c, statusSleep) {
+		t.Fatal("couldn't put a healthy Pokémon to sleep")
+	}
+	for turn := 1; turn <= maxSleepTurns; turn++ {
+		if b.canMove(0, c) {
+			t.Fatalf("moved on turn %d of %d asleep", turn, maxSleepTurns)
+		}
+	}
+	if !b.canMove(0, c) || c.status != "" {
+		t.Errorf("still asleep after %d turns", maxSleepTurns)
+	}
+}
+
+func TestParalysisHalvesSpeed(t *testing.T) {
+	b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), newRNG(1))
+	c := b.sides[0].current()
+	c.status = statusParalysis
+	if got := b.speed(c); got != 25 {
+		t.Errorf("paralyzed speed %d, want 25", got)
+	}
+}
+
+func TestStatusImmunities(t *testing.T) {
+	tests := []struct {
+		status string
+		types  []string
+		ok     bool
+	}{
+		{statusBurn, []string{"fire"}, false},
+		{statusBurn, []string{"water"}, true},
+		{statusPoison, []string{"grass", "poison"}, false},
+		{statusPoison, []string{"steel"}, false},
+		{statusParalysis, []string{"electric"}, false},
+		{statusFreeze, []string{"ice"}, false},
+		{statusSleep, []string{"fire"}, true},
+	}
+	for _, tt := range tests {
+		b := testBattle(testPokemon("target", "", tt.types...), testPokemon("pidgey", ""), newRNG(1))
+		c := b.sides[0].current()
+		if got := b.inflictStatus(0, c, tt.status); got != tt.ok {
+			t.Errorf("%s on %v: inflicted %v, want %v", tt.status, tt.types, got, tt.ok)
+		}
+	}
+
+	// A Pokémon has one status at a time.
+	b := testBattle(testPokemon("rattata", ""), testPokemon("pidgey", ""), newRNG(1))
+	c := b.sides[0].current()
+	c.status = statusPoison
+	if b.inflictStatus(0, c, statusBurn) || c.status != statusPoison {
+		t.Errorf("burned a poisoned Pokémon: status %q", c.status)
+	}
+}
+
+// TestStatusCarriesOver battles with a poisoned and a sleeping Pokémon and checks that both keep
+// their status, and the poisoned one the HP it lost, in the next battle.
+func TestStatusCarriesOver(t *testing.T) {
+	red, blue := testPokemon("rattata", ""), testPokemon("pidgey", "")
+	red.HP = 1000
+	red.Status, blue.Status = statusPoison, statusSleep
+	b := testBattle(red, blue, fixedRNG{0.99})
+	b.rules = BattleRules{MaxTurns: 2}
+	b.run(context.Background())
+
+	if red.Status != statusPoison || red.HPLost != 2*125 {
+		t.Errorf("after the battle red is %q and lost %d HP, want poison and %d", red.Status, red.HPLost, 2*125)
+	}
+	if blue.Status != statusSleep {
+		t.Errorf("after the battle blue is %q, want asleep", blue.Status)
+	}
+
+	next := testBattle(red, blue, fixedRNG{0.99})
+	poisoned, asleep := next.sides[0].current(), next.sides[1].current()
+	if poisoned.status != statusPoison || poisoned.hp != 1000-2*125 {
+		t.Errorf("next battle starts with red %q at %d HP", poisoned.status, poisoned.hp)
+	}
+	if asleep.status != statusSleep || asleep.sleepTurns != -1 {
+		t.Errorf("next battle starts with blue %q for %d turns, want asleep for a new roll", asleep.status, asleep.sleepTurns)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// playerDataDir is the default directory of the JSON file store.
+const playerDataDir = "player_data"
+
+// defaultStoreSpec selects the store used when none is configured.
+const defaultStoreSpec = "file:" + playerDataDir
+
+// errNoPlayerData is returned by Store.LoadPlayer when a player has never been saved.
+var errNoPlayerData = errors.New("no saved data for player")
+
+// Store persists players, their collections and battle history, and the state of the world.
+type Store interface {
+	// LoadPlayer returns the saved data of a player, or errNoPlayerData.
+	LoadPlayer(id int) (*PlayerData, error)
+	// SavePlayer replaces all saved data of a player.
+	SavePlayer(data *PlayerData) error
+	// AddCapture adds a single Pokémon to a player's collection.
+	AddCapture(id int, pokemon SavedPokemon) error
+	// AppendBattle adds a single entry to a player's battle history.
+	AppendBattle(id int, record BattleRecord) error
+	// PlayerIDs lists every player with saved data.
+	PlayerIDs() ([]int, error)
+	// LoadAccount returns the account with the given username, or errNoAccount.
+	LoadAccount(username string) (*Account, error)
+	// CreateAccount stores a new account and assigns it an unused PlayerID.
+	// It returns errAccountExists if the username is taken.
+	CreateAccount(account *Account) error
+	// SaveAccount stores an account as is, replacing any existing one.
+	SaveAccount(account *Account) error
+	// Usernames lists every registered account.
+	Usernames() ([]string, error)
+	// LoadWorld returns the saved world state, or nil if there is none.
+	LoadWorld() (*WorldState, error)
+	// SaveWorld replaces the saved world state.
+	SaveWorld(state *WorldState) error
+	// RecordTrade saves the players changed by a trade, if any, and adds the trade to the
+	// audit log, as one change: a crash can't leave a trade saved for one player only.
+	RecordTrade(record TradeRecord, players ...*PlayerData) error
+	// Trades returns the trade audit log, oldest first.
+	Trades() ([]TradeRecord, error)
+	// Close flushes and releases the store.
+	Close() error
+}
+
+// WorldState is the persisted state of the Pokeworld.
+type WorldState struct {
+	NextSpawn time.Time
+	Wild      []WildPokemon
+}
+
+// WildPokemon is a spawned Pokémon waiting in the world.
+type WildPokemon struct {
+	X, Y      int
+	Pokemon   SavedPokemon
+	DespawnAt time.Time
+}
+
+// store is the storage backend used by the server.
+var store Store = newFileStore(playerDataDir)
+
+// openStore opens a store from a spec of the form "file:<directory>" or "db:<file>".
+func openStore(spec string) (Store, error) {
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok || path == "" {
+		return nil, fmt.Errorf("invalid store %q, expected file:<directory> or db:<file>", spec)
+	}
+	switch kind {
+	case "file":
+		// Complete a trade the server was saving when it stopped.
+		fs := newFileStore(path)
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		if err := fs.finishTrade(); err != nil {
+			return nil, err
+		}
+		return fs, nil
+	case "db":
+		return openDBStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store type %q", kind)
+	}
+}
+
+// migrateStore copies every account, player, the world state and the trade audit log from src
+// into dst.
+func migrateStore(src, dst Store) error {
+	usernames, err := src.Usernames()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		account, err := src.LoadAccount(username)
+		if err != nil {
+			return fmt.Errorf("loading account %s: %v", username, err)
+		}
+		if err := dst.SaveAccount(account); err != nil {
+			return fmt.Errorf("saving account %s: %v", username, err)
+		}
+	}
+
+	ids, err := src.PlayerIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		data, err := src.LoadPlayer(id)
+		if err != nil {
+			return fmt.Errorf("loading player %d: %v", id, err)
+		}
+		if err := dst.SavePlayer(data); err != nil {
+			return fmt.Errorf("saving player %d: %v", id, err)
+		}
+	}
+
+	state, err := src.LoadWorld()
+	if err != nil {
+		return fmt.Errorf("loading world: %v", err)
+	}
+	if state != nil {
+		if err := dst.SaveWorld(state); err != nil {
+			return fmt.Errorf("saving world: %v", err)
+		}
+	}
+
+	trades, err := src.Trades()
+	if err != nil {
+		return fmt.Errorf("loading trades: %v", err)
+	}
+	for _, record := range trades {
+		if err := dst.RecordTrade(record); err != nil {
+			return fmt.Errorf("saving trade: %v", err)
+		}
+	}
+	return nil
+}
+
+// fileStore keeps one JSON file per player and one for the world in a directory, and the trade
+// audit log as one JSON line per trade.
+type fileStore struct {
+	dir string
+	mu  sync.Mutex // Serializes read-modify-write cycles on player files
+}
+
+func newFileStore(dir string) *fileStore {
+	return &fileStore{dir: dir}
+}
+
+// playerFilePattern matches the names of player files inside the store directory.
+var playerFilePattern = regexp.MustCompile(`^player(\d+)_data\.json$`)
+
+func (fs *fileStore) playerPath(id int) string {
+	return filepath.Join(fs.dir, fmt.Sprintf("player%d_data.json", id))
+}
+
+func (fs *fileStore) accountPath(username string) string {
+	return filepath.Join(fs.dir, "accounts", strings.ToLower(username)+".json")
+}
+
+func (fs *fileStore) worldPath() string {
+	return filepath.Join(fs.dir, "world.json")
+}
+
+func (fs *fileStore) tradeLogPath() string {
+	return filepath.Join(fs.dir, "trades.jsonl")
+}
+
+func (fs *fileStore) tradeJournalPath() string {
+	return filepath.Join(fs.dir, "trade-pending.json")
+}
+
+func (fs *fileStore) LoadPlayer(id int) (*PlayerData, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.finishTrade(); err != nil {
+		return nil, err
+	}
+	return fs.loadPlayer(id)
+}
+
+func (fs *fileStore) loadPlayer(id int) (*PlayerData, error) {
+	raw, err := os.ReadFile(fs.playerPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoPlayerData
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePlayerData(raw)
+}
+
+func (fs *fileStore) SavePlayer(data *PlayerData) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.finishTrade(); err != nil {
+		return err
+	}
+	return fs.savePlayer(data)
+}
+
+func (fs *fileStore) savePlayer(data *PlayerData) error {
+	id, err := parsePlayerKey(data.PlayerID)
+	if err != nil {
+		return fmt.Errorf("invalid PlayerID %q: %v", data.PlayerID, err)
+	}
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.playerPath(id), raw, 0644)
+}
+
+// update loads a player (or starts a new one), applies fn and saves the result.
+func (fs *fileStore) update(id int, fn func(data *PlayerData)) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.finishTrade(); err != nil {
+		return err
+	}
+
+	data, err := fs.loadPlayer(id)
+	if err == errNoPlayerData {
+		data = &PlayerData{SchemaVersion: currentSchemaVersion, PlayerID: playerKey(id)}
+	} else if err != nil {
+		return err
+	}
+	fn(data)
+	return fs.savePlayer(data)
+}
+
+func (fs *fileStore) AddCapture(id int, pokemon SavedPokemon) error {
+	return fs.update(id, func(data *PlayerData) {
+		data.CapturedPokemon = append(data.CapturedPokemon, pokemon)
+	})
+}
+
+func (fs *fileStore) AppendBattle(id int, record BattleRecord) error {
+	return fs.update(id, func(data *PlayerData) {
+		data.BattleHistory = append(data.BattleHistory, record)
+	})
+}
+
+func (fs *fileStore) PlayerIDs() ([]int, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		if m := playerFilePattern.FindStringSubmatch(entry.Name()); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (fs *fileStore) LoadAccount(username string) (*Account, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.loadAccount(username)
+}
+
+func (fs *fileStore) loadAccount(username string) (*Account, error) {
+	raw, err := os.ReadFile(fs.accountPath(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	var account Account
+	if err := json.Unmarshal(raw, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (fs *fileStore) CreateAccount(account *Account) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, err := fs.loadAccount(account.Username); err != errNoAccount {
+		if err == nil {
+			return errAccountExists
+		}
+		return err
+	}
+
+	// The new account gets the ID after the highest one used by any account or player file.
+	ids, err := fs.PlayerIDs()
+	if err != nil {
+		return err
+	}
+	usernames, err := fs.Usernames()
+	if err != nil {
+		return err
+	}
+	next := 1
+	for _, id := range ids {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	for _, username := range usernames {
+		other, err := fs.loadAccount(username)
+		if err != nil {
+			return err
+		}
+		if other.PlayerID >= next {
+			next = other.PlayerID + 1
+		}
+	}
+
+	account.PlayerID = next
+	return fs.saveAccount(account)
+}
+
+func (fs *fileStore) SaveAccount(account *Account) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.saveAccount(account)
+}
+
+func (fs *fileStore) saveAccount(account *Account) error {
+	raw, err := json.MarshalIndent(account, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Account files hold password hashes, so they are only readable by the server.
+	return writeFileAtomic(fs.accountPath(account.Username), raw, 0600)
+}
+
+func (fs *fileStore) Usernames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(fs.dir, "accounts"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var usernames []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			usernames = append(usernames, name)
+		}
+	}
+	return usernames, nil
+}
+
+func (fs *fileStore) LoadWorld() (*WorldState, error) {
+	raw, err := os.ReadFile(fs.worldPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state WorldState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (fs *fileStore) SaveWorld(state *WorldState) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.worldPath(), raw, 0644)
+}
+
+// tradeJournal is everything a trade writes to a fileStore. It is saved in a single file before
+// the player files are touched, so that a trade cut short by a crash can be completed.
+type tradeJournal struct {
+	Trade   TradeRecord
+	Players []*PlayerData
+}
+
+// RecordTrade writes the trade to the journal, which takes a single atomic write, and then
+// applies it: the players are saved and the trade is logged. Once the journal is written the
+// trade is saved; if applying it fails, e.g. in a crash, it is applied again before the store
+// is next used, and an error is reported then.
+func (fs *fileStore) RecordTrade(record TradeRecord, players ...*PlayerData) error {
+	for _, data := range players {
+		if _, err := parsePlayerKey(data.PlayerID); err != nil {
+			return fmt.Errorf("invalid PlayerID %q: %v", data.PlayerID, err)
+		}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.finishTrade(); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(tradeJournal{Trade: record, Players: players})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(fs.tradeJournalPath(), raw, 0644); err != nil {
+		return err
+	}
+	fs.finishTrade() // On failure, the next use of the store tries again and reports the error
+	return nil
+}
+
+// finishTrade applies the trade in the journal, if there is one, and removes the journal.
+// Applying a trade twice saves the same files again and logs it once, so a crash while
+// applying it does no harm. The caller must hold fs.mu.
+func (fs *fileStore) finishTrade() error {
+	raw, err := os.ReadFile(fs.tradeJournalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var journal tradeJournal
+	if err := json.Unmarshal(raw, &journal); err != nil {
+		return fmt.Errorf("%s: %v", fs.tradeJournalPath(), err)
+	}
+	for _, data := range journal.Players {
+		if err := fs.savePlayer(data); err != nil {
+			return fmt.Errorf("completing trade: %v", err)
+		}
+	}
+	line, err := json.Marshal(journal.Trade)
+	if err != nil {
+		return err
+	}
+	if err := fs.appendTrade(line); err != nil {
+		return fmt.Errorf("completing trade: %v", err)
+	}
+	return os.Remove(fs.tradeJournalPath())
+}
+
+// appendTrade adds a line to the trade log and flushes it to disk, unless it is already the last
+// line of the log.
+func (fs *fileStore) appendTrade(line []byte) error {
+	line = append(line, '\n')
+	if err := os.MkdirAll(fs.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fs.tradeLogPath(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if size := info.Size(); size >= int64(len(line)) {
+		last := make([]byte, len(line))
+		if _, err := file.ReadAt(last, size-int64(len(line))); err != nil {
+			file.Close()
+			return err
+		}
+		if bytes.Equal(last, line) {
+			return file.Close()
+		}
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (fs *fileStore) Trades() ([]TradeRecord, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.finishTrade(); err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(fs.tradeLogPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var trades []TradeRecord
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record TradeRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("%s: %v", fs.tradeLogPath(), err)
+		}
+		trades = append(trades, record)
+	}
+	return trades, nil
+}
+
+func (fs *fileStore) Close() error {
+	return nil
+}
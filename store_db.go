@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// dbCompactMinRecords is the smallest log size at which dbStore considers compacting.
+const dbCompactMinRecords = 1000
+
+// dbRecord is one entry of the dbStore log.
+type dbRecord struct {
+	Op   string          `json:"op"` // "player", "capture", "battle", "account", "world" or "trade"
+	ID   int             `json:"id,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+// dbTrade is the data of a "trade" record: an entry of the audit log together with the players
+// the trade changed, so that both sides of a trade are saved at once.
+type dbTrade struct {
+	Trade   TradeRecord
+	Players []*PlayerData `json:",omitempty"`
+}
+
+// dbStore is an embedded database kept in a single append-only file.
+// Every change is appended as one record and synced, so a capture costs one small write
+// instead of rewriting the player's whole file. The full state is held in memory,
+// rebuilt from the log on open, and the log is compacted into a snapshot once it
+// grows well past the number of live entries.
+type dbStore struct {
+	path     string
+	file     *os.File
+	records  int // Records in the log since the last compaction
+	players  map[int]*PlayerData
+	accounts map[string]*Account // Accounts by lower-cased username
+	world    *WorldState
+	trades   []TradeRecord
+	mu       sync.Mutex
+}
+
+// openDBStore opens or creates the database file at path.
+func openDBStore(path string) (*dbStore, error) {
+	db := &dbStore{path: path, players: make(map[int]*PlayerData), accounts: make(map[string]*Account)}
+	if err := db.replay(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600) // Holds password hashes
+	if err != nil {
+		return nil, err
+	}
+	db.file = file
+	return db, nil
+}
+
+// replay rebuilds the in-memory state from the log.
+// A partially written last record, left by a crash, is discarded.
+func (db *dbStore) replay() error {
+	file, err := os.Open(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var valid int64 // Offset just past the last complete record
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var record dbRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("%s: corrupt record at offset %d: %v", db.path, valid, err)
+		}
+		if err := db.apply(record); err != nil {
+			return fmt.Errorf("%s: record at offset %d: %v", db.path, valid, err)
+		}
+		valid += int64(len(line))
+		db.records++
+	}
+	return os.Truncate(db.path, valid)
+}
+
+// apply updates the in-memory state with a single record.
+func (db *dbStore) apply(record dbRecord) error {
+	switch record.Op {
+	case "player":
+		data, err := decodePlayerData(record.Data)
+		if err != nil {
+			return err
+		}
+		id, err := parsePlayerKey(data.PlayerID)
+		if err != nil {
+			return err
+		}
+		db.players[id] = data
+	case "capture":
+		var pokemon SavedPokemon
+		if err := json.Unmarshal(record.Data, &pokemon); err != nil {
+			return err
+		}
+		data := db.player(record.ID)
+		data.CapturedPokemon = append(data.CapturedPokemon, pokemon)
+	case "battle":
+		var battle BattleRecord
+		if err := json.Unmarshal(record.Data, &battle); err != nil {
+			return err
+		}
+		data := db.player(record.ID)
+		data.BattleHistory = append(data.BattleHistory, battle)
+	case "account":
+		var account Account
+		if err := json.Unmarshal(record.Data, &account); err != nil {
+			return err
+		}
+		db.accounts[strings.ToLower(account.Username)] = &account
+	case "world":
+		var state WorldState
+		if err := json.Unmarshal(record.Data, &state); err != nil {
+			return err
+		}
+		db.world = &state
+	case "trade":
+		var trade dbTrade
+		if err := json.Unmarshal(record.Data, &trade); err != nil {
+			return err
+		}
+		for _, data := range trade.Players {
+			id, err := parsePlayerKey(data.PlayerID)
+			if err != nil {
+				return err
+			}
+			db.players[id] = data
+		}
+		db.trades = append(db.trades, trade.Trade)
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+	return nil
+}
+
+// player returns the in-memory data of a player, creating it if needed.
+func (db *dbStore) player(id int) *PlayerData {
+	data, ok := db.players[id]
+	if !ok {
+		data = &PlayerData{SchemaVersion: currentSchemaVersion, PlayerID: playerKey(id)}
+		db.players[id] = data
+	}
+	return data
+}
+
+// write appends a record to the log, syncs it and applies it to the in-memory state.
+// A record is tried on an empty store before it is written, because one that can't be applied
+// would stay in the log and make every later replay fail.
+func (db *dbStore) write(op string, id int, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	record := dbRecord{Op: op, ID: id, Data: raw}
+	check := &dbStore{players: make(map[int]*PlayerData), accounts: make(map[string]*Account)}
+	if err := check.apply(record); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := db.file.Write(append(line, '\n')); err != nil {
+		// Don't leave part of a record for the next one to be appended to.
+		if err := db.file.Truncate(info.Size()); err != nil {
+			log.Printf("Error truncating %s: %v", db.path, err)
+		}
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		return err
+	}
+	if err := db.apply(record); err != nil {
+		return err
+	}
+	db.records++
+
+	if db.records >= dbCompactMinRecords && db.records > 4*(len(db.players)+len(db.trades)+1) {
+		return db.compact()
+	}
+	return nil
+}
+
+// compact replaces the log with one record per player, account and trade plus the world state.
+func (db *dbStore) compact() error {
+	var buf bytes.Buffer
+	records := 0
+	add := func(op string, id int, value interface{}) error {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(dbRecord{Op: op, ID: id, Data: raw})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		records++
+		return nil
+	}
+
+	for _, id := range db.sortedIDs() {
+		if err := add("player", id, db.players[id]); err != nil {
+			return err
+		}
+	}
+	for _, username := range db.sortedUsernames() {
+		if err := add("account", 0, db.accounts[username]); err != nil {
+			return err
+		}
+	}
+	if db.world != nil {
+		if err := add("world", 0, db.world); err != nil {
+			return err
+		}
+	}
+	for _, trade := range db.trades {
+		if err := add("trade", 0, dbTrade{Trade: trade}); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(db.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	db.file.Close()
+	db.file = file
+	db.records = records
+	return nil
+}
+
+func (db *dbStore) sortedIDs() []int {
+	ids := make([]int, 0, len(db.players))
+	for id := range db.players {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (db *dbStore) sortedUsernames() []string {
+	usernames := make([]string, 0, len(db.accounts))
+	for username := range db.accounts {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// clonePlayerData returns a deep copy so callers cannot modify the in-memory state.
+func clonePlayerData(data *PlayerData) (*PlayerData, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var clone PlayerData
+	err = json.Unmarshal(raw, &clone)
+	return &clone, err
+}
+
+func (db *dbStore) LoadPlayer(id int) (*PlayerData, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	data, ok := db.players[id]
+	if !ok {
+		return nil, errNoPlayerData
+	}
+	return clonePlayerData(data)
+}
+
+func (db *dbStore) SavePlayer(data *PlayerData) error {
+	id, err := parsePlayerKey(data.PlayerID)
+	if err != nil {
+		return fmt.Errorf("invalid PlayerID %q: %v", data.PlayerID, err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("player", id, data)
+}
+
+func (db *dbStore) AddCapture(id int, pokemon SavedPokemon) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("capture", id, pokemon)
+}
+
+func (db *dbStore) AppendBattle(id int, record BattleRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("battle", id, record)
+}
+
+func (db *dbStore) PlayerIDs() ([]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.sortedIDs(), nil
+}
+
+func (db *dbStore) LoadAccount(username string) (*Account, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	account, ok := db.accounts[strings.ToLower(username)]
+	if !ok {
+		return nil, errNoAccount
+	}
+	clone := *account
+	return &clone, nil
+}
+
+func (db *dbStore) CreateAccount(account *Account) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.accounts[strings.ToLower(account.Username)]; ok {
+		return errAccountExists
+	}
+	// The new account gets the ID after the highest one used by any account or player.
+	next := 1
+	for id := range db.players {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	for _, other := range db.accounts {
+		if other.PlayerID >= next {
+			next = other.PlayerID + 1
+		}
+	}
+	account.PlayerID = next
+	return db.write("account", 0, account)
+}
+
+func (db *dbStore) SaveAccount(account *Account) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("account", 0, account)
+}
+
+func (db *dbStore) Usernames() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.sortedUsernames(), nil
+}
+
+func (db *dbStore) LoadWorld() (*WorldState, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.world == nil {
+		return nil, nil
+	}
+	state := *db.world
+	state.Wild = append([]WildPokemon(nil), db.world.Wild...)
+	return &state, nil
+}
+
+func (db *dbStore) SaveWorld(state *WorldState) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("world", 0, state)
+}
+
+func (db *dbStore) RecordTrade(record TradeRecord, players ...*PlayerData) error {
+	for _, data := range players {
+		if _, err := parsePlayerKey(data.PlayerID); err != nil {
+			return fmt.Errorf("invalid PlayerID %q: %v", data.PlayerID, err)
+		}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write("trade", 0, dbTrade{Trade: record, Players: players})
+}
+
+func (db *dbStore) Trades() ([]TradeRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]TradeRecord(nil), db.trades...), nil
+}
+
+func (db *dbStore) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.file.Close()
+}
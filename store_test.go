@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTrade returns a trade of a rattata from player 1 for a magikarp from player 2, with the
+// data of both players after it.
+func testTrade() (TradeRecord, []*PlayerData) {
+	rattata, magikarp := toSavedPokemon(1, testPokedex[0]), toSavedPokemon(1, testPokedex[1])
+	record := TradeRecord{
+		Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Players:   [2]string{playerKey(1), playerKey(2)},
+		Usernames: [2]string{"ash", "misty"},
+		Offers:    [2][]SavedPokemon{{rattata}, {magikarp}},
+		Received:  [2][]int{{2}, {2}},
+		Result:    tradeCompleted,
+	}
+	magikarp.ID, rattata.ID = 2, 2
+	players := []*PlayerData{
+		{SchemaVersion: currentSchemaVersion, PlayerID: playerKey(1), CapturedPokemon: []SavedPokemon{magikarp}},
+		{SchemaVersion: currentSchemaVersion, PlayerID: playerKey(2), CapturedPokemon: []SavedPokemon{rattata}},
+	}
+	return record, players
+}
+
+// checkTraded verifies that both players of testTrade are saved and the trade is logged once.
+func checkTraded(t *testing.T, s Store) {
+	t.Helper()
+	for id, want := range map[int]string{1: "magikarp", 2: "rattata"} {
+		data, err := s.LoadPlayer(id)
+		if err != nil {
+			t.Fatalf("player %d: %v", id, err)
+		}
+		if len(data.CapturedPokemon) != 1 || data.CapturedPokemon[0].Name != want {
+			t.Errorf("player %d has %+v, want the traded %s", id, data.CapturedPokemon, want)
+		}
+	}
+	trades, err := s.Trades()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 || trades[0].Result != tradeCompleted {
+		t.Errorf("trade log %+v, want the trade once", trades)
+	}
+}
+
+func TestFileStoreRecordTrade(t *testing.T) {
+	fs := newFileStore(t.TempDir())
+	record, players := testTrade()
+	if err := fs.RecordTrade(record, players...); err != nil {
+		t.Fatal(err)
+	}
+	checkTraded(t, fs)
+	if _, err := os.Stat(fs.tradeJournalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal left behind: %v", err)
+	}
+}
+
+// TestFileStoreTradeRecovery stops a trade at every point after its journal is written and
+// checks that opening the store completes it.
+func TestFileStoreTradeRecovery(t *testing.T) {
+	record, players := testTrade()
+	journal, err := json.Marshal(tradeJournal{Trade: record, Players: players})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		applied func(fs *fileStore) error // What was written before the crash
+	}{
+		{"nothing applied", func(fs *fileStore) error { return nil }},
+		{"one player saved", func(fs *fileStore) error { return fs.savePlayer(players[0]) }},
+		{"both players saved", func(fs *fileStore) error {
+			if err := fs.savePlayer(players[0]); err != nil {
+				return err
+			}
+			return fs.savePlayer(players[1])
+		}},
+		{"trade logged", func(fs *fileStore) error {
+			for _, data := range players {
+				if err := fs.savePlayer(data); err != nil {
+					return err
+				}
+			}
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			return fs.appendTrade(line)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fs := newFileStore(dir)
+			// The players as they were before the trade.
+			for i, data := range players {
+				before := *data
+				before.CapturedPokemon = []SavedPokemon{toSavedPokemon(1, testPokedex[i])}
+				if err := fs.savePlayer(&before); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeFileAtomic(fs.tradeJournalPath(), journal, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.applied(fs); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := openStore("file:" + dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTraded(t, s)
+			if _, err := os.Stat(fs.tradeJournalPath()); !os.IsNotExist(err) {
+				t.Errorf("journal left behind: %v", err)
+			}
+		})
+	}
+}
+
+// fillStore saves an account, a player with a capture and a battle, the world and a trade.
+func fillStore(t *testing.T, s Store) {
+	t.Helper()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	record, _ := testTrade()
+	world := &WorldState{NextSpawn: at, Wild: []WildPokemon{{X: 2, Y: 3, Pokemon: toSavedPokemon(0, testPokedex[1]), DespawnAt: at}}}
+	for _, err := range []error{
+		s.SaveAccount(&Account{Username: "Ash", PlayerID: 1, Iterations: 1, Created: at}),
+		s.SavePlayer(&PlayerData{SchemaVersion: currentSchemaVersion, PlayerID: playerKey(1)}),
+		s.AddCapture(1, toSavedPokemon(1, testPokedex[0])),
+		s.AppendBattle(1, BattleRecord{OpponentID: playerKey(2), Result: resultDraw, Timestamp: at}),
+		s.SaveWorld(world),
+		s.RecordTrade(record),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFilled verifies that s holds what fillStore saved.
+func checkFilled(t *testing.T, s Store) {
+	t.Helper()
+	account, err := s.LoadAccount("ash")
+	if err != nil || account.Username != "Ash" || account.PlayerID != 1 {
+		t.Errorf("account %+v, %v; want Ash with player 1", account, err)
+	}
+	data, err := s.LoadPlayer(1)
+	if err != nil {
+		t.Fatalf("player 1: %v", err)
+	}
+	if len(data.CapturedPokemon) != 1 || data.CapturedPokemon[0].Name != "rattata" {
+		t.Errorf("player 1 has %+v, want the rattata", data.CapturedPokemon)
+	}
+	if len(data.BattleHistory) != 1 || data.BattleHistory[0].Result != resultDraw {
+		t.Errorf("player 1 battle history %+v, want the draw", data.BattleHistory)
+	}
+	state, err := s.LoadWorld()
+	if err != nil || state == nil || len(state.Wild) != 1 || state.Wild[0].Pokemon.Name != "magikarp" {
+		t.Errorf("world %+v, %v; want the magikarp", state, err)
+	}
+	trades, err := s.Trades()
+	if err != nil || len(trades) != 1 || trades[0].Usernames != [2]string{"ash", "misty"} {
+		t.Errorf("trade log %+v, %v; want the trade once", trades, err)
+	}
+}
+
+func TestDBStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokecat.db")
+	db, err := openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db)
+	checkFilled(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFilled(t, db)
+	if db.records != 6 {
+		t.Errorf("%d records replayed, want 6", db.records)
+	}
+}
+
+func TestDBStoreReplayDiscardsPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokecat.db")
+	db, err := openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db)
+	db.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash in the middle of writing a record.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"capture","id":1,"da`)
+	file.Close()
+
+	db, err = openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFilled(t, db)
+	if err := db.AddCapture(1, toSavedPokemon(2, testPokedex[2])); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if after, err := os.Stat(path); err != nil || after.Size() <= info.Size() {
+		t.Fatalf("log of %d bytes after another capture, had %d: %v", after.Size(), info.Size(), err)
+	}
+
+	db, err = openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if data, err := db.LoadPlayer(1); err != nil || len(data.CapturedPokemon) != 2 {
+		t.Errorf("player 1 after the capture that followed the crash: %+v, %v", data, err)
+	}
+}
+
+// TestDBStoreRejectsRecordItCannotApply saves player data the store can't read back and checks
+// that the save fails without breaking the log.
+func TestDBStoreRejectsRecordItCannotApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokecat.db")
+	db, err := openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db)
+	newer := &PlayerData{SchemaVersion: currentSchemaVersion + 1, PlayerID: playerKey(1)}
+	if err := db.SavePlayer(newer); err == nil {
+		t.Error("saved player data with a newer schema version")
+	}
+	checkFilled(t, db)
+	db.Close()
+
+	db, err = openDBStore(path)
+	if err != nil {
+		t.Fatalf("reopening after a rejected record: %v", err)
+	}
+	defer db.Close()
+	checkFilled(t, db)
+}
+
+func TestDBStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pokecat.db")
+	db, err := openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db)
+	state, err := db.LoadWorld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < dbCompactMinRecords; i++ {
+		if err := db.SaveWorld(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One record each for the player, the account, the world and the trade, and what came after.
+	if db.records >= dbCompactMinRecords {
+		t.Errorf("%d records in the log, want it compacted", db.records)
+	}
+	checkFilled(t, db)
+	db.Close()
+
+	db, err = openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkFilled(t, db)
+	if db.records >= dbCompactMinRecords {
+		t.Errorf("%d records replayed, want the compacted log", db.records)
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	dir := t.TempDir()
+	files := newFileStore(filepath.Join(dir, "files"))
+	fillStore(t, files)
+
+	db, err := openDBStore(filepath.Join(dir, "pokecat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateStore(files, db); err != nil {
+		t.Fatalf("file to db: %v", err)
+	}
+	checkFilled(t, db)
+
+	back := newFileStore(filepath.Join(dir, "back"))
+	if err := migrateStore(db, back); err != nil {
+		t.Fatalf("db to file: %v", err)
+	}
+	checkFilled(t, back)
+}
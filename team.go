@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// teamSize is the number of Pokémon a player brings into a battle.
+const teamSize = 3
+
+// Battle formats. In the collection format players battle with Pokémon they own;
+// in the draft format they pick freely from the whole Pokédex.
+const (
+	formatCollection = "collection"
+	formatDraft      = "draft"
+)
+
+// chooseTeam lets the client pick their team for a battle in the given format.
+func chooseTeam(client *Client, format string, pokedex []Pokemon) error {
+	if format == formatDraft {
+		return chooseDraftTeam(client, pokedex)
+	}
+	return chooseCollectionTeam(client)
+}
+
+// chooseDraftTeam lets the client pick any Pokémon from the Pokédex.
+func chooseDraftTeam(client *Client, pokedex []Pokemon) error {
+	conn := client.conn
+	fmt.Fprintf(conn, "Choose %d Pokémon for your team:\n", teamSize)
+	for i, p := range pokedex {
+		fmt.Fprintf(conn, "%d. %s (%v)\n", i+1, p.Name, p.Type)
+	}
+	// Loop to get the player's team choices.
+	for i := 0; i < teamSize; i++ {
+		fmt.Fprint(conn, "Enter number for Pokémon: ")
+		input, err := client.reader.ReadString('\n') // Read the player's choice.
+		if err != nil {
+			return err
+		}
+
+		// Convert input to integer and validate the choice.
+		choice, err := strconv.Atoi(strings.TrimSpace(input))
+		if err != nil || choice < 1 || choice > len(pokedex) {
+			fmt.Fprintln(conn, "Invalid choice. Try again.")
+			i--
+			continue
+		}
+
+		// Add a copy of the chosen Pokémon to the client's team, so battles never touch the Pokédex.
+		pokemon := pokedex[choice-1]
+		pokemon.Owner = client
+		client.team = append(client.team, &pokemon)
+	}
+	client.roster = append([]*Pokemon(nil), client.team...)
+	return nil
+}
+
+// chooseCollectionTeam lets the client pick their team from the Pokémon they own.
+// The saved default team is used when the client just presses Enter.
+func chooseCollectionTeam(client *Client) error {
+	conn := client.conn
+	player := client.player
+
+	player.mutex.Lock()
+	keys := player.collectionKeys()
+	defaultTeam := player.validTeam()
+	fmt.Fprintln(conn, "Your Pokémon:")
+	for _, key := range keys {
+		fmt.Fprintf(conn, "%d. %s\n", key, describePokemon(player.Pokemons[key]))
+	}
+	player.mutex.Unlock()
+
+	for {
+		if len(defaultTeam) > 0 {
+			fmt.Fprintf(conn, "Press Enter to use your default team (%s), or enter up to %d numbers: ", joinKeys(defaultTeam), teamSize)
+		} else {
+			fmt.Fprintf(conn, "Enter up to %d numbers separated by spaces: ", teamSize)
+		}
+		input, err := client.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		var chosen []int
+		if strings.TrimSpace(input) == "" {
+			chosen = defaultTeam
+		} else {
+			chosen, err = parseTeam(player, strings.Fields(input))
+			if err != nil {
+				fmt.Fprintf(conn, "%v. Try again.\n", err)
+				continue
+			}
+		}
+		if len(chosen) == 0 {
+			fmt.Fprintln(conn, "Choose at least one Pokémon.")
+			continue
+		}
+
+		// The team gets copies of the owned Pokémon, with stats matching their level and EV.
+		// Fainted Pokémon stay behind until they are healed.
+		player.mutex.Lock()
+		for _, key := range chosen {
+			pokemon := player.Pokemons[key]
+			if pokemon.fainted() {
+				fmt.Fprintf(conn, "%s has fainted and can't battle.\n", pokemon.Name)
+				continue
+			}
+			recomputeStats(&pokemon)
+			pokemon.Owner = client
+			pokemon.collectionKey = key
+			client.team = append(client.team, &pokemon)
+		}
+		player.mutex.Unlock()
+		if len(client.team) == 0 {
+			fmt.Fprintln(conn, "Choose Pokémon that can still battle.")
+			continue
+		}
+		client.roster = append([]*Pokemon(nil), client.team...)
+		return nil
+	}
+}
+
+// parseTeam validates a list of collection keys chosen as a team.
+func parseTeam(player *Player, args []string) ([]int, error) {
+	if len(args) > teamSize {
+		return nil, fmt.Errorf("a team has at most %d Pokémon", teamSize)
+	}
+
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	var team []int
+	seen := make(map[int]bool)
+	for _, arg := range args {
+		key, err := strconv.Atoi(arg)
+		if err != nil || !player.hasPokemon(key) {
+			return nil, fmt.Errorf("you don't have a Pokémon numbered %q", arg)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("Pokémon %d was chosen twice", key)
+		}
+		seen[key] = true
+		team = append(team, key)
+	}
+	return team, nil
+}
+
+// collectionKeys returns the keys of the player's collection in ascending order.
+// The caller must hold player.mutex.
+func (player *Player) collectionKeys() []int {
+	keys := make([]int, 0, len(player.Pokemons))
+	for key := range player.Pokemons {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// ableKeys returns the keys of the player's Pokémon that have not fainted, in ascending order.
+// The caller must hold player.mutex.
+func (player *Player) ableKeys() []int {
+	var keys []int
+	for _, key := range player.collectionKeys() {
+		if pokemon := player.Pokemons[key]; !pokemon.fainted() {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// validTeam returns the player's default team without Pokémon that are no longer owned.
+// The caller must hold player.mutex.
+func (player *Player) validTeam() []int {
+	var team []int
+	for _, key := range player.Team {
+		if player.hasPokemon(key) {
+			team = append(team, key)
+		}
+	}
+	return team
+}
+
+// describePokemon returns a one-line summary of a Pokémon.
+func describePokemon(p Pokemon) string {
+	name := p.Name
+	if p.Nickname != "" {
+		name = fmt.Sprintf("%s (%s)", p.Nickname, p.Name)
+	}
+	description := fmt.Sprintf("%s Lv.%d (%s", name, p.Level, strings.Join(p.Type, "/"))
+	if ability := abilityName(&p); ability != "" {
+		description += ", " + displayAbility(ability)
+	}
+	description += ")"
+	if p.fainted() {
+		description += " fainted"
+	} else if p.HPLost > 0 {
+		description += fmt.Sprintf(" %d/%d HP", p.currentHP(), p.HP)
+	}
+	if p.HeldItem != "" {
+		description += " @ " + itemName(p.HeldItem)
+	}
+	return description + statusTag(p.Status)
+}
+
+func joinKeys(keys []int) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = strconv.Itoa(key)
+	}
+	return strings.Join(parts, " ")
+}
+
+// cmdTeam shows or changes the player's default team.
+func cmdTeam(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	player := client.player
+
+	if len(args) == 0 {
+		player.mutex.Lock()
+		defer player.mutex.Unlock()
+
+		fmt.Fprintln(conn, "Your Pokémon:")
+		for _, key := range player.collectionKeys() {
+			fmt.Fprintf(conn, "%d. %s\n", key, describePokemon(player.Pokemons[key]))
+		}
+		if team := player.validTeam(); len(team) > 0 {
+			fmt.Fprintf(conn, "Your default team: %s\n", joinKeys(team))
+		} else {
+			fmt.Fprintln(conn, "You have no default team. Use 'team set <number>...' to choose one.")
+		}
+		return
+	}
+
+	if strings.ToLower(args[0]) != "set" || len(args) < 2 {
+		fmt.Fprintln(conn, "Usage: team [set <number>...]")
+		return
+	}
+	team, err := parseTeam(player, args[1:])
+	if err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		return
+	}
+
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	player.Team = team
+	if err := savePlayer(player); err != nil {
+		log.Printf("Error saving data for player %d: %v", player.ID, err)
+	}
+	fmt.Fprintln(conn, "Default team saved.")
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of a trade in the audit log.
+const (
+	tradeCompleted = "completed"
+	tradeRejected  = "rejected" // Both sides confirmed, but the trade could not be carried out
+	tradeCancelled = "cancelled"
+)
+
+// TradeRecord is an entry of the trade audit log.
+type TradeRecord struct {
+	Timestamp time.Time
+	Players   [2]string // Player keys, the player who proposed the trade first
+	Usernames [2]string
+	Offers    [2][]SavedPokemon // Pokémon offered by each side, with their keys in the giver's collection
+	Received  [2][]int          // Keys the Pokémon each side received got in their collection
+	Result    string
+	Reason    string `json:",omitempty"` // Why the trade was rejected or cancelled
+}
+
+// Trade is a trade being negotiated between two clients. Either side can change their offer at
+// any time, which withdraws both confirmations; once both sides have confirmed the current
+// offers, the Pokémon change hands in one step.
+type Trade struct {
+	clients   [2]*Client
+	offers    [2][]int     // Collection keys offered by each side
+	offered   [2][]Pokemon // The offered Pokémon as they were when offered
+	confirmed [2]bool
+}
+
+var (
+	trades     = make(map[*Client]*Trade) // Trade each client is negotiating, if any
+	tradeMutex sync.Mutex
+)
+
+// cmdTrade negotiates a trade with another player who is online.
+func cmdTrade(client *Client, args []string, pokedex []Pokemon) {
+	if len(args) == 0 {
+		showTrade(client)
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "with":
+		if len(args) < 2 {
+			break
+		}
+		proposeTrade(client, args[1], args[2:])
+		return
+	case "offer":
+		offerTrade(client, args[1:])
+		return
+	case "confirm":
+		confirmTrade(client)
+		return
+	case "cancel":
+		if !endTrade(client, client.account.Username+" cancelled it") {
+			fmt.Fprintln(client.conn, "You are not trading with anyone.")
+			return
+		}
+		fmt.Fprintln(client.conn, "Trade cancelled.")
+		return
+	}
+	fmt.Fprintln(client.conn, "Usage: trade [with <player> [number...]|offer [number...]|confirm|cancel]")
+}
+
+// proposeTrade starts a trade with the player logged in as username, offering the Pokémon
+// numbered in args.
+func proposeTrade(client *Client, username string, args []string) {
+	conn := client.conn
+	other := onlineClient(username)
+	if other == nil || other.player == nil {
+		fmt.Fprintf(conn, "%s is not online.\n", username)
+		return
+	}
+	if other == client {
+		fmt.Fprintln(conn, "You can't trade with yourself.")
+		return
+	}
+	keys, offered, err := parseOffer(client.player, args)
+	if err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		return
+	}
+
+	tradeMutex.Lock()
+	defer tradeMutex.Unlock()
+
+	if t := trades[client]; t != nil {
+		fmt.Fprintf(conn, "You are already trading with %s. Type 'trade cancel' first.\n", t.partner(client).account.Username)
+		return
+	}
+	if trades[other] != nil {
+		fmt.Fprintf(conn, "%s is busy with another trade.\n", other.account.Username)
+		return
+	}
+	t := &Trade{clients: [2]*Client{client, other}}
+	t.offers[0], t.offered[0] = keys, offered
+	trades[client] = t
+	trades[other] = t
+
+	fmt.Fprintf(conn, "You proposed a trade to %s. Wait for their offer, then type 'trade confirm'.\n", other.account.Username)
+	fmt.Fprintf(other.conn, "\n%s wants to trade with you, offering:\n", client.account.Username)
+	writeOffer(other.conn, keys, offered)
+	fmt.Fprintln(other.conn, "Type 'trade offer <number>...' to offer Pokémon in return, 'trade confirm' to accept or 'trade cancel' to decline.")
+}
+
+// offerTrade replaces the client's offer in their trade with the Pokémon numbered in args.
+func offerTrade(client *Client, args []string) {
+	conn := client.conn
+	keys, offered, err := parseOffer(client.player, args)
+	if err != nil {
+		fmt.Fprintf(conn, "Error: %v\n", err)
+		return
+	}
+
+	tradeMutex.Lock()
+	defer tradeMutex.Unlock()
+
+	t := trades[client]
+	if t == nil {
+		fmt.Fprintln(conn, "You are not trading with anyone. Use 'trade with <player> [number...]' to start.")
+		return
+	}
+	side := t.side(client)
+	other := t.clients[1-side]
+	t.offers[side], t.offered[side] = keys, offered
+	t.confirmed = [2]bool{}
+
+	fmt.Fprintln(conn, "Your offer:")
+	writeOffer(conn, keys, offered)
+	fmt.Fprintf(other.conn, "\n%s changed their offer to:\n", client.account.Username)
+	writeOffer(other.conn, keys, offered)
+	fmt.Fprintln(other.conn, "Type 'trade confirm' to accept it.")
+}
+
+// confirmTrade accepts the current offers of the client's trade, and carries it out if the
+// other side has accepted them too.
+func confirmTrade(client *Client) {
+	conn := client.conn
+	tradeMutex.Lock()
+	defer tradeMutex.Unlock()
+
+	t := trades[client]
+	if t == nil {
+		fmt.Fprintln(conn, "You are not trading with anyone.")
+		return
+	}
+	if len(t.offers[0]) == 0 && len(t.offers[1]) == 0 {
+		fmt.Fprintln(conn, "Nothing has been offered yet.")
+		return
+	}
+	side := t.side(client)
+	other := t.clients[1-side]
+	t.confirmed[side] = true
+	if !t.confirmed[1-side] {
+		fmt.Fprintf(conn, "You confirmed the trade. Waiting for %s to confirm...\n", other.account.Username)
+		fmt.Fprintf(other.conn, "\n%s confirmed the trade. Type 'trade confirm' to complete it.\n", client.account.Username)
+		return
+	}
+
+	record, err := t.commit()
+	if err != nil {
+		// Both sides can adjust their offers and try again.
+		t.confirmed = [2]bool{}
+		record.Result = tradeRejected
+		record.Reason = err.Error()
+		saveTradeRecord(record)
+		for _, c := range t.clients {
+			fmt.Fprintf(c.conn, "\nThe trade was rejected: %v.\n", err)
+		}
+		return
+	}
+
+	delete(trades, t.clients[0])
+	delete(trades, t.clients[1])
+	for i, c := range t.clients {
+		fmt.Fprintf(c.conn, "\nTrade with %s complete! You received:\n", t.clients[1-i].account.Username)
+		writeOffer(c.conn, record.Received[i], t.offered[1-i])
+	}
+}
+
+// commit moves the offered Pokémon between the collections of both players and saves both
+// players together with the audit record. Nothing changes if it returns an error.
+// The caller must hold tradeMutex.
+func (t *Trade) commit() (TradeRecord, error) {
+	record := t.record()
+	for _, c := range t.clients {
+		// Battles refer to Pokémon by their collection key, which may change hands.
+		if c.battling() {
+			return record, fmt.Errorf("%s is in a battle", c.account.Username)
+		}
+	}
+
+	players := [2]*Player{t.clients[0].player, t.clients[1].player}
+	first, second := players[0], players[1]
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+	first.mutex.Lock()
+	defer first.mutex.Unlock()
+	second.mutex.Lock()
+	defer second.mutex.Unlock()
+
+	limit := currentConfig().World.MaxPokemonPerPlayer
+	for i, player := range players {
+		username := t.clients[i].account.Username
+		for j, key := range t.offers[i] {
+			pokemon, ok := player.Pokemons[key]
+			offered := t.offered[i][j]
+			if !ok || !reflect.DeepEqual(toSavedPokemon(key, pokemon), toSavedPokemon(key, offered)) {
+				return record, fmt.Errorf("%s's %s changed since it was offered", username, offered.Name)
+			}
+		}
+		if n := len(player.Pokemons) - len(t.offers[i]) + len(t.offers[1-i]); n > limit {
+			return record, fmt.Errorf("%s can't hold more than %d Pokémon", username, limit)
+		}
+	}
+
+	// Keep the collections as they were, to put them back if the trade can't be saved.
+	var collections [2]map[int]Pokemon
+	var teams [2][]int
+	for i, player := range players {
+		collections[i] = make(map[int]Pokemon, len(player.Pokemons))
+		for key, pokemon := range player.Pokemons {
+			collections[i][key] = pokemon
+		}
+		teams[i] = player.Team
+	}
+
+	// Take every offered Pokémon out first, then hand them over under new keys.
+	var moving [2][]Pokemon
+	for i, player := range players {
+		for _, key := range t.offers[i] {
+			pokemon := player.Pokemons[key]
+			pokemon.Favorite = false // Favorites are up to the new owner
+			moving[i] = append(moving[i], pokemon)
+			delete(player.Pokemons, key)
+			if timer, pending := player.evolutions[key]; pending {
+				timer.Stop()
+				delete(player.evolutions, key)
+			}
+		}
+		player.Team = player.validTeam()
+	}
+	for i, player := range players {
+		for _, pokemon := range moving[1-i] {
+			key := player.nextPokemonKey()
+			player.Pokemons[key] = pokemon
+			record.Received[i] = append(record.Received[i], key)
+		}
+	}
+
+	record.Result = tradeCompleted
+	if err := store.RecordTrade(record, playerToData(players[0]), playerToData(players[1])); err != nil {
+		log.Printf("Error saving trade between players %d and %d: %v", players[0].ID, players[1].ID, err)
+		// Evolutions stopped above are not restarted; they are tried again at the next level up.
+		for i, player := range players {
+			player.Pokemons = collections[i]
+			player.Team = teams[i]
+		}
+		record.Received = [2][]int{}
+		return record, errors.New("it could not be saved")
+	}
+	return record, nil
+}
+
+// endTrade cancels the client's trade, if any, tells the other side why and logs it. It reports
+// whether there was a trade to cancel.
+func endTrade(client *Client, reason string) bool {
+	tradeMutex.Lock()
+	defer tradeMutex.Unlock()
+
+	t := trades[client]
+	if t == nil {
+		return false
+	}
+	delete(trades, t.clients[0])
+	delete(trades, t.clients[1])
+
+	record := t.record()
+	record.Result = tradeCancelled
+	record.Reason = reason
+	saveTradeRecord(record)
+	fmt.Fprintf(t.partner(client).conn, "\nThe trade was cancelled: %s.\n", reason)
+	return true
+}
+
+// showTrade shows the offers of the client's trade.
+func showTrade(client *Client) {
+	conn := client.conn
+	tradeMutex.Lock()
+	defer tradeMutex.Unlock()
+
+	t := trades[client]
+	if t == nil {
+		fmt.Fprintln(conn, "You are not trading with anyone. Use 'trade with <player> [number...]' to start.")
+		return
+	}
+	side := t.side(client)
+	for _, i := range []int{side, 1 - side} {
+		who := "You offer"
+		if i != side {
+			who = t.clients[i].account.Username + " offers"
+		}
+		if t.confirmed[i] {
+			who += " (confirmed)"
+		}
+		fmt.Fprintf(conn, "%s:\n", who)
+		writeOffer(conn, t.offers[i], t.offered[i])
+	}
+}
+
+// side returns the index of the client in the trade.
+func (t *Trade) side(client *Client) int {
+	if t.clients[0] == client {
+		return 0
+	}
+	return 1
+}
+
+// partner returns the other client of the trade.
+func (t *Trade) partner(client *Client) *Client {
+	return t.clients[1-t.side(client)]
+}
+
+// record returns the audit record of the trade in its current state, without a result.
+func (t *Trade) record() TradeRecord {
+	record := TradeRecord{Timestamp: pokeworld.clock.Now()}
+	for i, c := range t.clients {
+		record.Players[i] = playerKey(c.player.ID)
+		record.Usernames[i] = c.account.Username
+		for j, key := range t.offers[i] {
+			record.Offers[i] = append(record.Offers[i], toSavedPokemon(key, t.offered[i][j]))
+		}
+	}
+	return record
+}
+
+// saveTradeRecord adds a trade that did not change any collection to the audit log.
+func saveTradeRecord(record TradeRecord) {
+	if err := store.RecordTrade(record); err != nil {
+		log.Printf("Error saving trade between %s and %s: %v", record.Players[0], record.Players[1], err)
+	}
+}
+
+// parseOffer validates a list of collection keys offered in a trade and returns them with the
+// Pokémon they refer to.
+func parseOffer(player *Player, args []string) ([]int, []Pokemon, error) {
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+
+	var keys []int
+	var offered []Pokemon
+	seen := make(map[int]bool)
+	for _, arg := range args {
+		key, err := strconv.Atoi(arg)
+		if err != nil || !player.hasPokemon(key) {
+			return nil, nil, fmt.Errorf("you don't have a Pokémon numbered %q", arg)
+		}
+		if seen[key] {
+			return nil, nil, fmt.Errorf("Pokémon %d was offered twice", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+		offered = append(offered, player.Pokemons[key])
+	}
+	return keys, offered, nil
+}
+
+// writeOffer lists the Pokémon of an offer, one per line.
+func writeOffer(w io.Writer, keys []int, offered []Pokemon) {
+	if len(keys) == 0 {
+		fmt.Fprintln(w, "  nothing")
+		return
+	}
+	for i, key := range keys {
+		fmt.Fprintf(w, "  %d. %s\n", key, describePokemon(offered[i]))
+	}
+}
+
+// onlineClient returns the client logged in as username, if any.
+func onlineClient(username string) *Client {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for name, client := range sessions {
+		if strings.EqualFold(name, username) {
+			return client
+		}
+	}
+	return nil
+}
+
+// setBattling records whether the client is taking part in a battle.
+func (client *Client) setBattling(active bool) {
+	client.Lock()
+	defer client.Unlock()
+	client.isActive = active
+}
+
+// battling reports whether the client is taking part in a battle.
+func (client *Client) battling() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.isActive
+}
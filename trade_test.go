@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTradeBetween returns a trade in which two new players offer their only Pokémon.
+func testTradeBetween(a, b Pokemon) *Trade {
+	t := &Trade{}
+	for i, pokemon := range []Pokemon{a, b} {
+		client, _ := testClient(pokemon)
+		client.player.ID = i + 1
+		client.account = &Account{Username: []string{"ash", "misty"}[i], PlayerID: i + 1}
+		t.clients[i] = client
+		t.offers[i] = []int{1}
+		t.offered[i] = []Pokemon{pokemon}
+	}
+	return t
+}
+
+func TestTradeCommit(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	trade := testTradeBetween(testPokedex[0], testPokedex[1])
+	record, err := trade.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ash, misty := trade.clients[0].player, trade.clients[1].player
+	if len(ash.Pokemons) != 1 || ash.Pokemons[record.Received[0][0]].Name != "magikarp" {
+		t.Errorf("ash has %v after the trade, want the magikarp", ash.Pokemons)
+	}
+	if len(misty.Pokemons) != 1 || misty.Pokemons[record.Received[1][0]].Name != "rattata" {
+		t.Errorf("misty has %v after the trade, want the rattata", misty.Pokemons)
+	}
+}
+
+// TestTradeCommitChangedPokemon changes an offered Pokémon in ways that keep its species and
+// level, and checks that the trade is refused and nothing changes hands.
+func TestTradeCommitChangedPokemon(t *testing.T) {
+	useTestWorld(t, newFakeClock(time.Now()))
+	changes := map[string]func(p *Pokemon){
+		"hurt":       func(p *Pokemon) { p.HPLost = 5 },
+		"poisoned":   func(p *Pokemon) { p.Status = statusPoison },
+		"trained":    func(p *Pokemon) { p.AccumExp += 10 },
+		"holding":    func(p *Pokemon) { p.HeldItem = "potion" },
+		"nicknamed":  func(p *Pokemon) { p.Nickname = "Rat" },
+		"new spread": func(p *Pokemon) { p.EVs = []float64{1, 0, 0, 0, 0, 0} },
+	}
+	for name, change := range changes {
+		trade := testTradeBetween(testPokedex[0], testPokedex[1])
+		ash := trade.clients[0].player
+		pokemon := ash.Pokemons[1]
+		change(&pokemon)
+		ash.Pokemons[1] = pokemon
+
+		_, err := trade.commit()
+		if err == nil || !strings.Contains(err.Error(), "changed since it was offered") {
+			t.Errorf("%s: commit returned %v, want the Pokémon to have changed", name, err)
+		}
+		if got := ash.Pokemons[1]; len(ash.Pokemons) != 1 || got.Name != "rattata" {
+			t.Errorf("%s: ash has %v after a refused trade", name, ash.Pokemons)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// newPokeworld creates an empty world with a generated biome map and the default spawn tables.
+// The seed of the settings generates the map and initializes the random number generator used
+// for spawns, captures and battles; clock runs the world's timers.
+func newPokeworld(pokedex []Pokemon, settings WorldConfig, clock Clock) *Pokeworld {
+	pw := &Pokeworld{
+		grid:          make([][]*Pokemon, settings.Width),
+		players:       make(map[net.Conn]*Client),
+		pokedex:       pokedex,
+		Width:         settings.Width,
+		Height:        settings.Height,
+		rng:           newRNG(settings.Seed),
+		clock:         clock,
+		biomes:        generatedBiomes{seed: settings.Seed},
+		spawnTables:   buildSpawnTables(defaultSpawnTables, pokedex),
+		despawnTimers: make(map[uint64]wildTimer),
+		metrics:       spawnMetrics{removed: make(map[string]uint64)},
+		events:        newEventBus(),
+	}
+	for x := range pw.grid {
+		pw.grid[x] = make([]*Pokemon, settings.Height)
+	}
+	pw.configure(settings)
+	return pw
+}
+
+// configure applies the spawn and event settings, which may change while the server runs.
+func (pw *Pokeworld) configure(settings WorldConfig) {
+	pw.Lock()
+	defer pw.Unlock()
+	pw.PokemonSpawnRate = time.Duration(settings.SpawnRate)
+	pw.PokemonDespawnTime = time.Duration(settings.DespawnTime)
+	pw.PokemonPerSpawn = settings.PokemonPerSpawn
+	pw.MaxPokemon = settings.MaxWildPokemon
+	pw.events.configure(settings)
+}
+
+// forkRNG returns a new generator seeded from the world's, for a battle or an AI, so that those
+// are repeatable too without sharing a generator between goroutines.
+func (pw *Pokeworld) forkRNG() RNG {
+	pw.Lock()
+	defer pw.Unlock()
+	return newRNG(pw.rng.Int63())
+}
+
+// randomPosition returns a random tile of the world.
+func (pw *Pokeworld) randomPosition() (x, y int) {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.rng.Intn(pw.Width), pw.rng.Intn(pw.Height)
+}
+
+// wildAt returns the wild Pokémon at (x, y), if any.
+func (pw *Pokeworld) wildAt(x, y int) *Pokemon {
+	pw.Lock()
+	defer pw.Unlock()
+	return pw.grid[x][y]
+}
+
+// cmdMove moves the player one tile and starts an encounter if a wild Pokémon is there.
+func cmdMove(client *Client, args []string, pokedex []Pokemon) {
+	conn := client.conn
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: move <up|down|left|right>")
+		return
+	}
+	if enc := client.encounter; enc != nil {
+		fmt.Fprintf(conn, "The wild %s blocks your way! Type 'fight', 'catch' or 'flee'.\n", enc.wild.Name)
+		return
+	}
+
+	player := client.player
+	player.mutex.Lock()
+	fromX, fromY := player.X, player.Y
+	player.mutex.Unlock()
+	if !movePlayer(player, strings.ToLower(args[0])) {
+		fmt.Fprintln(conn, "You can't move there.")
+		return
+	}
+
+	player.mutex.Lock()
+	x, y := player.X, player.Y
+	player.mutex.Unlock()
+	fmt.Fprintf(conn, "You are at (%d, %d).\n", x, y)
+	pokeworld.events.moveTo(client.events, x, y)
+	pokeworld.events.publish(WorldEvent{Kind: eventMove, X: x, Y: y, FromX: fromX, FromY: fromY,
+		Player: client.account.Username, player: player})
+
+	// Check for Pokemon encounter
+	if wild := pokeworld.wildAt(x, y); wild != nil {
+		startEncounter(client, wild, x, y)
+	} else if isPokemonCenter(x, y) {
+		visitPokemonCenter(client)
+	} else if key := pokeworld.findItem(); key != "" {
+		giveItem(client, key, "You found a %s!")
+	}
+}
+
+// cmdWhere shows the player's position.
+func cmdWhere(client *Client, args []string, pokedex []Pokemon) {
+	player := client.player
+	player.mutex.Lock()
+	defer player.mutex.Unlock()
+	fmt.Fprintf(client.conn, "You are at (%d, %d), in the %s.\n", player.X, player.Y, pokeworld.biomes.biomeAt(player.X, player.Y))
+	if isPokemonCenter(player.X, player.Y) {
+		fmt.Fprintln(client.conn, "You are at a Pokémon Center.")
+	} else if cx, cy, ok := nearestPokemonCenter(player.X, player.Y); ok {
+		fmt.Fprintf(client.conn, "The nearest Pokémon Center is at (%d, %d).\n", cx, cy)
+	}
+}